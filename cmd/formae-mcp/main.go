@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/platform-engineering-labs/formae-mcp/internal/server"
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 	"github.com/platform-engineering-labs/formae-mcp/internal/version"
 )
 
@@ -21,8 +25,15 @@ Usage:
   formae-mcp [flags]
 
 Flags:
-  -h, --help       Show this help message and exit
-  -V, --version    Print the version and exit
+      --http ADDR     Serve the MCP streamable-HTTP transport at /mcp on ADDR
+                      instead of stdio; /healthz reports liveness. :8080 binds
+                      loopback only; use 0.0.0.0:8080 to accept remote clients
+      --read-only     Register only read-only tools: no apply, destroy, cancel,
+                      force_*, profile or policy changes
+      --tools=LIST    Comma-separated tool name patterns to register ("list_*,get_*");
+                      prefix a pattern with - to leave tools out ("-destroy_forma")
+  -h, --help          Show this help message and exit
+  -V, --version       Print the version and exit
 `
 
 // tryHelp handles the --help flag. If args contains an exact --help (-help or
@@ -51,6 +62,63 @@ func tryVersion(args []string, version string, stdout io.Writer) bool {
 	return false
 }
 
+// parseHTTPAddr handles the --http flag. It returns the listen address given as
+// --http ADDR or --http=ADDR (single-dash forms accepted too), or "" when the
+// flag is absent and the server should speak stdio.
+func parseHTTPAddr(args []string) (string, error) {
+	for i, arg := range args {
+		switch {
+		case arg == "--http" || arg == "-http":
+			if i+1 >= len(args) || args[i+1] == "" || strings.HasPrefix(args[i+1], "-") {
+				return "", fmt.Errorf("%s requires a listen address, e.g. --http :8080", arg)
+			}
+			return args[i+1], nil
+		case strings.HasPrefix(arg, "--http=") || strings.HasPrefix(arg, "-http="):
+			addr := arg[strings.Index(arg, "=")+1:]
+			if addr == "" {
+				return "", fmt.Errorf("--http requires a listen address, e.g. --http :8080")
+			}
+			return addr, nil
+		}
+	}
+	return "", nil
+}
+
+// parseReadOnly reports whether the --read-only flag (or -read-only) is set.
+func parseReadOnly(args []string) bool {
+	for _, arg := range args {
+		if arg == "--read-only" || arg == "-read-only" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseToolFilter handles the --tools flag, given as --tools LIST or
+// --tools=LIST. The value may start with "-" (a deny pattern), so the next
+// argument is always taken as the value.
+func parseToolFilter(args []string) (server.ToolFilter, error) {
+	for i, arg := range args {
+		var spec string
+		switch {
+		case arg == "--tools" || arg == "-tools":
+			if i+1 >= len(args) || args[i+1] == "" {
+				return server.ToolFilter{}, fmt.Errorf("%s requires a list of tool name patterns, e.g. --tools 'list_*,get_*'", arg)
+			}
+			spec = args[i+1]
+		case strings.HasPrefix(arg, "--tools=") || strings.HasPrefix(arg, "-tools="):
+			spec = arg[strings.Index(arg, "=")+1:]
+			if spec == "" {
+				return server.ToolFilter{}, fmt.Errorf("--tools requires a list of tool name patterns, e.g. --tools 'list_*,get_*'")
+			}
+		default:
+			continue
+		}
+		return server.ParseToolFilter(spec)
+	}
+	return server.ToolFilter{}, nil
+}
+
 func main() {
 	if tryHelp(os.Args[1:], os.Stdout) {
 		return
@@ -60,11 +128,42 @@ func main() {
 		return
 	}
 
+	httpAddr, err := parseHTTPAddr(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	toolFilter, err := parseToolFilter(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	s := server.New("") // empty: resolve endpoint per call from the active profile
-	if err := s.Run(ctx, &mcp.StdioTransport{}); err != nil {
+	shutdownTelemetry, err := telemetry.Setup(ctx, version.String())
+	if err != nil {
+		log.Fatalf("telemetry: %v", err)
+	}
+
+	// empty endpoint: resolve it per call from the active profile
+	s := server.NewWithOptions("", server.Options{ReadOnly: parseReadOnly(os.Args[1:]), Tools: toolFilter})
+	// Log to stderr as before, and to clients that ask for it with
+	// logging/setLevel.
+	slog.SetDefault(slog.New(s.LogHandler(slog.NewTextHandler(os.Stderr, nil))))
+	if httpAddr != "" {
+		err = s.RunHTTP(ctx, httpAddr)
+	} else {
+		err = s.Run(ctx, &mcp.StdioTransport{})
+	}
+
+	// Flush buffered spans and metrics; ctx is likely cancelled by now.
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if ferr := shutdownTelemetry(flushCtx); ferr != nil {
+		log.Printf("telemetry shutdown: %v", ferr)
+	}
+	if err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
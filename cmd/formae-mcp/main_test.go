@@ -67,3 +67,72 @@ func TestTryHelp_NoFlag(t *testing.T) {
 		}
 	}
 }
+
+func TestParseHTTPAddr(t *testing.T) {
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"something"}, ""},
+		{[]string{"--http", ":8080"}, ":8080"},
+		{[]string{"-http", "127.0.0.1:9000"}, "127.0.0.1:9000"},
+		{[]string{"--http=:8080"}, ":8080"},
+	}
+	for _, c := range cases {
+		got, err := parseHTTPAddr(c.args)
+		if err != nil {
+			t.Errorf("parseHTTPAddr(%q) error: %v", c.args, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseHTTPAddr(%q) = %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestParseHTTPAddr_MissingValue(t *testing.T) {
+	for _, args := range [][]string{{"--http"}, {"--http="}, {"--http", "--version"}} {
+		if _, err := parseHTTPAddr(args); err == nil {
+			t.Errorf("parseHTTPAddr(%q) = nil error, want error", args)
+		}
+	}
+}
+
+func TestParseReadOnly(t *testing.T) {
+	if parseReadOnly([]string{"--http", ":8080"}) {
+		t.Error("parseReadOnly without the flag = true")
+	}
+	for _, arg := range []string{"--read-only", "-read-only"} {
+		if !parseReadOnly([]string{arg}) {
+			t.Errorf("parseReadOnly([%q]) = false", arg)
+		}
+	}
+}
+
+func TestParseToolFilter(t *testing.T) {
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"--tools", "list_*,get_*"}, "list_*,get_*"},
+		{[]string{"--tools", "-destroy_forma"}, "-destroy_forma"},
+		{[]string{"--tools=list_*, -list_profiles"}, "list_*,-list_profiles"},
+	}
+	for _, c := range cases {
+		got, err := parseToolFilter(c.args)
+		if err != nil {
+			t.Errorf("parseToolFilter(%q) error: %v", c.args, err)
+			continue
+		}
+		if got.String() != c.want {
+			t.Errorf("parseToolFilter(%q) = %q, want %q", c.args, got, c.want)
+		}
+	}
+	for _, args := range [][]string{{"--tools"}, {"--tools="}, {"--tools", "list_["}} {
+		if _, err := parseToolFilter(args); err == nil {
+			t.Errorf("parseToolFilter(%q) = nil error, want error", args)
+		}
+	}
+}
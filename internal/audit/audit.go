@@ -0,0 +1,187 @@
+// Package audit is the append-only record of the mutating tool calls
+// formae-mcp serves: one JSON object per line, written when the call returns,
+// whatever its outcome.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+)
+
+// FileName is the audit log in the formae config dir.
+const FileName = "mcp-audit.jsonl"
+
+// Outcomes of an audited call.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeError    = "error"
+	OutcomeDeclined = "declined"
+)
+
+// Entry is one audited tool call.
+type Entry struct {
+	Time          time.Time      `json:"time"`
+	SessionID     string         `json:"session_id,omitempty"`
+	ClientName    string         `json:"client_name,omitempty"`
+	ClientVersion string         `json:"client_version,omitempty"`
+	Tool          string         `json:"tool"`
+	Arguments     map[string]any `json:"arguments,omitempty" jsonschema:"The call's arguments, with file contents and credentials redacted."`
+	Profile       string         `json:"profile,omitempty" jsonschema:"The formae profile the call resolved to, empty for the default agent or a forced endpoint."`
+	Endpoint      string         `json:"endpoint,omitempty" jsonschema:"The agent URL the call reached."`
+	FormaHash     string         `json:"forma_hash,omitempty" jsonschema:"sha256 of the evaluated forma JSON that was submitted."`
+	CommandIDs    []string       `json:"command_ids,omitempty" jsonschema:"IDs of the commands the agent created or cancelled."`
+	Outcome       string         `json:"outcome" jsonschema:"success, error, or declined (the user refused the confirmation)."`
+	Error         string         `json:"error,omitempty"`
+	DurationMS    int64          `json:"duration_ms"`
+}
+
+// Path returns the audit log: $FORMAE_MCP_AUDIT_LOG, else FileName in the
+// formae config dir.
+func Path() (string, error) {
+	if p := os.Getenv("FORMAE_MCP_AUDIT_LOG"); p != "" {
+		return filepath.Abs(p)
+	}
+	dir, err := profile.ResolveConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// mu serializes appends from concurrent calls, so lines never interleave.
+var mu sync.Mutex
+
+// Append writes e as one line at the end of the log, creating it (readable
+// by the owner only) if needed.
+func Append(e Entry) error {
+	p, err := Path()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return fmt.Errorf("create audit log dir: %w", err)
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write audit log %s: %w", p, err)
+	}
+	return f.Close()
+}
+
+// Filter selects entries from the log. Zero fields match everything.
+type Filter struct {
+	Tool      string
+	SessionID string
+	Outcome   string
+	Since     time.Time
+	Limit     int
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Tool == "" || e.Tool == f.Tool) &&
+		(f.SessionID == "" || e.SessionID == f.SessionID) &&
+		(f.Outcome == "" || e.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since))
+}
+
+// Read returns the entries f selects, newest first, at most f.Limit of them
+// when it is positive. A missing log has no entries; lines that do not parse
+// (e.g. one cut short by a crash) are skipped.
+func Read(f Filter) ([]Entry, error) {
+	p, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Tool == "" {
+			continue
+		}
+		if f.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log %s: %w", p, err)
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// redactedArguments are replaced by their size: file contents can be large
+// and may hold credentials.
+var redactedArguments = []string{"content"}
+
+// SanitizeArguments decodes raw tool arguments for the log, replacing file
+// contents and anything that looks like a credential. Arguments that are not
+// a JSON object are dropped.
+func SanitizeArguments(raw json.RawMessage) map[string]any {
+	var args map[string]any
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil
+	}
+	for k, v := range args {
+		switch {
+		case slices.Contains(redactedArguments, k):
+			if s, ok := v.(string); ok {
+				args[k] = fmt.Sprintf("<redacted %d bytes>", len(s))
+			} else {
+				args[k] = "<redacted>"
+			}
+		case isSecretKey(k):
+			args[k] = "<redacted>"
+		}
+	}
+	return args
+}
+
+// isSecretKey reports whether an argument name suggests a credential. The
+// plan_token issued by a simulation only binds an apply to its plan, so it is
+// kept for traceability.
+func isSecretKey(k string) bool {
+	if k == "plan_token" {
+		return false
+	}
+	k = strings.ToLower(k)
+	for _, s := range []string{"token", "secret", "password", "credential", "api_key", "apikey"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withLog(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "nested", FileName)
+	t.Setenv("FORMAE_MCP_AUDIT_LOG", p)
+	return p
+}
+
+func TestReadMissingLogIsEmpty(t *testing.T) {
+	withLog(t)
+	entries, err := Read(Filter{})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("got %v, %v; want no entries", entries, err)
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	p := withLog(t)
+	base := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	for i, e := range []Entry{
+		{Time: base, Tool: "apply_forma", SessionID: "s1", Outcome: OutcomeSuccess},
+		{Time: base.Add(time.Hour), Tool: "destroy_forma", SessionID: "s1", Outcome: OutcomeDeclined},
+		{Time: base.Add(2 * time.Hour), Tool: "apply_forma", SessionID: "s2", Outcome: OutcomeError},
+	} {
+		if err := Append(e); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	// A line cut short by a crash must not hide the rest of the log.
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"time":"2026-01-02T18:00:00Z","tool":"ap` + "\n")
+	f.Close()
+
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("log permissions %o, want 600", perm)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string // sessions, newest first
+	}{
+		{"all", Filter{}, []string{"s2", "s1", "s1"}},
+		{"tool", Filter{Tool: "apply_forma"}, []string{"s2", "s1"}},
+		{"session", Filter{SessionID: "s1"}, []string{"s1", "s1"}},
+		{"outcome", Filter{Outcome: OutcomeDeclined}, []string{"s1"}},
+		{"since", Filter{Since: base.Add(90 * time.Minute)}, []string{"s2"}},
+		{"limit keeps newest", Filter{Limit: 1}, []string{"s2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Read(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.SessionID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeArguments(t *testing.T) {
+	args := SanitizeArguments(json.RawMessage(`{"name":"prod","content":"amends \"x\"","plan_token":"p-1","api_token":"s3cret","Password":"pw"}`))
+	want := map[string]any{
+		"name":       "prod",
+		"content":    "<redacted 10 bytes>",
+		"plan_token": "p-1",
+		"api_token":  "<redacted>",
+		"Password":   "<redacted>",
+	}
+	for k, v := range want {
+		if args[k] != v {
+			t.Errorf("%s = %v, want %v", k, args[k], v)
+		}
+	}
+	if SanitizeArguments(json.RawMessage(`[1]`)) != nil {
+		t.Error("want nil for non-object arguments")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -18,16 +20,58 @@ const (
 )
 
 // deprecatedEnvWarnOnce ensures the FORMAE_AGENT_URL/PORT deprecation warning is
-// emitted at most once per process, since AgentEndpoint runs per MCP tool call.
+// emitted at most once per process, since AgentAPI runs per MCP tool call.
 var deprecatedEnvWarnOnce sync.Once
 
+// APIAuth is the authentication and TLS configuration of a profile's cli.api
+// block. Relative file paths are resolved against the profile's directory.
+type APIAuth struct {
+	// Token is a static bearer token.
+	Token string
+	// TokenCommand is a shell command whose trimmed stdout is the bearer
+	// token; it is used when Token is empty.
+	TokenCommand string
+	// CABundle is a PEM file of CAs trusted for the agent's certificate.
+	CABundle string
+	// ClientCert and ClientKey are the PEM client certificate and key
+	// presented for mTLS.
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables verification of the agent's certificate.
+	InsecureSkipVerify bool
+}
+
+// IsZero reports whether no auth or TLS setting is configured.
+func (a APIAuth) IsZero() bool {
+	return a == APIAuth{}
+}
+
+// API is a resolved agent endpoint plus the auth settings to reach it.
+type API struct {
+	URL  string
+	Port string
+	Auth APIAuth
+}
+
 // AgentEndpoint resolves the formae agent endpoint for an optional profile.
-// Precedence: a profile always wins. An explicit requested profile is resolved
-// first, else the active pointer; either resolving-but-unparseable is a hard
-// error, never a silent fallback. Only when no profile is configured at all do
-// the deprecated FORMAE_AGENT_URL/FORMAE_AGENT_PORT env vars apply, falling back
-// to the localhost default for whichever endpoint field they leave unset.
+// See AgentAPI for the precedence rules.
 func AgentEndpoint(profileName string) (url, port string, err error) {
+	api, err := AgentAPI(profileName)
+	if err != nil {
+		return "", "", err
+	}
+	return api.URL, api.Port, nil
+}
+
+// AgentAPI resolves the formae agent endpoint and auth settings for an
+// optional profile. Precedence: a profile always wins. An explicit requested
+// profile is resolved first, else the active pointer; either
+// resolving-but-unparseable is a hard error, never a silent fallback. Only when
+// no profile is configured at all do the deprecated
+// FORMAE_AGENT_URL/FORMAE_AGENT_PORT env vars apply, falling back to the
+// localhost default for whichever endpoint field they leave unset. Auth
+// settings only ever come from a profile.
+func AgentAPI(profileName string) (API, error) {
 	envURL := os.Getenv("FORMAE_AGENT_URL")
 	envPort := os.Getenv("FORMAE_AGENT_PORT")
 	if envURL != "" || envPort != "" {
@@ -37,61 +81,241 @@ func AgentEndpoint(profileName string) (url, port string, err error) {
 		})
 	}
 
+	var api API
+	var err error
 	switch {
 	case profileName != "":
-		url, port, err = endpointFromProfile(profileName)
+		api, err = apiFromProfile(profileName)
 	default:
 		active, aerr := profile.ActiveProfile()
 		if aerr == nil {
-			url, port, err = endpointFromProfile(active)
+			api, err = apiFromProfile(active)
 		} else if !errors.Is(aerr, profile.ErrNotInitialized) {
-			return "", "", aerr
+			return API{}, aerr
 		} else {
 			// genuinely unconfigured: deprecated env fallback below
-			url, port = envURL, envPort
+			api.URL, api.Port = envURL, envPort
 		}
 	}
 	if err != nil {
-		return "", "", err
+		return API{}, err
 	}
 
 	// Fill absent endpoint fields from the localhost default (never from env,
 	// which is only consulted when no profile is configured at all).
-	if url == "" {
-		url = defaultURL
+	if api.URL == "" {
+		api.URL = defaultURL
 	}
-	if port == "" {
-		port = defaultPort
+	if api.Port == "" {
+		api.Port = defaultPort
 	}
-	return url, port, nil
+	return api, nil
 }
 
-// endpointFromProfile reads a profile's PKL and extracts its cli.api endpoint.
-// A profile that exists but yields neither url nor port is a hard error.
-func endpointFromProfile(name string) (url, port string, err error) {
+// apiFromProfile reads a profile's PKL and extracts its cli.api endpoint and
+// auth settings. A profile that exists but yields neither url nor port is a
+// hard error.
+func apiFromProfile(name string) (API, error) {
 	path, err := profile.ProfilePath(name)
 	if err != nil {
-		return "", "", err
+		return API{}, err
 	}
 	data, rerr := os.ReadFile(path)
 	if rerr != nil {
-		return "", "", fmt.Errorf("profile %q not found: %w", name, rerr)
+		return API{}, fmt.Errorf("profile %q not found: %w", name, rerr)
 	}
-	url, port = parseCliAPI(string(data))
-	if url == "" && port == "" {
-		return "", "", fmt.Errorf("profile %q has no resolvable cli.api endpoint", name)
+	var api API
+	api.URL, api.Port = parseCliAPI(string(data))
+	if api.URL == "" && api.Port == "" {
+		return API{}, fmt.Errorf("profile %q has no resolvable cli.api endpoint", name)
 	}
-	return url, port, nil
+	api.Auth = parseCliAPIAuth(string(data))
+	dir := filepath.Dir(path)
+	api.Auth.CABundle = resolvePath(dir, api.Auth.CABundle)
+	api.Auth.ClientCert = resolvePath(dir, api.Auth.ClientCert)
+	api.Auth.ClientKey = resolvePath(dir, api.Auth.ClientKey)
+	return api, nil
+}
+
+// resolvePath expands a leading ~/ and makes a relative path absolute against
+// dir. Empty stays empty.
+func resolvePath(dir, p string) string {
+	if p == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
 }
 
 var (
 	urlPattern  = regexp.MustCompile(`url\s*=\s*"([^"]+)"`)
 	portPattern = regexp.MustCompile(`port\s*=\s*(\d+)`)
+
+	tokenPattern        = regexp.MustCompile(`\btoken\s*=\s*"([^"]+)"`)
+	tokenCommandPattern = regexp.MustCompile(`\btokenCommand\s*=\s*"((?:[^"\\]|\\.)+)"`)
+	caBundlePattern     = regexp.MustCompile(`\bcaBundle\s*=\s*"([^"]+)"`)
+	clientCertPattern   = regexp.MustCompile(`\bclientCert\s*=\s*"([^"]+)"`)
+	clientKeyPattern    = regexp.MustCompile(`\bclientKey\s*=\s*"([^"]+)"`)
+	insecurePattern     = regexp.MustCompile(`\binsecureSkipVerify\s*=\s*(true|false)`)
+)
+
+// RedactedToken stands in for token values in profile content shown to the
+// model. PrepareProfileWrite puts the real values back.
+const RedactedToken = "<redacted>"
+
+var (
+	// anyTokenPattern and anyTokenCommandPattern match the settings anywhere
+	// in a file (agent.api has a token too), in comments included.
+	anyTokenPattern        = regexp.MustCompile(`(\btoken\s*=\s*)"((?:[^"\\]|\\.)*)"`)
+	anyTokenCommandPattern = regexp.MustCompile(`\btokenCommand\s*=\s*"((?:[^"\\]|\\.)*)"`)
 )
 
+// RedactTokens replaces the value of every token = "..." in PKL content with
+// RedactedToken, so bearer secrets are not handed to the model.
+func RedactTokens(content string) string {
+	return anyTokenPattern.ReplaceAllString(content, `${1}"`+RedactedToken+`"`)
+}
+
+// PrepareProfileWrite vets profile content written on the model's behalf
+// over a profile whose current content is current, and returns what to write.
+// Token values left as RedactedToken are restored from current, matched by
+// position. Only the user may, by editing the file directly:
+//   - set a tokenCommand current does not already contain, since it is run
+//     through the shell on the next agent request;
+//   - change the cli.api TLS settings (caBundle, clientCert, clientKey,
+//     insecureSkipVerify), which decide whom the credentials are sent to;
+//   - change the cli.api url or port while the profile keeps credentials from
+//     current (a redacted token, a tokenCommand or a client certificate),
+//     which would send them to another host.
+func PrepareProfileWrite(content, current string) (string, error) {
+	if err := checkCliAPIChange(content, current); err != nil {
+		return "", err
+	}
+	known := map[string]bool{}
+	for _, m := range anyTokenCommandPattern.FindAllStringSubmatch(current, -1) {
+		known[m[1]] = true
+	}
+	for _, m := range anyTokenCommandPattern.FindAllStringSubmatch(content, -1) {
+		if !known[m[1]] {
+			return "", fmt.Errorf("tokenCommand %q is not in the current profile; "+
+				"tokenCommand runs as a shell command, so only the user can add or change it, by editing the profile file directly", m[1])
+		}
+	}
+
+	if !strings.Contains(content, RedactedToken) {
+		return content, nil
+	}
+	currentTokens := anyTokenPattern.FindAllStringSubmatch(current, -1)
+	newTokens := anyTokenPattern.FindAllStringSubmatch(content, -1)
+	if len(currentTokens) != len(newTokens) {
+		return "", fmt.Errorf("cannot restore %s token values: the content has %d token settings and the current profile %d; "+
+			"keep the token lines as read_profile returned them", RedactedToken, len(newTokens), len(currentTokens))
+	}
+	i := 0
+	return anyTokenPattern.ReplaceAllStringFunc(content, func(match string) string {
+		m := anyTokenPattern.FindStringSubmatch(match)
+		orig := currentTokens[i]
+		i++
+		if m[2] != RedactedToken {
+			return match
+		}
+		return m[1] + `"` + orig[2] + `"`
+	}), nil
+}
+
+// checkCliAPIChange refuses changes to the cli.api endpoint and TLS settings
+// that only the user may make; see PrepareProfileWrite.
+func checkCliAPIChange(content, current string) error {
+	was, now := parseCliAPIAuth(current), parseCliAPIAuth(content)
+	for _, s := range []struct{ name, was, now string }{
+		{"caBundle", was.CABundle, now.CABundle},
+		{"clientCert", was.ClientCert, now.ClientCert},
+		{"clientKey", was.ClientKey, now.ClientKey},
+		{"insecureSkipVerify", strconv.FormatBool(was.InsecureSkipVerify), strconv.FormatBool(now.InsecureSkipVerify)},
+	} {
+		if s.was != s.now {
+			return fmt.Errorf("cli.api %s differs from the current profile; TLS settings decide where the agent credentials "+
+				"are sent, so only the user can change them, by editing the profile file directly", s.name)
+		}
+	}
+
+	wasURL, wasPort := parseCliAPI(current)
+	nowURL, nowPort := parseCliAPI(content)
+	if wasURL == nowURL && wasPort == nowPort {
+		return nil
+	}
+	if now.Token == RedactedToken || now.TokenCommand != "" || now.ClientCert != "" {
+		return fmt.Errorf("cli.api endpoint changes from %s to %s while the profile keeps its credentials, which would "+
+			"send them to the new host; only the user can move them, by editing the profile file directly "+
+			"(or write the new endpoint without the credentials)", endpointLabel(wasURL, wasPort), endpointLabel(nowURL, nowPort))
+	}
+	return nil
+}
+
+// endpointLabel renders a cli.api url and optional port for messages.
+func endpointLabel(url, port string) string {
+	if port == "" {
+		return url
+	}
+	return url + " port " + port
+}
+
 // parseCliAPI extracts url and port from within the cli { api { ... } } block
-// in a PKL config file. Uses simple brace-depth tracking.
+// in a PKL config file.
 func parseCliAPI(content string) (url, port string) {
+	scanCliAPI(content, func(line string) {
+		if m := urlPattern.FindStringSubmatch(line); len(m) > 1 {
+			url = m[1]
+		}
+		if m := portPattern.FindStringSubmatch(line); len(m) > 1 {
+			port = m[1]
+		}
+	})
+	return url, port
+}
+
+// parseCliAPIAuth extracts the auth and TLS settings from within the
+// cli { api { ... } } block in a PKL config file.
+func parseCliAPIAuth(content string) APIAuth {
+	var auth APIAuth
+	scanCliAPI(content, func(line string) {
+		if m := tokenPattern.FindStringSubmatch(line); len(m) > 1 {
+			auth.Token = m[1]
+		}
+		if m := tokenCommandPattern.FindStringSubmatch(line); len(m) > 1 {
+			// Commands often need quoting; undo PKL's \" and \\ escapes.
+			auth.TokenCommand = m[1]
+			if unquoted, err := strconv.Unquote(`"` + m[1] + `"`); err == nil {
+				auth.TokenCommand = unquoted
+			}
+		}
+		if m := caBundlePattern.FindStringSubmatch(line); len(m) > 1 {
+			auth.CABundle = m[1]
+		}
+		if m := clientCertPattern.FindStringSubmatch(line); len(m) > 1 {
+			auth.ClientCert = m[1]
+		}
+		if m := clientKeyPattern.FindStringSubmatch(line); len(m) > 1 {
+			auth.ClientKey = m[1]
+		}
+		if m := insecurePattern.FindStringSubmatch(line); len(m) > 1 {
+			auth.InsecureSkipVerify = m[1] == "true"
+		}
+	})
+	return auth
+}
+
+// scanCliAPI calls fn with every trimmed, non-comment line that lies inside
+// the cli { api { ... } } block of a PKL config file. Uses simple brace-depth
+// tracking.
+func scanCliAPI(content string, fn func(line string)) {
 	lines := strings.Split(content, "\n")
 
 	inCli := false
@@ -135,12 +359,7 @@ func parseCliAPI(content string) (url, port string) {
 
 		// Extract values only when inside cli.api block
 		if inCli && inAPI {
-			if m := urlPattern.FindStringSubmatch(trimmed); len(m) > 1 {
-				url = m[1]
-			}
-			if m := portPattern.FindStringSubmatch(trimmed); len(m) > 1 {
-				port = m[1]
-			}
+			fn(trimmed)
 		}
 
 		// Track braces for this line
@@ -168,6 +387,4 @@ func parseCliAPI(content string) (url, port string) {
 			}
 		}
 	}
-
-	return url, port
 }
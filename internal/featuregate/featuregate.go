@@ -1,12 +1,15 @@
 package featuregate
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 )
 
 // Feature names a version-gated MCP capability.
@@ -92,7 +95,9 @@ func GuardFeature(f Feature) error {
 }
 
 func detectFromCLI() (string, error) {
-	out, err := exec.Command("formae", "--version").CombinedOutput()
+	ctx, span := telemetry.StartSubprocess(context.Background(), "--version")
+	out, err := exec.CommandContext(ctx, "formae", "--version").CombinedOutput()
+	telemetry.End(ctx, span, telemetry.ErrSubprocess, err)
 	if err != nil {
 		return "", fmt.Errorf("formae --version failed: %w (output: %s)", err, string(out))
 	}
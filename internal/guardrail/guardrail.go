@@ -0,0 +1,158 @@
+// Package guardrail is the server-side safety policy for destructive
+// operations: profiles, stacks and resource labels that formae-mcp refuses to
+// force-apply, destroy or force-reconcile unless the call carries an explicit
+// override the policy allows.
+package guardrail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+)
+
+// FileName is the guardrail config file in the formae config dir.
+const FileName = "mcp-guardrails.json"
+
+// Policy lists what is protected. Stack and label entries are glob patterns
+// (path.Match syntax, e.g. "prod-*"); profile entries are exact names.
+type Policy struct {
+	ProtectedProfiles []string `json:"protectedProfiles"`
+	ProtectedStacks   []string `json:"protectedStacks"`
+	ProtectedLabels   []string `json:"protectedLabels"`
+	// AllowOverride lets a call that passes override_protection=true go
+	// ahead. Without it, protection can only be lifted by editing the file.
+	AllowOverride bool `json:"allowOverride"`
+
+	// Path is the file the policy was loaded from, for error messages.
+	Path string `json:"-"`
+}
+
+// Subject is a stack, or a resource in a stack, an operation touches. A
+// subject with no Label stands for the whole stack.
+type Subject struct {
+	Stack string
+	Label string
+}
+
+// Scope is everything a guarded operation would touch. Unresolved marks a
+// scope whose subjects could not be fully determined, e.g. a destroy query
+// the agent failed to simulate.
+type Scope struct {
+	Profile    string
+	Subjects   []Subject
+	Unresolved bool
+}
+
+// Path returns the guardrail config file: $FORMAE_MCP_GUARDRAILS, else
+// FileName in the formae config dir.
+func Path() (string, error) {
+	if p := os.Getenv("FORMAE_MCP_GUARDRAILS"); p != "" {
+		return filepath.Abs(p)
+	}
+	dir, err := profile.ResolveConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Load reads the guardrail config. A missing file is an empty policy that
+// protects nothing; a malformed one is an error, so a typo never silently
+// disables protection.
+func Load() (Policy, error) {
+	p, err := Path()
+	if err != nil {
+		return Policy{}, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Policy{Path: p}, nil
+		}
+		return Policy{}, fmt.Errorf("read guardrails %s: %w", p, err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, fmt.Errorf("parse guardrails %s: %w", p, err)
+	}
+	for _, pattern := range slices.Concat(policy.ProtectedStacks, policy.ProtectedLabels) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Policy{}, fmt.Errorf("guardrails %s: bad pattern %q: %w", p, pattern, err)
+		}
+	}
+	policy.Path = p
+	return policy, nil
+}
+
+// Active reports whether the policy protects anything.
+func (p Policy) Active() bool {
+	return len(p.ProtectedProfiles) > 0 || len(p.ProtectedStacks) > 0 || len(p.ProtectedLabels) > 0
+}
+
+// Check returns a *Violation when the operation would touch something
+// protected, and nil otherwise or when override is set and allowed. An
+// unresolved scope violates any stack or label protection, since what it
+// touches cannot be ruled out.
+func (p Policy) Check(operation string, scope Scope, override bool) error {
+	v := &Violation{Operation: operation, Overridable: p.AllowOverride, Path: p.Path}
+	if scope.Profile != "" && slices.Contains(p.ProtectedProfiles, scope.Profile) {
+		v.Reasons = append(v.Reasons, fmt.Sprintf("profile %q is protected", scope.Profile))
+	}
+	seen := map[string]bool{}
+	add := func(reason string) {
+		if !seen[reason] {
+			seen[reason] = true
+			v.Reasons = append(v.Reasons, reason)
+		}
+	}
+	for _, s := range scope.Subjects {
+		if pattern, ok := matchAny(p.ProtectedStacks, s.Stack); ok {
+			add(fmt.Sprintf("stack %q matches protected pattern %q", s.Stack, pattern))
+		}
+		if pattern, ok := matchAny(p.ProtectedLabels, s.Label); ok {
+			add(fmt.Sprintf("resource %q matches protected pattern %q", s.Label, pattern))
+		}
+	}
+	if scope.Unresolved && (len(p.ProtectedStacks) > 0 || len(p.ProtectedLabels) > 0) {
+		add("the affected stacks and resources could not be determined, so protected ones cannot be ruled out")
+	}
+	if len(v.Reasons) == 0 || (override && p.AllowOverride) {
+		return nil
+	}
+	return v
+}
+
+func matchAny(patterns []string, name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Violation is a guarded operation refused by the policy.
+type Violation struct {
+	Operation   string
+	Reasons     []string
+	Overridable bool
+	Path        string
+}
+
+func (v *Violation) Error() string {
+	msg := fmt.Sprintf("%s refused by guardrails (%s): %s.", v.Operation, v.Path, strings.Join(v.Reasons, "; "))
+	if v.Overridable {
+		return msg + " Only if the user explicitly approves touching protected infrastructure, retry with override_protection=true."
+	}
+	return msg + " Overrides are disabled; the user must change the guardrail config to allow this."
+}
@@ -0,0 +1,89 @@
+package guardrail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "guardrails.json")
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FORMAE_MCP_GUARDRAILS", p)
+	return p
+}
+
+func TestLoadMissingFileProtectsNothing(t *testing.T) {
+	t.Setenv("FORMAE_MCP_GUARDRAILS", filepath.Join(t.TempDir(), "absent.json"))
+	p, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Active() {
+		t.Errorf("expected an inactive policy, got %+v", p)
+	}
+}
+
+func TestLoadRejectsMalformedConfig(t *testing.T) {
+	for name, content := range map[string]string{
+		"json":    `{"protectedStacks": [`,
+		"pattern": `{"protectedStacks": ["prod-["]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			writePolicy(t, content)
+			if _, err := Load(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	writePolicy(t, `{"protectedProfiles":["prod"],"protectedStacks":["prod-*"],"protectedLabels":["*-primary"],"allowOverride":true}`)
+	p, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		scope    Scope
+		override bool
+		want     string // substring of the violation, "" for allowed
+	}{
+		{"unprotected", Scope{Profile: "dev", Subjects: []Subject{{Stack: "dev-db", Label: "db"}}}, false, ""},
+		{"profile", Scope{Profile: "prod"}, false, `profile "prod" is protected`},
+		{"stack", Scope{Subjects: []Subject{{Stack: "prod-db"}}}, false, `stack "prod-db" matches protected pattern "prod-*"`},
+		{"label", Scope{Subjects: []Subject{{Stack: "dev", Label: "db-primary"}}}, false, `resource "db-primary"`},
+		{"unresolved", Scope{Unresolved: true}, false, "could not be determined"},
+		{"override", Scope{Profile: "prod"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check("destroy_forma", tt.scope, tt.override)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected violation: %v", err)
+				}
+				return
+			}
+			var v *Violation
+			if !errors.As(err, &v) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want a violation mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckOverrideDisabled(t *testing.T) {
+	p := Policy{ProtectedProfiles: []string{"prod"}}
+	err := p.Check("force_reconcile_stack", Scope{Profile: "prod"}, true)
+	if err == nil || !strings.Contains(err.Error(), "Overrides are disabled") {
+		t.Errorf("got %v, want a non-overridable violation", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package model holds typed forms of the formae agent's REST API responses.
+//
+// The agent's JSON uses PascalCase field names. Values decoded from the agent
+// keep the exact bytes they were decoded from and re-encode to them, so tools
+// can pass agent responses through without dropping fields this package does
+// not model. Treat decoded values as read-only; build a fresh value to emit
+// something different.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Resource is one resource known to the agent, managed or discovered.
+type Resource struct {
+	Label      string          `json:"Label"`
+	Type       string          `json:"Type"`
+	Stack      string          `json:"Stack,omitempty"`
+	Target     string          `json:"Target,omitempty"`
+	NativeID   string          `json:"NativeID,omitempty"`
+	Managed    bool            `json:"Managed"`
+	Properties json.RawMessage `json:"Properties,omitempty"`
+
+	raw json.RawMessage
+}
+
+// Stack is a named group of managed resources.
+type Stack struct {
+	Label       string `json:"Label"`
+	Description string `json:"Description,omitempty"`
+
+	raw json.RawMessage
+}
+
+// Target is a configured cloud account/region (or other provider endpoint).
+type Target struct {
+	Label        string          `json:"Label"`
+	Namespace    string          `json:"Namespace,omitempty"`
+	Discoverable bool            `json:"Discoverable"`
+	Config       json.RawMessage `json:"Config,omitempty"`
+
+	raw json.RawMessage
+}
+
+// Command is an apply, destroy, sync or discovery run and its progress.
+type Command struct {
+	CommandID       string           `json:"CommandID"`
+	Command         string           `json:"Command"`
+	State           string           `json:"State"`
+	ResourceUpdates []ResourceUpdate `json:"ResourceUpdates,omitempty"`
+
+	raw json.RawMessage
+}
+
+// ResourceUpdate is one resource's operation within a command.
+type ResourceUpdate struct {
+	ResourceLabel string          `json:"ResourceLabel"`
+	ResourceType  string          `json:"ResourceType"`
+	StackName     string          `json:"StackName,omitempty"`
+	Operation     string          `json:"Operation"`
+	State         string          `json:"State"`
+	ErrorMessage  string          `json:"ErrorMessage,omitempty"`
+	Properties    json.RawMessage `json:"Properties,omitempty"`
+
+	raw json.RawMessage
+}
+
+// Policy is a standalone (reusable) TTL or auto-reconcile policy. Config is
+// the marshalled domain policy:
+// TTL     -> {"Type":"ttl","Label":"...","TTLSeconds":3600,"OnDependents":"abort"}
+// AutoRec -> {"Type":"auto-reconcile","Label":"...","IntervalSeconds":300}
+type Policy struct {
+	Label          string          `json:"Label"`
+	Type           string          `json:"Type"`
+	Config         json.RawMessage `json:"Config,omitempty"`
+	AttachedStacks []string        `json:"AttachedStacks"`
+
+	raw json.RawMessage
+}
+
+// Stats is the agent's overview: its version, resource counts by provider
+// (e.g. "AWS"), command counts by state, and loaded plugins.
+type Stats struct {
+	Version            string         `json:"Version"`
+	ManagedResources   map[string]int `json:"ManagedResources,omitempty"`
+	UnmanagedResources map[string]int `json:"UnmanagedResources,omitempty"`
+	Commands           map[string]int `json:"Commands,omitempty"`
+	Plugins            []string       `json:"Plugins,omitempty"`
+
+	raw json.RawMessage
+}
+
+// ModifiedResource is a resource changed outside formae since its stack was
+// last reconciled.
+type ModifiedResource struct {
+	Stack     string `json:"Stack"`
+	Type      string `json:"Type"`
+	Label     string `json:"Label"`
+	Operation string `json:"Operation"`
+
+	raw json.RawMessage
+}
+
+// StackChanges is a stack's changes since its last reconcile.
+type StackChanges struct {
+	ModifiedResources []ModifiedResource `json:"ModifiedResources"`
+
+	raw json.RawMessage
+}
+
+// CommandList is a command status response. The agent wraps results as
+// {"Commands":[...]}; a bare command object decodes as a one-element list.
+type CommandList struct {
+	Commands []Command `json:"Commands"`
+
+	raw json.RawMessage
+	// bare is set when the agent answered with a single command object
+	// rather than the wrapped list.
+	bare bool
+}
+
+// Find returns the command with the given ID. The only other answer it
+// accepts is a bare command object without a CommandID, which the agent
+// sends for the one command it was asked about.
+func (l CommandList) Find(id string) (Command, bool) {
+	for _, c := range l.Commands {
+		if c.CommandID == id {
+			return c, true
+		}
+	}
+	if l.bare && len(l.Commands) == 1 && l.Commands[0].CommandID == "" {
+		return l.Commands[0], true
+	}
+	return Command{}, false
+}
+
+// Canonical returns r without the agent's raw bytes, so it marshals exactly
+// the modelled fields. Structured tool output uses this form: it always
+// matches the schema inferred from the type.
+func (r Resource) Canonical() Resource {
+	r.raw = nil
+	return r
+}
+
+// Canonical returns s without the agent's raw bytes.
+func (s Stack) Canonical() Stack {
+	s.raw = nil
+	return s
+}
+
+// Canonical returns t without the agent's raw bytes.
+func (t Target) Canonical() Target {
+	t.raw = nil
+	return t
+}
+
+// Canonical returns c and its resource updates without the agent's raw bytes.
+func (c Command) Canonical() Command {
+	c.raw = nil
+	if c.ResourceUpdates != nil {
+		c.ResourceUpdates = CanonicalList(c.ResourceUpdates)
+	}
+	return c
+}
+
+// Canonical returns u without the agent's raw bytes.
+func (u ResourceUpdate) Canonical() ResourceUpdate {
+	u.raw = nil
+	return u
+}
+
+// Canonical returns p without the agent's raw bytes. AttachedStacks is never
+// nil, so it encodes as a list.
+func (p Policy) Canonical() Policy {
+	p.raw = nil
+	if p.AttachedStacks == nil {
+		p.AttachedStacks = []string{}
+	}
+	return p
+}
+
+// Canonical returns s without the agent's raw bytes.
+func (s Stats) Canonical() Stats {
+	s.raw = nil
+	return s
+}
+
+// CanonicalList applies Canonical to every element. The result is never nil.
+func CanonicalList[T interface{ Canonical() T }](list []T) []T {
+	out := make([]T, len(list))
+	for i, v := range list {
+		out[i] = v.Canonical()
+	}
+	return out
+}
+
+// decode unmarshals data into v, tolerating fields whose JSON type differs
+// from the model (they are left zero: the agent's schema evolves
+// independently), and records data as the value's raw form.
+func decode(data []byte, v any, raw *json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Field == "" {
+			return err
+		}
+	}
+	*raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// encode returns raw when the value was decoded from the agent, otherwise
+// the marshalled fields.
+func encode(raw json.RawMessage, v any) ([]byte, error) {
+	if raw != nil {
+		return raw, nil
+	}
+	return json.Marshal(v)
+}
+
+type (
+	resourceFields         Resource
+	stackFields            Stack
+	targetFields           Target
+	commandFields          Command
+	resourceUpdateFields   ResourceUpdate
+	policyFields           Policy
+	statsFields            Stats
+	modifiedResourceFields ModifiedResource
+	stackChangesFields     StackChanges
+)
+
+func (r *Resource) UnmarshalJSON(data []byte) error {
+	return decode(data, (*resourceFields)(r), &r.raw)
+}
+
+func (r Resource) MarshalJSON() ([]byte, error) { return encode(r.raw, resourceFields(r)) }
+
+func (s *Stack) UnmarshalJSON(data []byte) error {
+	return decode(data, (*stackFields)(s), &s.raw)
+}
+
+func (s Stack) MarshalJSON() ([]byte, error) { return encode(s.raw, stackFields(s)) }
+
+func (t *Target) UnmarshalJSON(data []byte) error {
+	return decode(data, (*targetFields)(t), &t.raw)
+}
+
+func (t Target) MarshalJSON() ([]byte, error) { return encode(t.raw, targetFields(t)) }
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	return decode(data, (*commandFields)(c), &c.raw)
+}
+
+func (c Command) MarshalJSON() ([]byte, error) { return encode(c.raw, commandFields(c)) }
+
+func (u *ResourceUpdate) UnmarshalJSON(data []byte) error {
+	return decode(data, (*resourceUpdateFields)(u), &u.raw)
+}
+
+func (u ResourceUpdate) MarshalJSON() ([]byte, error) {
+	return encode(u.raw, resourceUpdateFields(u))
+}
+
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	return decode(data, (*policyFields)(p), &p.raw)
+}
+
+func (p Policy) MarshalJSON() ([]byte, error) { return encode(p.raw, policyFields(p)) }
+
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	return decode(data, (*statsFields)(s), &s.raw)
+}
+
+func (s Stats) MarshalJSON() ([]byte, error) { return encode(s.raw, statsFields(s)) }
+
+func (m *ModifiedResource) UnmarshalJSON(data []byte) error {
+	return decode(data, (*modifiedResourceFields)(m), &m.raw)
+}
+
+func (m ModifiedResource) MarshalJSON() ([]byte, error) {
+	return encode(m.raw, modifiedResourceFields(m))
+}
+
+func (s *StackChanges) UnmarshalJSON(data []byte) error {
+	return decode(data, (*stackChangesFields)(s), &s.raw)
+}
+
+func (s StackChanges) MarshalJSON() ([]byte, error) { return encode(s.raw, stackChangesFields(s)) }
+
+func (l *CommandList) UnmarshalJSON(data []byte) error {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	if _, wrapped := probe["Commands"]; wrapped {
+		var fields struct {
+			Commands []Command `json:"Commands"`
+		}
+		if err := decode(data, &fields, &l.raw); err != nil {
+			return err
+		}
+		l.Commands = fields.Commands
+		return nil
+	}
+	var c Command
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	l.Commands = []Command{c}
+	l.raw = append(json.RawMessage(nil), data...)
+	l.bare = true
+	return nil
+}
+
+func (l CommandList) MarshalJSON() ([]byte, error) {
+	if l.raw != nil {
+		return l.raw, nil
+	}
+	commands := l.Commands
+	if commands == nil {
+		commands = []Command{}
+	}
+	return json.Marshal(struct {
+		Commands []Command `json:"Commands"`
+	}{commands})
+}
+
+// DecodeList decodes a listing the agent sends either as a bare array or
+// wrapped in an object under key (e.g. {"Resources":[...]}). The result is
+// never nil.
+func DecodeList[T any](body []byte, key string) ([]T, error) {
+	list := []T{}
+	if err := json.Unmarshal(body, &list); err == nil {
+		if list == nil {
+			list = []T{}
+		}
+		return list, nil
+	}
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", key, err)
+	}
+	inner, ok := wrapped[key]
+	if !ok {
+		return nil, fmt.Errorf("parse %s: response has neither a list nor a %q field", key, key)
+	}
+	if err := json.Unmarshal(inner, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", key, err)
+	}
+	if list == nil {
+		list = []T{}
+	}
+	return list, nil
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResourceRoundTripKeepsUnmodelledFields(t *testing.T) {
+	in := `{"Label":"b","Type":"AWS::S3::Bucket","Managed":true,"Schema":{"Identifier":"BucketName"}}`
+	var r Resource
+	if err := json.Unmarshal([]byte(in), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Label != "b" || r.Type != "AWS::S3::Bucket" || !r.Managed {
+		t.Errorf("decoded = %+v", r)
+	}
+	out, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("re-encoded = %s, want %s", out, in)
+	}
+}
+
+func TestDecodeToleratesFieldTypeDrift(t *testing.T) {
+	var s Stats
+	if err := json.Unmarshal([]byte(`{"Version":"0.88.0","Plugins":"aws"}`), &s); err != nil {
+		t.Fatalf("type mismatch on one field should not fail the decode: %v", err)
+	}
+	if s.Version != "0.88.0" {
+		t.Errorf("Version = %q", s.Version)
+	}
+	if err := json.Unmarshal([]byte(`"nope"`), &s); err == nil {
+		t.Error("expected error for a non-object stats body")
+	}
+}
+
+func TestBuiltValueMarshalsFields(t *testing.T) {
+	out, err := json.Marshal(Stack{Label: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"Label":"prod"}` {
+		t.Errorf("marshalled = %s", out)
+	}
+}
+
+func TestDecodeList(t *testing.T) {
+	for name, body := range map[string]string{
+		"bare":    `[{"Label":"a"},{"Label":"b"}]`,
+		"wrapped": `{"Stacks":[{"Label":"a"},{"Label":"b"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			stacks, err := DecodeList[Stack]([]byte(body), "Stacks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stacks) != 2 || stacks[1].Label != "b" {
+				t.Errorf("stacks = %+v", stacks)
+			}
+		})
+	}
+	t.Run("null is empty", func(t *testing.T) {
+		stacks, err := DecodeList[Stack]([]byte(`null`), "Stacks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stacks == nil {
+			t.Error("DecodeList returned nil")
+		}
+	})
+	t.Run("missing key", func(t *testing.T) {
+		if _, err := DecodeList[Stack]([]byte(`{"Targets":[]}`), "Stacks"); err == nil {
+			t.Error("expected error when the wrapper key is missing")
+		}
+	})
+}
+
+func TestCommandList(t *testing.T) {
+	t.Run("wrapped", func(t *testing.T) {
+		var l CommandList
+		if err := json.Unmarshal([]byte(`{"Commands":[{"CommandID":"x","State":"Pending"},{"CommandID":"y","State":"Success"}]}`), &l); err != nil {
+			t.Fatal(err)
+		}
+		c, ok := l.Find("y")
+		if !ok || c.State != "Success" {
+			t.Errorf("Find(y) = %+v, %v", c, ok)
+		}
+		if c, ok := l.Find("z"); ok {
+			t.Errorf("Find(z) = %+v, want no match for an ID the list lacks", c)
+		}
+	})
+	t.Run("bare object", func(t *testing.T) {
+		body := `{"CommandID":"x","State":"InProgress","ResourceUpdates":[{"ResourceLabel":"b","State":"Success"}]}`
+		var l CommandList
+		if err := json.Unmarshal([]byte(body), &l); err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Commands) != 1 || len(l.Commands[0].ResourceUpdates) != 1 {
+			t.Fatalf("commands = %+v", l.Commands)
+		}
+		out, _ := json.Marshal(l)
+		if string(out) != body {
+			t.Errorf("re-encoded = %s, want the agent's bytes", out)
+		}
+		if _, ok := l.Find("other"); ok {
+			t.Error("Find matched a bare object carrying a different CommandID")
+		}
+		var anon CommandList
+		if err := json.Unmarshal([]byte(`{"State":"Success"}`), &anon); err != nil {
+			t.Fatal(err)
+		}
+		if c, ok := anon.Find("x"); !ok || c.State != "Success" {
+			t.Errorf("Find on a bare object without an ID = %+v, %v", c, ok)
+		}
+	})
+	t.Run("built empty list", func(t *testing.T) {
+		out, _ := json.Marshal(CommandList{})
+		if string(out) != `{"Commands":[]}` {
+			t.Errorf("marshalled = %s", out)
+		}
+		if _, ok := (CommandList{}).Find("x"); ok {
+			t.Error("Find on an empty list reported a match")
+		}
+	})
+}
@@ -3,6 +3,8 @@ package profile
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // ActiveProfile returns the validated active profile name, or ErrNotInitialized
@@ -38,3 +40,30 @@ func ProfilePath(name string) (string, error) {
 	}
 	return filepath.Join(dir, "profiles", name+".pkl"), nil
 }
+
+// List returns the names of the profiles in the config dir, sorted. Files
+// whose names are not valid profile names are skipped; a missing profiles
+// directory is an empty list.
+func List() ([]string, error) {
+	dir, err := ResolveConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "profiles"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".pkl")
+		if !ok || e.IsDir() || ValidateName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
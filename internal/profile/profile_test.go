@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -67,3 +68,27 @@ func TestProfilePath(t *testing.T) {
 		t.Fatalf("expected ErrInvalidName, got %v", err)
 	}
 }
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+
+	names, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected no profiles before init, got %v", names)
+	}
+
+	for _, f := range []string{"prod.pkl", "dev.pkl", "-bad.pkl", "notes.txt"} {
+		writeFile(t, filepath.Join(dir, "profiles", f), "")
+	}
+	names, err = List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "dev,prod" {
+		t.Errorf("List() = %v, want [dev prod]", names)
+	}
+}
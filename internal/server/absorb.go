@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+func (s *Server) handleAbsorbDrift(ctx context.Context, req *mcp.CallToolRequest, input tools.AbsorbDriftInput) (*mcp.CallToolResult, any, error) {
+	if input.Stack == "" {
+		return errorResult(fmt.Errorf("stack is required")), nil, nil
+	}
+	c, err := s.clientFor(ctx, input.Profile)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	drifts, err := stackResourceDrift(ctx, c, input.Stack)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+
+	out := tools.AbsorbDriftOutput{Stack: input.Stack, Resources: []tools.ResourceAbsorbPlan{}}
+	if len(drifts) == 0 {
+		return absorbResult(out)
+	}
+
+	ws, err := workspaceFor(ctx, req)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	stackFile := input.FormaFile
+	if stackFile == "" {
+		resolved, diags, err := resolveStackFile(ctx, ws, input.Stack, currentEvalFunc(ctx, ws))
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+		stackFile = resolved
+		out.Notes = diags.notes(ws)
+	}
+	sources := &sourceSet{ws: ws, first: stackFile, cache: map[string]string{}}
+
+	for _, d := range drifts {
+		out.Resources = append(out.Resources, planResourceAbsorb(sources, d))
+	}
+	return absorbResult(out)
+}
+
+// planResourceAbsorb locates one drifted resource in source and plans the
+// edits that absorb its out-of-band changes.
+func planResourceAbsorb(sources *sourceSet, d resourceDrift) tools.ResourceAbsorbPlan {
+	plan := tools.ResourceAbsorbPlan{Type: d.diff.Type, Label: d.diff.Label}
+	deleted := planAction(d.diff.Operation) == actionDelete
+	if len(d.diff.Changes) == 0 && !deleted {
+		if d.diff.Note != "" {
+			plan.Notes = append(plan.Notes, d.diff.Note)
+		}
+		plan.Notes = append(plan.Notes, "nothing to absorb automatically; use extract_resources to compare by hand")
+		return plan
+	}
+
+	file, block, err := sources.findResource(d.diff.Type, d.diff.Label)
+	if err != nil {
+		plan.Notes = append(plan.Notes, err.Error())
+		return plan
+	}
+	source, _ := sources.read(file)
+	plan.FilePath = file
+	plan.BlockStart = block.StartLine
+	plan.BlockEnd = block.EndLine
+
+	if deleted {
+		plan.Edits = []tools.PropertyEdit{{
+			Operation:            "remove_block",
+			InsertionAnchorStart: block.StartLine,
+			InsertionAnchorEnd:   block.EndLine,
+			ExistingSnippet:      strings.TrimSuffix(extractLines(source, block.StartLine, block.EndLine), "\n"),
+		}}
+		plan.Notes = append(plan.Notes, "resource was deleted outside formae; absorbing removes its whole block. Remove any references to it (e.g. .res bindings) as well")
+		return plan
+	}
+
+	edits, notes := planPropertyEdits(source, block, d.diff.Changes, d.desired, d.current)
+	plan.Edits = edits
+	plan.Notes = append(plan.Notes, notes...)
+	return plan
+}
+
+// sourceSet reads workspace PKL sources once each, searching the file that
+// declares the stack before any other.
+type sourceSet struct {
+	ws    workspace
+	first string
+	files []string
+	cache map[string]string
+}
+
+func (s *sourceSet) read(path string) (string, error) {
+	if src, ok := s.cache[path]; ok {
+		return src, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s.cache[path] = string(data)
+	return string(data), nil
+}
+
+// findResource returns the single resource block declaring typ/label. The
+// stack's own file wins; otherwise every workspace PKL file is searched, since
+// resources often live in modules the stack file imports.
+func (s *sourceSet) findResource(typ, label string) (string, resourceBlock, error) {
+	if src, err := s.read(s.first); err == nil {
+		if blocks := findResourceBlocks(src, typ, label); len(blocks) == 1 {
+			return s.first, blocks[0], nil
+		} else if len(blocks) > 1 {
+			return "", resourceBlock{}, fmt.Errorf("%s declares %d blocks labelled %q; edit by hand", s.first, len(blocks), label)
+		}
+	}
+
+	if s.files == nil {
+		files, err := s.ws.pklFiles()
+		if err != nil {
+			return "", resourceBlock{}, err
+		}
+		s.files = files
+	}
+	var foundFile string
+	var found resourceBlock
+	var candidates []string
+	total := 0
+	for _, f := range s.files {
+		if f == s.first {
+			continue
+		}
+		src, err := s.read(f)
+		if err != nil {
+			continue
+		}
+		if blocks := findResourceBlocks(src, typ, label); len(blocks) > 0 {
+			candidates = append(candidates, f)
+			foundFile, found = f, blocks[0]
+			total += len(blocks)
+		}
+	}
+	switch total {
+	case 0:
+		return "", resourceBlock{}, fmt.Errorf("no resource block labelled %q found in %s or the workspace; it may be generated (loops, functions). Use extract_resources and edit by hand", label, s.first)
+	case 1:
+		return foundFile, found, nil
+	default:
+		return "", resourceBlock{}, fmt.Errorf("several PKL blocks are labelled %q: %s; edit by hand", label, s.ws.describe(candidates))
+	}
+}
+
+func absorbResult(out tools.AbsorbDriftOutput) (*mcp.CallToolResult, any, error) {
+	body, err := json.Marshal(out)
+	if err != nil {
+		return errorResult(fmt.Errorf("marshal output: %w", err)), nil, nil
+	}
+	return jsonResult(body), nil, nil
+}
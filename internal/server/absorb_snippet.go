@@ -0,0 +1,390 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+var (
+	// resourceBlockStartRE matches any `new <Class> {` opener, qualified or
+	// not. formae's own classes (Stack, Target, policies) are filtered out by
+	// the caller; what remains are plugin resource classes such as
+	// `new s3.Bucket {`.
+	resourceBlockStartRE = regexp.MustCompile(`new\s+([A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*)\s*\{`)
+	// assignmentRE matches a property assignment at the start of a line.
+	assignmentRE = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s*=\s*`)
+	// pklLiteralRE matches a value that is a plain literal rather than an
+	// expression (reference, interpolation, function call).
+	pklLiteralRE = regexp.MustCompile(`^(?:"(?:[^"\\]|\\.)*"|-?\d+(?:\.\d+)?|true|false|null)$`)
+	pklIdentRE   = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+)
+
+// resourceBlock is a located `new <Class> { ... }` resource declaration.
+type resourceBlock struct {
+	Class     string
+	StartLine int
+	EndLine   int
+	openIdx   int
+	closeIdx  int
+}
+
+// pklAssignment is one top-level `name = value` inside a block. Lines are
+// 1-indexed and inclusive; a multi-line value (a nested `new { ... }`) spans
+// several lines.
+type pklAssignment struct {
+	Name      string
+	StartLine int
+	EndLine   int
+	Value     string
+	Indent    string
+}
+
+// blockAssignments returns the assignments sitting directly inside the block
+// whose braces are at openIdx and closeIdx — nested blocks' own assignments
+// are not included.
+func blockAssignments(source string, openIdx, closeIdx int) []pklAssignment {
+	var out []pklAssignment
+	depth := 0
+	inString := false
+	lineStart := true
+	for i := openIdx + 1; i < closeIdx; i++ {
+		ch := source[i]
+		if lineStart && depth == 0 && !inString {
+			lineEnd := strings.IndexByte(source[i:closeIdx], '\n')
+			if lineEnd < 0 {
+				lineEnd = closeIdx - i
+			}
+			line := source[i : i+lineEnd]
+			if m := assignmentRE.FindStringSubmatchIndex(line); m != nil {
+				valueStart := i + m[1]
+				valueEnd := scanValueEnd(source, valueStart, closeIdx)
+				out = append(out, pklAssignment{
+					Name:      line[m[2]:m[3]],
+					StartLine: lineNumber(source, i),
+					EndLine:   lineNumber(source, valueEnd),
+					Value:     strings.TrimSpace(source[valueStart:valueEnd]),
+					Indent:    line[:len(line)-len(strings.TrimLeft(line, " \t"))],
+				})
+				i = valueEnd - 1
+				lineStart = false
+				continue
+			}
+		}
+		lineStart = false
+		switch {
+		case inString:
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+		case ch == '\n':
+			lineStart = true
+		}
+	}
+	return out
+}
+
+// scanValueEnd returns the offset just past an assignment's value: the end of
+// its line once any braces it opened are closed again.
+func scanValueEnd(source string, start, limit int) int {
+	depth := 0
+	inString := false
+	for i := start; i < limit; i++ {
+		ch := source[i]
+		switch {
+		case inString:
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+		case ch == '\n' && depth <= 0:
+			return i
+		}
+	}
+	return limit
+}
+
+// findResourceBlocks returns every plugin resource block in source whose own
+// `label = "..."` equals label. typ (e.g. "AWS::S3::Bucket") narrows several
+// matches down to those whose class name ends in the type's last segment.
+func findResourceBlocks(source, typ, label string) []resourceBlock {
+	var matches []resourceBlock
+	for _, m := range resourceBlockStartRE.FindAllStringSubmatchIndex(source, -1) {
+		class := source[m[2]:m[3]]
+		if strings.HasPrefix(class, "formae.") {
+			continue
+		}
+		openIdx := m[1] - 1
+		closeIdx, ok := matchBrace(source, openIdx)
+		if !ok {
+			continue
+		}
+		for _, a := range blockAssignments(source, openIdx, closeIdx) {
+			if a.Name == "label" && a.Value == strconv.Quote(label) {
+				matches = append(matches, resourceBlock{
+					Class:     class,
+					StartLine: lineNumber(source, m[0]),
+					EndLine:   lineNumber(source, closeIdx),
+					openIdx:   openIdx,
+					closeIdx:  closeIdx,
+				})
+				break
+			}
+		}
+	}
+	if len(matches) <= 1 || typ == "" {
+		return matches
+	}
+	segments := strings.Split(typ, "::")
+	want := strings.ToLower(segments[len(segments)-1])
+	var narrowed []resourceBlock
+	for _, b := range matches {
+		parts := strings.Split(b.Class, ".")
+		if strings.ToLower(parts[len(parts)-1]) == want {
+			narrowed = append(narrowed, b)
+		}
+	}
+	if len(narrowed) == 0 {
+		return matches
+	}
+	return narrowed
+}
+
+// planPropertyEdits computes the edits that make a resource block declare the
+// current values of every top-level property touched by changes. Only
+// properties the block declares or the desired state set are absorbed, and
+// nested values keep only the fields the desired state set: anything else in
+// the synced state is read-only or filled in by the provider (ids, ARNs,
+// timestamps, defaults), and writing it into source would make the next apply
+// try to set it. Edits are returned bottom-up (descending line) so applying
+// them in order keeps the remaining line numbers valid.
+func planPropertyEdits(source string, block resourceBlock, changes []tools.PropertyChange, desired, current any) ([]tools.PropertyEdit, []string) {
+	desiredMap, _ := desired.(map[string]any)
+	currentMap, _ := current.(map[string]any)
+	assignments := blockAssignments(source, block.openIdx, block.closeIdx)
+
+	// Group changed pointers by top-level property.
+	pointers := map[string][]string{}
+	var keys []string
+	for _, c := range changes {
+		seg := strings.SplitN(strings.TrimPrefix(c.Path, "/"), "/", 2)[0]
+		key := unescapePointerToken(seg)
+		if key == "" {
+			continue
+		}
+		if _, seen := pointers[key]; !seen {
+			keys = append(keys, key)
+		}
+		pointers[key] = append(pointers[key], c.Path)
+	}
+
+	var edits []tools.PropertyEdit
+	var notes []string
+	nested := false
+	for _, key := range keys {
+		var existing *pklAssignment
+		for i := range assignments {
+			if strings.EqualFold(assignments[i].Name, key) {
+				existing = &assignments[i]
+				break
+			}
+		}
+		want, declared := desiredMap[key]
+		if existing == nil && !declared {
+			notes = append(notes, fmt.Sprintf("%s is neither declared in the block nor set by the last apply; it is read-only or provider-generated and is not absorbed", key))
+			continue
+		}
+		value, present := currentMap[key]
+		if present && declared {
+			value = declaredPart(want, value)
+		}
+
+		switch {
+		case existing != nil && present:
+			if !pklLiteralRE.MatchString(existing.Value) && !strings.HasPrefix(existing.Value, "new") {
+				notes = append(notes, fmt.Sprintf("%s was the expression %q; the edit replaces it with a literal — check whether the expression's source should change instead", existing.Name, existing.Value))
+			}
+			rendered, isNested := renderPKLValue(value, existing.Indent)
+			nested = nested || isNested
+			edits = append(edits, tools.PropertyEdit{
+				Property:             existing.Name,
+				Pointers:             pointers[key],
+				Operation:            "update",
+				PKLSnippet:           existing.Indent + existing.Name + " = " + rendered,
+				InsertionAnchorStart: existing.StartLine,
+				InsertionAnchorEnd:   existing.EndLine,
+				ExistingSnippet:      strings.TrimSuffix(extractLines(source, existing.StartLine, existing.EndLine), "\n"),
+			})
+		case existing != nil:
+			edits = append(edits, tools.PropertyEdit{
+				Property:             existing.Name,
+				Pointers:             pointers[key],
+				Operation:            "remove",
+				InsertionAnchorStart: existing.StartLine,
+				InsertionAnchorEnd:   existing.EndLine,
+				ExistingSnippet:      strings.TrimSuffix(extractLines(source, existing.StartLine, existing.EndLine), "\n"),
+			})
+		case present:
+			indent := blockIndent(source, block) + "  "
+			name := pklFieldName(key)
+			rendered, isNested := renderPKLValue(value, indent)
+			nested = nested || isNested
+			edits = append(edits, tools.PropertyEdit{
+				Property:             name,
+				Pointers:             pointers[key],
+				Operation:            "create",
+				PKLSnippet:           indent + name + " = " + rendered,
+				InsertionAnchorStart: block.EndLine,
+				InsertionAnchorEnd:   block.EndLine,
+			})
+		}
+	}
+	if nested {
+		notes = append(notes, "nested values are rendered as generic `new { ... }` amendments; check field names against the resource schema")
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].InsertionAnchorStart > edits[j].InsertionAnchorStart
+	})
+	return edits, notes
+}
+
+// declaredPart trims a synced value to the object keys the desired value
+// set, at every level. Array elements past the desired ones are kept whole:
+// they were added out of band.
+func declaredPart(desired, current any) any {
+	switch cur := current.(type) {
+	case map[string]any:
+		want, ok := desired.(map[string]any)
+		if !ok {
+			return current
+		}
+		out := map[string]any{}
+		for k, v := range cur {
+			if w, set := want[k]; set {
+				out[k] = declaredPart(w, v)
+			}
+		}
+		return out
+	case []any:
+		want, ok := desired.([]any)
+		if !ok {
+			return current
+		}
+		out := make([]any, len(cur))
+		for i, v := range cur {
+			if i < len(want) {
+				v = declaredPart(want[i], v)
+			}
+			out[i] = v
+		}
+		return out
+	}
+	return current
+}
+
+// blockIndent returns the leading whitespace of the block's opening line.
+func blockIndent(source string, block resourceBlock) string {
+	line := extractLines(source, block.StartLine, block.StartLine)
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// pklFieldName converts an agent property name (PascalCase) to the camelCase
+// PKL field formae plugins declare.
+func pklFieldName(key string) string {
+	if key == "" {
+		return key
+	}
+	return strings.ToLower(key[:1]) + key[1:]
+}
+
+// renderPKLValue renders a decoded JSON value as a PKL expression. Objects and
+// arrays become `new { ... }` amendments indented one level past indent; the
+// second return reports whether such nesting was needed.
+func renderPKLValue(v any, indent string) (string, bool) {
+	switch val := v.(type) {
+	case nil:
+		return "null", false
+	case bool:
+		return strconv.FormatBool(val), false
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), false
+	case string:
+		return pklQuote(val), false
+	case []any:
+		var b strings.Builder
+		b.WriteString("new {\n")
+		for _, item := range val {
+			rendered, _ := renderPKLValue(item, indent+"  ")
+			b.WriteString(indent + "  " + rendered + "\n")
+		}
+		b.WriteString(indent + "}")
+		return b.String(), true
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString("new {\n")
+		for _, k := range keys {
+			rendered, _ := renderPKLValue(val[k], indent+"  ")
+			name := "[" + pklQuote(k) + "]"
+			if pklIdentRE.MatchString(k) {
+				name = pklFieldName(k)
+			}
+			b.WriteString(indent + "  " + name + " = " + rendered + "\n")
+		}
+		b.WriteString(indent + "}")
+		return b.String(), true
+	}
+	return fmt.Sprintf("%v", v), false
+}
+
+// pklQuote renders s as a PKL string literal. PKL shares JSON's simple escapes
+// but writes other code points as \u{XXXX}.
+func pklQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u{%X}`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+const absorbTestSource = `amends "@formae/forma.pkl"
+import "@formae/formae.pkl"
+import "@aws/s3/bucket.pkl" as s3
+
+forma {
+  new formae.Stack {
+    label = "prod"
+  }
+
+  new s3.Bucket {
+    label = "logs"
+    bucketName = "acme-logs"
+    versioning = "Suspended"
+    tags = new {
+      new { key = "env"; value = "prod" }
+    }
+  }
+
+  new s3.Bucket {
+    label = "assets"
+    bucketName = "acme-" + suffix
+  }
+}
+`
+
+func TestFindResourceBlocks(t *testing.T) {
+	blocks := findResourceBlocks(absorbTestSource, "AWS::S3::Bucket", "logs")
+	if len(blocks) != 1 {
+		t.Fatalf("blocks = %+v", blocks)
+	}
+	if b := blocks[0]; b.Class != "s3.Bucket" || b.StartLine != 10 || b.EndLine != 17 {
+		t.Errorf("block = %+v, want s3.Bucket lines 10-17", b)
+	}
+	if got := findResourceBlocks(absorbTestSource, "AWS::S3::Bucket", "missing"); len(got) != 0 {
+		t.Errorf("unexpected match for missing label: %+v", got)
+	}
+	// The stack's own label must not be mistaken for a resource.
+	if got := findResourceBlocks(absorbTestSource, "", "prod"); len(got) != 0 {
+		t.Errorf("formae.Stack matched as a resource: %+v", got)
+	}
+}
+
+func TestBlockAssignmentsSkipsNested(t *testing.T) {
+	b := findResourceBlocks(absorbTestSource, "", "logs")[0]
+	var names []string
+	for _, a := range blockAssignments(absorbTestSource, b.openIdx, b.closeIdx) {
+		names = append(names, a.Name)
+	}
+	if got := strings.Join(names, ","); got != "label,bucketName,versioning,tags" {
+		t.Errorf("assignments = %s", got)
+	}
+}
+
+func TestPlanPropertyEdits(t *testing.T) {
+	b := findResourceBlocks(absorbTestSource, "", "logs")[0]
+	changes := []tools.PropertyChange{
+		{Path: "/Versioning", Op: "replace", Old: "Suspended", New: "Enabled"},
+		{Path: "/Tags/0/Value", Op: "replace", Old: "prod", New: "staging"},
+		{Path: "/Tags/1", Op: "add", New: map[string]any{"Key": "team"}},
+		{Path: "/BucketName", Op: "remove", Old: "acme-logs"},
+		{Path: "/ObjectLock", Op: "add", New: true},
+	}
+	current := map[string]any{
+		"Versioning": "Enabled",
+		"Tags":       []any{map[string]any{"Key": "env", "Value": "staging"}, map[string]any{"Key": "team"}},
+		"ObjectLock": true,
+	}
+	desired := map[string]any{
+		"Versioning": "Suspended",
+		"Tags":       []any{map[string]any{"Key": "env", "Value": "prod"}},
+		"BucketName": "acme-logs",
+		"ObjectLock": false,
+	}
+	edits, notes := planPropertyEdits(absorbTestSource, b, changes, desired, current)
+	if len(edits) != 4 {
+		t.Fatalf("edits = %+v", edits)
+	}
+	for i := 1; i < len(edits); i++ {
+		if edits[i].InsertionAnchorStart > edits[i-1].InsertionAnchorStart {
+			t.Fatalf("edits not bottom-up: %+v", edits)
+		}
+	}
+	byProp := map[string]tools.PropertyEdit{}
+	for _, e := range edits {
+		byProp[e.Property] = e
+	}
+
+	if e := byProp["objectLock"]; e.Operation != "create" || e.InsertionAnchorStart != 17 || e.PKLSnippet != "    objectLock = true" {
+		t.Errorf("objectLock edit = %+v", e)
+	}
+	if e := byProp["tags"]; e.Operation != "update" || e.InsertionAnchorStart != 14 || e.InsertionAnchorEnd != 16 ||
+		len(e.Pointers) != 2 || !strings.Contains(e.PKLSnippet, `value = "staging"`) {
+		t.Errorf("tags edit = %+v", e)
+	}
+	if e := byProp["versioning"]; e.Operation != "update" || e.PKLSnippet != `    versioning = "Enabled"` ||
+		e.ExistingSnippet != `    versioning = "Suspended"` {
+		t.Errorf("versioning edit = %+v", e)
+	}
+	if e := byProp["bucketName"]; e.Operation != "remove" || e.InsertionAnchorStart != 12 || e.InsertionAnchorEnd != 12 {
+		t.Errorf("bucketName edit = %+v", e)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "nested values") {
+		t.Errorf("notes = %v", notes)
+	}
+}
+
+func TestPlanPropertyEditsFlagsExpressions(t *testing.T) {
+	b := findResourceBlocks(absorbTestSource, "", "assets")[0]
+	changes := []tools.PropertyChange{{Path: "/BucketName", Op: "replace", Old: "acme-x", New: "acme-y"}}
+	edits, notes := planPropertyEdits(absorbTestSource, b, changes, map[string]any{"BucketName": "acme-x"}, map[string]any{"BucketName": "acme-y"})
+	if len(edits) != 1 || edits[0].PKLSnippet != `    bucketName = "acme-y"` {
+		t.Fatalf("edits = %+v", edits)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "expression") {
+		t.Errorf("notes = %v", notes)
+	}
+}
+
+func TestPlanPropertyEditsSkipsServerSideFields(t *testing.T) {
+	b := findResourceBlocks(absorbTestSource, "", "logs")[0]
+	changes := []tools.PropertyChange{
+		{Path: "/Tags/0/Value", Op: "replace", Old: "prod", New: "staging"},
+		{Path: "/Arn", Op: "add", New: "arn:aws:s3:::acme-logs"},
+	}
+	desired := map[string]any{"Tags": []any{map[string]any{"Key": "env", "Value": "prod"}}}
+	current := map[string]any{
+		"Tags":         []any{map[string]any{"Key": "env", "Value": "staging", "ResourceId": "tag-1"}},
+		"Arn":          "arn:aws:s3:::acme-logs",
+		"CreationDate": "2026-01-01T00:00:00Z",
+	}
+	edits, notes := planPropertyEdits(absorbTestSource, b, changes, desired, current)
+	if len(edits) != 1 || edits[0].Property != "tags" {
+		t.Fatalf("got:\n%+v\nwant:\nonly the tags edit", edits)
+	}
+	if snippet := edits[0].PKLSnippet; !strings.Contains(snippet, `value = "staging"`) || strings.Contains(snippet, "tag-1") {
+		t.Errorf("got:\n%s\nwant:\nthe changed tag without the provider's ResourceId", snippet)
+	}
+	if !strings.Contains(strings.Join(notes, "\n"), "Arn is neither declared") {
+		t.Errorf("got:\n%v\nwant:\na note that Arn is not absorbed", notes)
+	}
+}
+
+func TestPKLQuote(t *testing.T) {
+	cases := map[string]string{
+		`plain`:     `"plain"`,
+		`say "hi"`:  `"say \"hi\""`,
+		"a\\b":      `"a\\b"`,
+		"line\nend": `"line\nend"`,
+		"bell\x07":  `"bell\u{7}"`,
+	}
+	for in, want := range cases {
+		if got := pklQuote(in); got != want {
+			t.Errorf("pklQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+func TestAbsorbDrift(t *testing.T) {
+	dir := t.TempDir()
+	formaFile := filepath.Join(dir, "main.pkl")
+	if err := writeTestFile(formaFile, absorbTestSource); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/prod/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[
+				{"Stack":"prod","Type":"AWS::S3::Bucket","Label":"logs","Operation":"update"},
+				{"Stack":"prod","Type":"AWS::S3::Bucket","Label":"assets","Operation":"delete"}]}`)
+		},
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"Commands":[{"CommandID":"cmd-1","State":"Success","ResourceUpdates":[
+				{"ResourceLabel":"logs","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"create","State":"Success",
+				 "Properties":{"BucketName":"acme-logs","Versioning":"Suspended"}}]}]}`)
+		},
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"logs","Type":"AWS::S3::Bucket","Stack":"prod",
+				"Properties":{"BucketName":"acme-logs","Versioning":"Enabled"}}]`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "absorb_drift",
+		Arguments: map[string]any{"stack": "prod", "forma_file": formaFile},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	var out tools.AbsorbDriftOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Resources) != 2 {
+		t.Fatalf("resources = %+v", out.Resources)
+	}
+
+	logs := out.Resources[0]
+	if logs.FilePath != formaFile || logs.BlockStart != 10 || logs.BlockEnd != 17 {
+		t.Errorf("logs location = %s:%d-%d", logs.FilePath, logs.BlockStart, logs.BlockEnd)
+	}
+	if len(logs.Edits) != 1 || logs.Edits[0].Operation != "update" ||
+		logs.Edits[0].PKLSnippet != `    versioning = "Enabled"` || logs.Edits[0].InsertionAnchorStart != 13 {
+		t.Errorf("logs edits = %+v", logs.Edits)
+	}
+
+	assets := out.Resources[1]
+	if len(assets.Edits) != 1 || assets.Edits[0].Operation != "remove_block" ||
+		assets.Edits[0].InsertionAnchorStart != 19 || assets.Edits[0].InsertionAnchorEnd != 22 {
+		t.Errorf("assets edits = %+v", assets.Edits)
+	}
+}
+
+func TestAbsorbDrift_RequiresStack(t *testing.T) {
+	agent := mockAgent(t, nil)
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "absorb_drift",
+		Arguments: map[string]any{"stack": ""},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError {
+		t.Fatalf("expected error, got: %s", textContent(t, result))
+	}
+}
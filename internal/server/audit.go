@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/audit"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// auditedTools are the tools whose calls are written to the audit log: those
+// that change infrastructure, the active or stored profiles, or PKL source.
+var auditedTools = map[string]bool{
+	"apply_forma":              true,
+	"destroy_forma":            true,
+	"cancel_commands":          true,
+	"force_sync":               true,
+	"force_discover":           true,
+	"force_check_ttl":          true,
+	"force_reconcile_stack":    true,
+	"use_profile":              true,
+	"save_profile":             true,
+	"create_profile":           true,
+	"delete_profile":           true,
+	"write_profile":            true,
+	"create_inline_policy":     true,
+	"absorb_drift":             true,
+	"create_standalone_policy": true,
+	"attach_standalone_policy": true,
+	"detach_standalone_policy": true,
+	"delete_standalone_policy": true,
+}
+
+// auditRecord collects what a handler learns during an audited call that
+// the request alone does not show.
+type auditRecord struct {
+	mu       sync.Mutex
+	entry    audit.Entry
+	declined bool
+}
+
+type auditKey struct{}
+
+func auditFrom(ctx context.Context) *auditRecord {
+	rec, _ := ctx.Value(auditKey{}).(*auditRecord)
+	return rec
+}
+
+// noteAuditTarget records the profile and agent an audited call resolved to.
+func noteAuditTarget(ctx context.Context, profileName, endpoint string) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.entry.Profile, rec.entry.Endpoint = profileName, endpoint
+		rec.mu.Unlock()
+	}
+}
+
+// noteAuditForma records the hash of the evaluated forma an audited call
+// submitted, which identifies exactly what was applied or destroyed.
+func noteAuditForma(ctx context.Context, formaJSON []byte) {
+	if rec := auditFrom(ctx); rec != nil {
+		sum := sha256.Sum256(formaJSON)
+		rec.mu.Lock()
+		rec.entry.FormaHash = "sha256:" + hex.EncodeToString(sum[:])
+		rec.mu.Unlock()
+	}
+}
+
+// noteAuditDeclined records that the user refused to confirm the call.
+func noteAuditDeclined(ctx context.Context) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.declined = true
+		rec.mu.Unlock()
+	}
+}
+
+// auditMiddleware writes an audit entry for every call to an audited tool
+// once it returns. A failed write is logged rather than failing the call,
+// which has already run by then.
+func auditMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		call, ok := req.(*mcp.CallToolRequest)
+		if !ok || call.Params == nil || !auditedTools[call.Params.Name] {
+			return next(ctx, method, req)
+		}
+		start := time.Now()
+		rec := &auditRecord{entry: audit.Entry{
+			Time:      start.UTC(),
+			Tool:      call.Params.Name,
+			Arguments: audit.SanitizeArguments(call.Params.Arguments),
+		}}
+		if p, ok := rec.entry.Arguments["profile"].(string); ok {
+			rec.entry.Profile = p
+		}
+		if call.Session != nil {
+			rec.entry.SessionID = call.Session.ID()
+			if params := call.Session.InitializeParams(); params != nil && params.ClientInfo != nil {
+				rec.entry.ClientName = params.ClientInfo.Name
+				rec.entry.ClientVersion = params.ClientInfo.Version
+			}
+		}
+
+		result, err := next(context.WithValue(ctx, auditKey{}, rec), method, req)
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		e := rec.entry
+		e.DurationMS = time.Since(start).Milliseconds()
+		res, _ := result.(*mcp.CallToolResult)
+		switch {
+		case err != nil:
+			e.Outcome, e.Error = audit.OutcomeError, err.Error()
+		case res != nil && res.IsError:
+			e.Outcome, e.Error = audit.OutcomeError, resultText(res)
+			if rec.declined {
+				e.Outcome = audit.OutcomeDeclined
+			}
+		default:
+			e.Outcome = audit.OutcomeSuccess
+			e.CommandIDs = commandIDsIn(res)
+		}
+		if werr := audit.Append(e); werr != nil {
+			slog.ErrorContext(ctx, "audit log write failed", "tool", e.Tool, "error", werr)
+		}
+		return result, err
+	}
+}
+
+// resultText joins the text content of a tool result.
+func resultText(res *mcp.CallToolResult) string {
+	var parts []string
+	for _, c := range res.Content {
+		if t, ok := c.(*mcp.TextContent); ok {
+			parts = append(parts, t.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// commandIDsIn finds the IDs of the commands a tool result reports: the
+// agent's CommandID (apply, destroy), CommandIds (cancel) or command_id
+// (force_reconcile_stack, change plans).
+func commandIDsIn(res *mcp.CallToolResult) []string {
+	if res == nil {
+		return nil
+	}
+	var bodies [][]byte
+	if res.StructuredContent != nil {
+		if data, err := json.Marshal(res.StructuredContent); err == nil {
+			bodies = append(bodies, data)
+		}
+	}
+	for _, c := range res.Content {
+		if t, ok := c.(*mcp.TextContent); ok {
+			bodies = append(bodies, []byte(t.Text))
+		}
+	}
+	for _, body := range bodies {
+		var ids struct {
+			CommandID        string   `json:"CommandID"`
+			CommandIDs       []string `json:"CommandIds"`
+			CommandIDSnake   string   `json:"command_id"`
+			CommandIDsPlural []string `json:"command_ids"`
+		}
+		if json.Unmarshal(body, &ids) != nil {
+			continue
+		}
+		var found []string
+		for _, id := range append([]string{ids.CommandID, ids.CommandIDSnake}, append(ids.CommandIDs, ids.CommandIDsPlural...)...) {
+			if id != "" {
+				found = append(found, id)
+			}
+		}
+		if len(found) > 0 {
+			return found
+		}
+	}
+	return nil
+}
+
+// defaultAuditLimit is how many entries list_audit_log returns by default.
+const defaultAuditLimit = 50
+
+func (s *Server) handleListAuditLog(_ context.Context, _ *mcp.CallToolRequest, input tools.ListAuditLogInput) (*mcp.CallToolResult, any, error) {
+	filter := audit.Filter{Tool: input.Tool, SessionID: input.SessionID, Outcome: input.Outcome, Limit: input.Limit}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	if input.Since != "" {
+		since, err := parseSince(input.Since)
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+		filter.Since = since
+	}
+	path, err := audit.Path()
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	entries, err := audit.Read(filter)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	out := tools.ListAuditLogOutput{Path: path, Entries: entries}
+	if out.Entries == nil {
+		out.Entries = []audit.Entry{}
+	}
+	return marshalResult(out), out, nil
+}
+
+// parseSince accepts an RFC 3339 time or a duration back from now ("24h").
+func parseSince(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("since must be an RFC 3339 time (2026-01-02T15:04:05Z) or a duration such as 24h, got %q", v)
+}
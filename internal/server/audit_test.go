@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/audit"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// TestMain keeps every test in the package from appending to the user's real
+// audit log.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "formae-mcp-audit")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("FORMAE_MCP_AUDIT_LOG", filepath.Join(dir, audit.FileName))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// withAuditLog points the audit log at a fresh file for one test.
+func withAuditLog(t *testing.T) {
+	t.Helper()
+	t.Setenv("FORMAE_MCP_AUDIT_LOG", filepath.Join(t.TempDir(), audit.FileName))
+}
+
+func writeDestroyForma(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "main.json")
+	if err := writeTestFile(p, `{"Stacks":[{"Label":"prod"}],"Resources":[]}`); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuditLogRecordsDestroy(t *testing.T) {
+	withAuditLog(t)
+	submitted := 0
+	agentURL := destroyAgent(t, &submitted)
+	session, _ := connectTestServerWithElicitation(t, agentURL,
+		&mcp.ElicitResult{Action: "accept", Content: map[string]any{"confirm": true}})
+
+	res, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"file_path": writeDestroyForma(t)},
+	})
+	if err != nil || res.IsError {
+		t.Fatalf("destroy_forma failed: %v %+v", err, res)
+	}
+
+	entries, err := audit.Read(audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("want 1 audit entry, got %+v", entries)
+	}
+	e := entries[0]
+	if e.Tool != "destroy_forma" || e.Outcome != audit.OutcomeSuccess {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.Endpoint != agentURL || e.ClientName != "test-client" {
+		t.Errorf("want endpoint %s and client test-client, got %+v", agentURL, e)
+	}
+	if len(e.CommandIDs) != 1 || e.CommandIDs[0] != "cmd-destroy-1" {
+		t.Errorf("want command cmd-destroy-1, got %v", e.CommandIDs)
+	}
+	if len(e.FormaHash) != len("sha256:")+64 {
+		t.Errorf("want a sha256 forma hash, got %q", e.FormaHash)
+	}
+}
+
+func TestAuditLogRecordsDeclinedConfirmation(t *testing.T) {
+	withAuditLog(t)
+	submitted := 0
+	session, _ := connectTestServerWithElicitation(t, destroyAgent(t, &submitted), &mcp.ElicitResult{Action: "decline"})
+
+	if _, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"file_path": writeDestroyForma(t)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := audit.Read(audit.Filter{Tool: "destroy_forma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Outcome != audit.OutcomeDeclined || entries[0].Error == "" {
+		t.Errorf("want one declined entry with its message, got %+v", entries)
+	}
+}
+
+func TestListAuditLog(t *testing.T) {
+	withAuditLog(t)
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"POST /api/v1/admin/synchronize": func(w http.ResponseWriter, _ *http.Request) {},
+		"GET /api/v1/stacks": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`[]`))
+		},
+	})
+	t.Cleanup(agent.Close)
+	session := connectTestServer(t, agent.URL)
+	ctx := context.Background()
+
+	for _, name := range []string{"force_sync", "list_stacks"} {
+		if _, err := session.CallTool(ctx, &mcp.CallToolParams{Name: name, Arguments: map[string]any{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "list_audit_log", Arguments: map[string]any{"since": "1h"}})
+	if err != nil || res.IsError {
+		t.Fatalf("list_audit_log failed: %v %+v", err, res)
+	}
+	var out tools.ListAuditLogOutput
+	if err := json.Unmarshal([]byte(textContent(t, res)), &out); err != nil {
+		t.Fatal(err)
+	}
+	// Only the mutating call is audited.
+	if len(out.Entries) != 1 || out.Entries[0].Tool != "force_sync" || out.Entries[0].Outcome != audit.OutcomeSuccess {
+		t.Errorf("want one force_sync entry, got %+v", out.Entries)
+	}
+
+	res, err = session.CallTool(ctx, &mcp.CallToolParams{Name: "list_audit_log", Arguments: map[string]any{"since": "yesterday"}})
+	if err != nil || !res.IsError {
+		t.Errorf("want an error for a bad since, got %v %+v", err, res)
+	}
+}
+
+func TestCommandIDsIn(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"CommandID":"a"}`:        "a",
+		`{"CommandIds":["b","c"]}`: "b,c",
+		`{"command_id":"d"}`:       "d",
+		`Resource sync triggered.`: "",
+		`{"CommandIds":[]}`:        "",
+	} {
+		got := commandIDsIn(jsonResult(json.RawMessage(body)))
+		if joined := strings.Join(got, ","); joined != want {
+			t.Errorf("%s: got %q, want %q", body, joined, want)
+		}
+	}
+}
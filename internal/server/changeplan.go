@@ -0,0 +1,353 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// simulateResponse is the subset of the agent's SubmitCommand response needed
+// to render a simulation. Field names follow the agent's apimodel.
+type simulateResponse struct {
+	CommandID  string `json:"CommandID"`
+	Simulation *struct {
+		ChangesRequired *bool            `json:"ChangesRequired"`
+		Command         simulatedCommand `json:"Command"`
+	} `json:"Simulation"`
+}
+
+type simulatedCommand struct {
+	Command         string                    `json:"Command"`
+	ResourceUpdates []simulatedResourceUpdate `json:"ResourceUpdates"`
+	TargetUpdates   []simulatedTargetUpdate   `json:"TargetUpdates"`
+}
+
+// simulatedResourceUpdate mirrors apimodel.ResourceUpdate. The agent reports
+// a resource's target as Target on some versions and TargetLabel on others.
+type simulatedResourceUpdate struct {
+	ResourceLabel string          `json:"ResourceLabel"`
+	ResourceType  string          `json:"ResourceType"`
+	StackName     string          `json:"StackName"`
+	Target        string          `json:"Target"`
+	TargetLabel   string          `json:"TargetLabel"`
+	Operation     string          `json:"Operation"`
+	Properties    json.RawMessage `json:"Properties"`
+	OldProperties json.RawMessage `json:"OldProperties"`
+	PatchDocument json.RawMessage `json:"PatchDocument"`
+}
+
+type simulatedTargetUpdate struct {
+	TargetLabel string `json:"TargetLabel"`
+	Operation   string `json:"Operation"`
+}
+
+// jsonPatchOp is one RFC 6902 operation from a resource's PatchDocument.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Plan actions, in the order they are rendered within a group.
+const (
+	actionCreate  = "create"
+	actionUpdate  = "update"
+	actionReplace = "replace"
+	actionDelete  = "delete"
+)
+
+var actionOrder = map[string]int{actionCreate: 0, actionUpdate: 1, actionReplace: 2, actionDelete: 3}
+
+// buildChangePlan turns a simulate response into a grouped plan. It returns
+// an error when the body does not look like a simulation, so callers can fall
+// back to passing the raw JSON through.
+func buildChangePlan(command string, body []byte) (tools.ChangePlan, error) {
+	var resp simulateResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return tools.ChangePlan{}, fmt.Errorf("parse simulation: %w", err)
+	}
+	if resp.Simulation == nil {
+		return tools.ChangePlan{}, fmt.Errorf("parse simulation: response has no Simulation")
+	}
+	cmd := resp.Simulation.Command
+	plan := tools.ChangePlan{Command: command, CommandID: resp.CommandID}
+	if cmd.Command != "" {
+		plan.Command = cmd.Command
+	}
+
+	changes := map[[2]string][]tools.PlannedChange{}
+	for _, u := range mergeReplacements(cmd.ResourceUpdates) {
+		change := plannedResourceChange(u)
+		switch change.Action {
+		case actionCreate:
+			plan.Summary.Create++
+		case actionUpdate:
+			plan.Summary.Update++
+		case actionReplace:
+			plan.Summary.Replace++
+		case actionDelete:
+			plan.Summary.Delete++
+		default:
+			plan.Summary.Other++
+		}
+		target := u.Target
+		if target == "" {
+			target = u.TargetLabel
+		}
+		key := [2]string{u.StackName, target}
+		changes[key] = append(changes[key], change)
+	}
+	for key, list := range changes {
+		sort.SliceStable(list, func(i, j int) bool {
+			oi, oj := actionRank(list[i].Action), actionRank(list[j].Action)
+			if oi != oj {
+				return oi < oj
+			}
+			if list[i].Type != list[j].Type {
+				return list[i].Type < list[j].Type
+			}
+			return list[i].Label < list[j].Label
+		})
+		plan.Groups = append(plan.Groups, tools.ChangePlanGroup{Stack: key[0], Target: key[1], Changes: list})
+	}
+	sort.Slice(plan.Groups, func(i, j int) bool {
+		if plan.Groups[i].Stack != plan.Groups[j].Stack {
+			return plan.Groups[i].Stack < plan.Groups[j].Stack
+		}
+		return plan.Groups[i].Target < plan.Groups[j].Target
+	})
+
+	for _, t := range cmd.TargetUpdates {
+		plan.Targets = append(plan.Targets, tools.PlannedChange{Action: planAction(t.Operation), Label: t.TargetLabel})
+	}
+
+	if resp.Simulation.ChangesRequired != nil {
+		plan.ChangesRequired = *resp.Simulation.ChangesRequired
+	} else {
+		plan.ChangesRequired = len(plan.Groups) > 0 || len(plan.Targets) > 0
+	}
+	return plan, nil
+}
+
+// mergeReplacements folds a delete and a create of the same stack, type and
+// label into one replace, which is how some agent versions report a
+// createOnly change.
+func mergeReplacements(updates []simulatedResourceUpdate) []simulatedResourceUpdate {
+	type key struct{ stack, typ, label string }
+	keyOf := func(u simulatedResourceUpdate) key { return key{u.StackName, u.ResourceType, u.ResourceLabel} }
+	deletes := map[key]simulatedResourceUpdate{}
+	creates := map[key]bool{}
+	for _, u := range updates {
+		switch planAction(u.Operation) {
+		case actionDelete:
+			deletes[keyOf(u)] = u
+		case actionCreate:
+			creates[keyOf(u)] = true
+		}
+	}
+	merged := make([]simulatedResourceUpdate, 0, len(updates))
+	for _, u := range updates {
+		switch planAction(u.Operation) {
+		case actionDelete:
+			if creates[keyOf(u)] {
+				continue
+			}
+		case actionCreate:
+			if d, ok := deletes[keyOf(u)]; ok {
+				u.Operation = actionReplace
+				if len(u.OldProperties) == 0 {
+					u.OldProperties = d.Properties
+				}
+			}
+		}
+		merged = append(merged, u)
+	}
+	return merged
+}
+
+func plannedResourceChange(u simulatedResourceUpdate) tools.PlannedChange {
+	change := tools.PlannedChange{
+		Action: planAction(u.Operation),
+		Type:   u.ResourceType,
+		Label:  u.ResourceLabel,
+	}
+	if change.Action == actionUpdate || change.Action == actionReplace {
+		change.Properties = propertyChanges(u)
+	}
+	if change.Action == actionReplace {
+		// The simulation does not say which of the changed properties is
+		// createOnly, so no path is singled out.
+		change.ReplaceReason = "a createOnly property changed, so the resource is destroyed and recreated"
+	}
+	return change
+}
+
+// propertyChanges prefers the agent's JSON Patch, filling in old values from
+// OldProperties, and otherwise diffs OldProperties against Properties.
+func propertyChanges(u simulatedResourceUpdate) []tools.PropertyChange {
+	old := decodeJSONValue(u.OldProperties)
+	var ops []jsonPatchOp
+	if len(u.PatchDocument) > 0 && json.Unmarshal(u.PatchDocument, &ops) == nil && len(ops) > 0 {
+		out := make([]tools.PropertyChange, 0, len(ops))
+		for _, op := range ops {
+			pc := tools.PropertyChange{Path: op.Path, Op: op.Op, New: op.Value}
+			if v, ok := lookupPointer(old, op.Path); ok && old != nil {
+				pc.Old = v
+			}
+			out = append(out, pc)
+		}
+		return out
+	}
+	if old == nil {
+		return nil
+	}
+	return diffJSON(old, decodeJSONValue(u.Properties))
+}
+
+// planAction maps an agent operation to a plan action.
+func planAction(op string) string {
+	switch normalizeState(op) {
+	case "create", "add":
+		return actionCreate
+	case "update", "modify":
+		return actionUpdate
+	case "replace", "recreate":
+		return actionReplace
+	case "delete", "destroy", "remove":
+		return actionDelete
+	}
+	return strings.ToLower(op)
+}
+
+func actionRank(action string) int {
+	if r, ok := actionOrder[action]; ok {
+		return r
+	}
+	return len(actionOrder)
+}
+
+// renderChangePlan formats a plan as markdown for the model to show the user.
+func renderChangePlan(plan tools.ChangePlan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Simulated %s\n\n", plan.Command)
+	if !plan.ChangesRequired {
+		b.WriteString("No changes required.\n")
+		return b.String()
+	}
+
+	s := plan.Summary
+	parts := []string{
+		fmt.Sprintf("%d to create", s.Create),
+		fmt.Sprintf("%d to update", s.Update),
+		fmt.Sprintf("%d to replace", s.Replace),
+		fmt.Sprintf("%d to delete", s.Delete),
+	}
+	if s.Other > 0 {
+		parts = append(parts, fmt.Sprintf("%d other", s.Other))
+	}
+	fmt.Fprintf(&b, "%s.\n", strings.Join(parts, ", "))
+
+	for _, g := range plan.Groups {
+		stack := g.Stack
+		if stack == "" {
+			stack = "(none)"
+		}
+		fmt.Fprintf(&b, "\n### Stack `%s`", stack)
+		if g.Target != "" {
+			fmt.Fprintf(&b, " on target `%s`", g.Target)
+		}
+		b.WriteString("\n")
+		current := ""
+		for _, c := range g.Changes {
+			if c.Action != current {
+				current = c.Action
+				fmt.Fprintf(&b, "\n**%s**\n", actionHeading(c.Action))
+			}
+			fmt.Fprintf(&b, "- `%s` %s", c.Type, c.Label)
+			if c.ReplaceReason != "" {
+				fmt.Fprintf(&b, " — %s", c.ReplaceReason)
+			}
+			b.WriteString("\n")
+			for _, p := range c.Properties {
+				fmt.Fprintf(&b, "  - `%s` %s\n", p.Path, describePropertyChange(p))
+			}
+		}
+	}
+
+	if len(plan.Targets) > 0 {
+		b.WriteString("\n### Targets\n\n")
+		for _, t := range plan.Targets {
+			fmt.Fprintf(&b, "- %s `%s`\n", t.Action, t.Label)
+		}
+	}
+	return b.String()
+}
+
+func actionHeading(action string) string {
+	switch action {
+	case actionCreate:
+		return "Create"
+	case actionUpdate:
+		return "Update"
+	case actionReplace:
+		return "Replace (destroy and recreate)"
+	case actionDelete:
+		return "Delete"
+	case "":
+		return "Other"
+	}
+	return strings.ToUpper(action[:1]) + action[1:]
+}
+
+func describePropertyChange(p tools.PropertyChange) string {
+	switch p.Op {
+	case "add":
+		return "added: " + formatPlanValue(p.New)
+	case "remove":
+		if p.Old != nil {
+			return "removed (was " + formatPlanValue(p.Old) + ")"
+		}
+		return "removed"
+	}
+	if p.Old != nil {
+		return formatPlanValue(p.Old) + " → " + formatPlanValue(p.New)
+	}
+	return "→ " + formatPlanValue(p.New)
+}
+
+// maxPlanValueLen keeps one long policy document from swamping the plan.
+const maxPlanValueLen = 80
+
+func formatPlanValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	s := string(data)
+	if len(s) > maxPlanValueLen {
+		// Cut at the start of a rune, so a multi-byte character is not split.
+		cut := maxPlanValueLen - 3
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut] + "..."
+	}
+	return "`" + s + "`"
+}
+
+// simulationResult renders a simulate response as a markdown plan with the
+// structured plan as the second return value (structured content). A response
+// that does not parse as a simulation is passed through unchanged.
+func simulationResult(command string, body json.RawMessage) (*mcp.CallToolResult, *tools.ChangePlan) {
+	plan, err := buildChangePlan(command, body)
+	if err != nil {
+		return jsonResult(body), nil
+	}
+	return textResult(renderChangePlan(plan)), &plan
+}
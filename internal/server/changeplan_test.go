@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+const simulatedApplyBody = `{
+  "CommandID": "cmd-sim-1",
+  "Simulation": {
+    "ChangesRequired": true,
+    "Command": {
+      "Command": "apply",
+      "ResourceUpdates": [
+        {"ResourceLabel": "logs", "ResourceType": "AWS::S3::Bucket", "StackName": "prod", "Target": "aws-prod", "Operation": "create",
+         "Properties": {"BucketName": "logs"}},
+        {"ResourceLabel": "web", "ResourceType": "AWS::EC2::Instance", "StackName": "prod", "Target": "aws-prod", "Operation": "update",
+         "OldProperties": {"InstanceType": "t3.small", "Tags": [{"Key": "env", "Value": "prod"}]},
+         "Properties": {"InstanceType": "t3.large", "Tags": [{"Key": "env", "Value": "prod"}]}},
+        {"ResourceLabel": "db", "ResourceType": "AWS::RDS::DBInstance", "StackName": "prod", "Target": "aws-prod", "Operation": "delete",
+         "Properties": {"Engine": "postgres"}},
+        {"ResourceLabel": "db", "ResourceType": "AWS::RDS::DBInstance", "StackName": "prod", "Target": "aws-prod", "Operation": "create",
+         "Properties": {"Engine": "aurora-postgresql"}},
+        {"ResourceLabel": "old-queue", "ResourceType": "AWS::SQS::Queue", "StackName": "dev", "Target": "aws-dev", "Operation": "delete"}
+      ],
+      "TargetUpdates": [{"TargetLabel": "aws-dev", "Operation": "update"}]
+    }
+  }
+}`
+
+func TestBuildChangePlan(t *testing.T) {
+	plan, err := buildChangePlan("apply", []byte(simulatedApplyBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.ChangesRequired || plan.CommandID != "cmd-sim-1" {
+		t.Fatalf("unexpected plan header: %+v", plan)
+	}
+	want := tools.ChangePlanSummary{Create: 1, Update: 1, Replace: 1, Delete: 1}
+	if plan.Summary != want {
+		t.Errorf("summary = %+v, want %+v", plan.Summary, want)
+	}
+	if len(plan.Groups) != 2 || plan.Groups[0].Stack != "dev" || plan.Groups[1].Stack != "prod" || plan.Groups[1].Target != "aws-prod" {
+		t.Fatalf("unexpected groups: %+v", plan.Groups)
+	}
+
+	prod := plan.Groups[1].Changes
+	var actions []string
+	for _, c := range prod {
+		actions = append(actions, c.Action+":"+c.Label)
+	}
+	if got := strings.Join(actions, ","); got != "create:logs,update:web,replace:db" {
+		t.Errorf("prod changes = %s", got)
+	}
+
+	update := prod[1]
+	if len(update.Properties) != 1 || update.Properties[0].Path != "/InstanceType" ||
+		update.Properties[0].Old != "t3.small" || update.Properties[0].New != "t3.large" {
+		t.Errorf("update properties = %+v", update.Properties)
+	}
+	replace := prod[2]
+	if !strings.Contains(replace.ReplaceReason, "createOnly") || strings.Contains(replace.ReplaceReason, "/") {
+		t.Errorf("replace reason = %q, want the generic createOnly sentence without paths", replace.ReplaceReason)
+	}
+	if len(replace.Properties) == 0 {
+		t.Errorf("replace properties = %+v, want the changed properties listed", replace.Properties)
+	}
+	if len(plan.Targets) != 1 || plan.Targets[0].Label != "aws-dev" {
+		t.Errorf("targets = %+v", plan.Targets)
+	}
+}
+
+func TestBuildChangePlan_PatchDocument(t *testing.T) {
+	body := `{"Simulation":{"ChangesRequired":true,"Command":{"ResourceUpdates":[
+	  {"ResourceLabel":"web","ResourceType":"AWS::EC2::Instance","StackName":"prod","Operation":"update",
+	   "OldProperties":{"InstanceType":"t3.small"},
+	   "PatchDocument":[{"op":"replace","path":"/InstanceType","value":"t3.large"},{"op":"add","path":"/Monitoring","value":true}]}]}}}`
+	plan, err := buildChangePlan("apply", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := plan.Groups[0].Changes[0].Properties
+	if len(props) != 2 || props[0].Old != "t3.small" || props[0].New != "t3.large" || props[1].Op != "add" || props[1].Old != nil {
+		t.Errorf("properties = %+v", props)
+	}
+}
+
+func TestBuildChangePlan_NotASimulation(t *testing.T) {
+	if _, err := buildChangePlan("apply", []byte(`{"CommandID":"cmd-1"}`)); err == nil {
+		t.Fatal("expected error for a response without Simulation")
+	}
+}
+
+func TestRenderChangePlan(t *testing.T) {
+	plan, err := buildChangePlan("apply", []byte(simulatedApplyBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := renderChangePlan(plan)
+	for _, want := range []string{
+		"## Simulated apply",
+		"1 to create, 1 to update, 1 to replace, 1 to delete.",
+		"### Stack `prod` on target `aws-prod`",
+		"**Replace (destroy and recreate)**",
+		"`/InstanceType` `\"t3.small\"` → `\"t3.large\"`",
+		"### Targets",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("rendered plan missing %q:\n%s", want, md)
+		}
+	}
+	if strings.Index(md, "`dev`") > strings.Index(md, "`prod`") {
+		t.Error("groups should be ordered by stack")
+	}
+
+	empty := renderChangePlan(tools.ChangePlan{Command: "destroy"})
+	if !strings.Contains(empty, "No changes required.") {
+		t.Errorf("empty plan rendering = %q", empty)
+	}
+}
+
+func TestFormatPlanValue(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"short", "abc", "`\"abc\"`"},
+		{"ascii cut", strings.Repeat("a", 100), "`\"" + strings.Repeat("a", 76) + "...`"},
+		// The 77-byte cut falls inside the third byte of a 4-byte rune.
+		{"rune boundary", strings.Repeat("a", 73) + "🙂🙂", "`\"" + strings.Repeat("a", 73) + "...`"},
+	} {
+		got := formatPlanValue(tc.in)
+		if got != tc.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tc.name, got, tc.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: got:\n%q\nwant:\nvalid UTF-8", tc.name, got)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	var old, updated any
+	_ = json.Unmarshal([]byte(`{"a":1,"b":{"c":"x","d~/e":[1,2]},"gone":true}`), &old)
+	_ = json.Unmarshal([]byte(`{"a":2,"b":{"c":"x","d~/e":[1]},"new":"y"}`), &updated)
+	got := diffJSON(old, updated)
+	var paths []string
+	for _, c := range got {
+		paths = append(paths, c.Op+" "+c.Path)
+	}
+	want := "replace /a,remove /b/d~0~1e/1,remove /gone,add /new"
+	if strings.Join(paths, ",") != want {
+		t.Errorf("diff = %v, want %s", paths, want)
+	}
+	if v, ok := lookupPointer(old, "/b/d~0~1e/1"); !ok || v != float64(2) {
+		t.Errorf("lookupPointer = %v, %v", v, ok)
+	}
+}
+
+func TestDiffDeclared(t *testing.T) {
+	var desired, synced any
+	_ = json.Unmarshal([]byte(`{"a":1,"b":{"c":"x"},"gone":true,"tags":[{"k":"v"}]}`), &desired)
+	_ = json.Unmarshal([]byte(`{"a":2,"b":{"c":"x","id":"b-1"},"arn":"arn:x","tags":[{"k":"v","default":0},{"k":"w"}]}`), &synced)
+	var paths []string
+	for _, c := range diffDeclared(desired, synced) {
+		paths = append(paths, c.Op+" "+c.Path)
+	}
+	if want := "replace /a,remove /gone,add /tags/1"; strings.Join(paths, ",") != want {
+		t.Errorf("diff = %v, want %s", paths, want)
+	}
+}
+
+func TestApplyForma_SimulateRendersPlan(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"POST /api/v1/commands": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprint(w, simulatedApplyBody)
+		},
+	})
+	defer agent.Close()
+
+	file := t.TempDir() + "/main.json"
+	if err := writeTestFile(file, `{"Stacks":[{"Label":"prod"}]}`); err != nil {
+		t.Fatal(err)
+	}
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "apply_forma",
+		Arguments: map[string]any{"file_path": file, "mode": "reconcile", "simulate": true},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	if !strings.Contains(textContent(t, result), "## Simulated apply") {
+		t.Errorf("expected markdown plan, got %s", textContent(t, result))
+	}
+
+	data, err := json.Marshal(result.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan tools.ChangePlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Summary.Replace != 1 || !strings.HasPrefix(plan.PlanToken, "plan-") {
+		t.Errorf("structured plan = %+v", plan)
+	}
+}
@@ -2,19 +2,32 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 )
 
 // FormaeClient is a lightweight HTTP client for the formae agent REST API.
 type FormaeClient struct {
 	endpoint   string
 	httpClient *http.Client
+	retry      retryPolicy
 }
 
 func NewFormaeClient(endpoint string) *FormaeClient {
@@ -23,20 +36,85 @@ func NewFormaeClient(endpoint string) *FormaeClient {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		retry: defaultRetryPolicy,
+	}
+}
+
+// retryPolicy bounds how idempotent GETs are retried: up to attempts tries,
+// sleeping a random duration up to base*2^n (capped at max) between them.
+type retryPolicy struct {
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+// defaultRetryPolicy is copied into every new client. A variable so tests can
+// shorten it.
+var defaultRetryPolicy = retryPolicy{attempts: 4, base: 250 * time.Millisecond, max: 2 * time.Second}
+
+// backoff returns the full-jitter delay before retry number n (0-based).
+func (p retryPolicy) backoff(n int) time.Duration {
+	ceiling := p.base << n
+	if ceiling <= 0 || ceiling > p.max {
+		ceiling = p.max
+	}
+	if ceiling <= 0 {
+		return 0
 	}
+	return rand.N(ceiling)
+}
+
+// isRetryableStatus reports whether a response status means a gateway or the
+// agent is temporarily unable to answer.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// isRetryableTransportError reports whether a request that got no response is
+// worth repeating: the agent refused or reset the connection, or timed out.
+func isRetryableTransportError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// withoutRetries returns a copy of c that sends each request once, for
+// callers that would rather go without an answer than wait for one.
+func (c *FormaeClient) withoutRetries() *FormaeClient {
+	once := *c
+	once.retry = retryPolicy{attempts: 1}
+	return &once
 }
 
-func (c *FormaeClient) get(path string, query url.Values) ([]byte, int, error) {
+func (c *FormaeClient) url(path string, query url.Values) string {
 	u := c.endpoint + path
 	if len(query) > 0 {
 		u += "?" + query.Encode()
 	}
+	return u
+}
 
-	resp, err := c.httpClient.Get(u)
+// do sends req once. A request that gets no response fails with an
+// ErrAgentUnreachable *AgentError, unless the request's context ended first.
+func (c *FormaeClient) do(req *http.Request) ([]byte, int, error) {
+	req, span := telemetry.StartHTTP(req)
+	defer span.End()
+	telemetry.Inject(req)
+	ctx := req.Context()
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
+		if ctx.Err() != nil {
+			return nil, 0, fmt.Errorf("agent request %s: %w", req.URL.Path, context.Cause(ctx))
+		}
+		telemetry.Fail(ctx, span, telemetry.ErrAgentUnreachable, err.Error())
+		return nil, 0, &AgentError{Kind: ErrAgentUnreachable, Endpoint: c.endpoint, Err: err, attempts: 1}
 	}
 	defer func() { _ = resp.Body.Close() }()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		telemetry.Fail(ctx, span, telemetry.ErrAgentStatus, resp.Status, attribute.Int("http.response.status_code", resp.StatusCode))
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -46,134 +124,179 @@ func (c *FormaeClient) get(path string, query url.Values) ([]byte, int, error) {
 	return body, resp.StatusCode, nil
 }
 
-func (c *FormaeClient) post(path string, query url.Values) ([]byte, int, error) {
-	u := c.endpoint + path
-	if len(query) > 0 {
-		u += "?" + query.Encode()
+// doIdempotent sends a body-less req, retrying connection failures, timeouts
+// and 502/503/504 responses with jittered exponential backoff. Only safe for
+// requests the agent can receive twice.
+func (c *FormaeClient) doIdempotent(req *http.Request) ([]byte, int, error) {
+	attempts := max(c.retry.attempts, 1)
+	for n := 1; ; n++ {
+		body, status, err := c.do(req)
+		var agentErr *AgentError
+		retryable := (errors.As(err, &agentErr) && isRetryableTransportError(agentErr.Err)) ||
+			(err == nil && isRetryableStatus(status))
+		if !retryable || n >= attempts {
+			if agentErr != nil {
+				agentErr.attempts = n
+			}
+			return body, status, err
+		}
+		delay := c.retry.backoff(n - 1)
+		reason := fmt.Sprintf("status %d", status)
+		if agentErr != nil {
+			reason = agentErr.Err.Error()
+		}
+		slog.InfoContext(req.Context(), "agent request failed, retrying",
+			"path", req.URL.Path, "attempt", n, "of", attempts, "reason", reason, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, 0, fmt.Errorf("agent request %s: %w", req.URL.Path, context.Cause(req.Context()))
+		}
 	}
+}
 
-	resp, err := c.httpClient.Post(u, "application/json", nil)
+func (c *FormaeClient) get(ctx context.Context, path string, query url.Values) ([]byte, int, error) {
+	return c.getWithHeaders(ctx, path, query, nil)
+}
+
+func (c *FormaeClient) getWithHeaders(ctx context.Context, path string, query url.Values, headers map[string]string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url(path, query), nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return c.doIdempotent(req)
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (c *FormaeClient) post(ctx context.Context, path string, query url.Values) ([]byte, int, error) {
+	return c.postWithHeaders(ctx, path, query, nil)
+}
+
+func (c *FormaeClient) postWithHeaders(ctx context.Context, path string, query url.Values, headers map[string]string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url(path, query), nil)
 	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return c.do(req)
+}
 
-	return body, resp.StatusCode, nil
+// queryTerm renders one field:value term of an agent query, quoting the value
+// so labels with spaces, colons or query syntax match literally.
+func queryTerm(field, value string) string {
+	return field + ":" + `"` + queryValueEscaper.Replace(value) + `"`
 }
 
+var queryValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
 // ListResources queries the agent for resources matching the given query string.
-func (c *FormaeClient) ListResources(query string) (json.RawMessage, error) {
+func (c *FormaeClient) ListResources(ctx context.Context, query string) ([]model.Resource, error) {
 	q := url.Values{}
 	if query != "" {
 		q.Set("query", query)
 	}
 
-	body, status, err := c.get("/api/v1/resources", q)
+	body, status, err := c.get(ctx, "/api/v1/resources", q)
 	if err != nil {
 		return nil, err
 	}
 	if status == http.StatusNotFound {
-		return json.RawMessage("[]"), nil
+		return []model.Resource{}, nil
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
-	return body, nil
+	return model.DecodeList[model.Resource](body, "Resources")
 }
 
 // ListStacks retrieves all stacks from the agent.
-func (c *FormaeClient) ListStacks() (json.RawMessage, error) {
-	body, status, err := c.get("/api/v1/stacks", nil)
+func (c *FormaeClient) ListStacks(ctx context.Context) ([]model.Stack, error) {
+	body, status, err := c.get(ctx, "/api/v1/stacks", nil)
 	if err != nil {
 		return nil, err
 	}
 	if status == http.StatusNotFound {
-		return json.RawMessage("[]"), nil
+		return []model.Stack{}, nil
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
-	return body, nil
+	return model.DecodeList[model.Stack](body, "Stacks")
 }
 
 // ListPolicies retrieves all standalone policies from the agent.
-func (c *FormaeClient) ListPolicies() (json.RawMessage, error) {
-	body, status, err := c.get("/api/v1/policies", nil)
+func (c *FormaeClient) ListPolicies(ctx context.Context) ([]model.Policy, error) {
+	body, status, err := c.get(ctx, "/api/v1/policies", nil)
 	if err != nil {
 		return nil, err
 	}
 	if status == http.StatusNotFound {
-		return json.RawMessage("[]"), nil
+		return []model.Policy{}, nil
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
-	return body, nil
+	return model.DecodeList[model.Policy](body, "Policies")
 }
 
 // ListTargets queries the agent for targets matching the given query string.
-func (c *FormaeClient) ListTargets(query string) (json.RawMessage, error) {
+func (c *FormaeClient) ListTargets(ctx context.Context, query string) ([]model.Target, error) {
 	q := url.Values{}
 	if query != "" {
 		q.Set("query", query)
 	}
 
-	body, status, err := c.get("/api/v1/targets", q)
+	body, status, err := c.get(ctx, "/api/v1/targets", q)
 	if err != nil {
 		return nil, err
 	}
 	if status == http.StatusNotFound {
-		return json.RawMessage("[]"), nil
+		return []model.Target{}, nil
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
-	return body, nil
+	return model.DecodeList[model.Target](body, "Targets")
 }
 
 // GetCommandStatus retrieves the status of a specific command.
-func (c *FormaeClient) GetCommandStatus(commandID string, clientID string) (json.RawMessage, error) {
+func (c *FormaeClient) GetCommandStatus(ctx context.Context, commandID string, clientID string) (model.CommandList, error) {
 	q := url.Values{}
 	q.Set("id", commandID)
 
-	req, err := http.NewRequest("GET", c.endpoint+"/api/v1/commands/status"+"?"+q.Encode(), nil)
+	body, status, err := c.getWithHeaders(ctx, "/api/v1/commands/status", q, map[string]string{"Client-ID": clientID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return model.CommandList{}, err
 	}
-	req.Header.Set("Client-ID", clientID)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	if status == http.StatusNotFound {
+		e := c.statusError(status, body)
+		e.Msg = fmt.Sprintf("command %s not found", commandID)
+		return model.CommandList{}, e
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if status != http.StatusOK {
+		return model.CommandList{}, c.statusError(status, body)
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("command %s not found", commandID)
+	var list model.CommandList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return model.CommandList{}, fmt.Errorf("parse command status: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return body, nil
+	return list, nil
 }
 
 // ListCommands retrieves command statuses matching an optional query.
-func (c *FormaeClient) ListCommands(query string, maxResults string, clientID string) (json.RawMessage, error) {
+func (c *FormaeClient) ListCommands(ctx context.Context, query string, maxResults string, clientID string) (model.CommandList, error) {
 	q := url.Values{}
 	if query != "" {
 		q.Set("query", query)
@@ -182,61 +305,60 @@ func (c *FormaeClient) ListCommands(query string, maxResults string, clientID st
 		q.Set("max_results", maxResults)
 	}
 
-	req, err := http.NewRequest("GET", c.endpoint+"/api/v1/commands/status"+"?"+q.Encode(), nil)
+	body, status, err := c.getWithHeaders(ctx, "/api/v1/commands/status", q, map[string]string{"Client-ID": clientID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return model.CommandList{}, err
 	}
-	req.Header.Set("Client-ID", clientID)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	if status == http.StatusNotFound {
+		return model.CommandList{Commands: []model.Command{}}, nil
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if status != http.StatusOK {
+		return model.CommandList{}, c.statusError(status, body)
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return json.RawMessage(`{"Commands":[]}`), nil
+	var list model.CommandList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return model.CommandList{}, fmt.Errorf("parse commands: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return body, nil
+	return list, nil
 }
 
 // GetAgentStats retrieves agent statistics.
-func (c *FormaeClient) GetAgentStats() (json.RawMessage, error) {
-	body, status, err := c.get("/api/v1/stats", nil)
+func (c *FormaeClient) GetAgentStats(ctx context.Context) (model.Stats, error) {
+	body, status, err := c.get(ctx, "/api/v1/stats", nil)
 	if err != nil {
-		return nil, err
+		return model.Stats{}, err
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return model.Stats{}, c.statusError(status, body)
 	}
 
-	return body, nil
+	var stats model.Stats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return model.Stats{}, fmt.Errorf("parse stats: %w", err)
+	}
+	return stats, nil
 }
 
 // CheckHealth checks if the agent is healthy.
-func (c *FormaeClient) CheckHealth() error {
-	_, status, err := c.get("/api/v1/health", nil)
+func (c *FormaeClient) CheckHealth(ctx context.Context) error {
+	body, status, err := c.get(ctx, "/api/v1/health", nil)
 	if err != nil {
-		return fmt.Errorf("agent is not reachable: %w", err)
+		return err
 	}
 	if status != http.StatusOK {
-		return fmt.Errorf("agent returned unhealthy status: %d", status)
+		e := c.statusError(status, body)
+		if e.Kind == nil {
+			e.Msg = fmt.Sprintf("agent returned unhealthy status: %d", status)
+		}
+		return e
 	}
 
 	return nil
 }
 
 // SubmitCommand submits a forma command (apply/destroy) to the agent.
-func (c *FormaeClient) SubmitCommand(command string, mode string, simulate bool, force bool, formaJSON []byte, clientID string) (json.RawMessage, error) {
+func (c *FormaeClient) SubmitCommand(ctx context.Context, command string, mode string, simulate bool, force bool, formaJSON []byte, clientID string) (json.RawMessage, error) {
 	fields := map[string]string{
 		"command":  command,
 		"simulate": fmt.Sprintf("%t", simulate),
@@ -256,31 +378,31 @@ func (c *FormaeClient) SubmitCommand(command string, mode string, simulate bool,
 		fileContent = formaJSON
 	}
 
-	body, status, err := c.postMultipartWithHeaders("/api/v1/commands", nil, fields, fileField, fileName, fileContent, map[string]string{"Client-ID": clientID})
+	body, status, err := c.postMultipartWithHeaders(ctx, "/api/v1/commands", nil, fields, fileField, fileName, fileContent, map[string]string{"Client-ID": clientID})
 	if err != nil {
 		return nil, err
 	}
 	if !isCommandStatusOK(status, simulate) {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
 	return body, nil
 }
 
 // DestroyByQuery submits a destroy-by-query command to the agent.
-func (c *FormaeClient) DestroyByQuery(query string, simulate bool, clientID string) (json.RawMessage, error) {
+func (c *FormaeClient) DestroyByQuery(ctx context.Context, query string, simulate bool, clientID string) (json.RawMessage, error) {
 	fields := map[string]string{
 		"command":  "destroy",
 		"query":    query,
 		"simulate": fmt.Sprintf("%t", simulate),
 	}
 
-	body, status, err := c.postMultipartWithHeaders("/api/v1/commands", nil, fields, "", "", nil, map[string]string{"Client-ID": clientID})
+	body, status, err := c.postMultipartWithHeaders(ctx, "/api/v1/commands", nil, fields, "", "", nil, map[string]string{"Client-ID": clientID})
 	if err != nil {
 		return nil, err
 	}
 	if !isCommandStatusOK(status, simulate) {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 
 	return body, nil
@@ -294,112 +416,99 @@ func isCommandStatusOK(status int, simulate bool) bool {
 }
 
 // CancelCommands cancels running commands matching an optional query.
-func (c *FormaeClient) CancelCommands(query string, clientID string) (json.RawMessage, error) {
+func (c *FormaeClient) CancelCommands(ctx context.Context, query string, clientID string) (json.RawMessage, error) {
 	q := url.Values{}
 	if query != "" {
 		q.Set("query", query)
 	}
 
-	req, err := http.NewRequest("POST", c.endpoint+"/api/v1/commands/cancel"+"?"+q.Encode(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Client-ID", clientID)
-
-	resp, err := c.httpClient.Do(req)
+	body, status, err := c.postWithHeaders(ctx, "/api/v1/commands/cancel", q, map[string]string{"Client-ID": clientID})
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
+	if status == http.StatusNotFound {
 		return json.RawMessage(`{"CommandIds":[]}`), nil
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		return nil, fmt.Errorf("agent returned status %d: %s", resp.StatusCode, string(body))
+	if status != http.StatusAccepted {
+		return nil, c.statusError(status, body)
 	}
 
 	return body, nil
 }
 
 // ListChangesSinceLastReconcile retrieves modifications since last reconcile for a stack.
-func (c *FormaeClient) ListChangesSinceLastReconcile(stack string) (json.RawMessage, error) {
+func (c *FormaeClient) ListChangesSinceLastReconcile(ctx context.Context, stack string) (model.StackChanges, error) {
 	path := fmt.Sprintf("/api/v1/stacks/%s/changes-since-last-reconcile", url.PathEscape(stack))
-	body, status, err := c.get(path, nil)
+	body, status, err := c.get(ctx, path, nil)
 	if err != nil {
-		return nil, err
+		return model.StackChanges{}, err
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return model.StackChanges{}, c.statusError(status, body)
 	}
 
-	return body, nil
+	var changes model.StackChanges
+	if err := json.Unmarshal(body, &changes); err != nil {
+		return model.StackChanges{}, fmt.Errorf("failed to parse drift for stack %s: %w", stack, err)
+	}
+	return changes, nil
 }
 
 // ForceSync triggers an immediate resource synchronization.
-func (c *FormaeClient) ForceSync() error {
-	_, status, err := c.post("/api/v1/admin/synchronize", nil)
+func (c *FormaeClient) ForceSync(ctx context.Context) error {
+	body, status, err := c.post(ctx, "/api/v1/admin/synchronize", nil)
 	if err != nil {
 		return err
 	}
 	if status != http.StatusOK {
-		return fmt.Errorf("agent returned status %d", status)
+		return c.statusError(status, body)
 	}
 
 	return nil
 }
 
 // ForceDiscover triggers an immediate resource discovery.
-func (c *FormaeClient) ForceDiscover() error {
-	_, status, err := c.post("/api/v1/admin/discover", nil)
+func (c *FormaeClient) ForceDiscover(ctx context.Context) error {
+	body, status, err := c.post(ctx, "/api/v1/admin/discover", nil)
 	if err != nil {
 		return err
 	}
 	if status != http.StatusOK {
-		return fmt.Errorf("agent returned status %d", status)
+		return c.statusError(status, body)
 	}
 
 	return nil
 }
 
 // ForceCheckTTL triggers an immediate TTL expiry sweep.
-func (c *FormaeClient) ForceCheckTTL() (json.RawMessage, error) {
-	body, status, err := c.post("/api/v1/admin/check-ttl", nil)
+func (c *FormaeClient) ForceCheckTTL(ctx context.Context) (json.RawMessage, error) {
+	body, status, err := c.post(ctx, "/api/v1/admin/check-ttl", nil)
 	if err != nil {
 		return nil, err
 	}
 	if status != http.StatusOK {
-		return nil, fmt.Errorf("agent returned status %d: %s", status, string(body))
+		return nil, c.statusError(status, body)
 	}
 	return body, nil
 }
 
 // ForceReconcileStack triggers a one-shot reconcile for a specific stack.
-// Returns the response body and HTTP status. On non-2xx status, error is non-nil
-// but body is also returned so callers can surface the agent's error JSON.
-func (c *FormaeClient) ForceReconcileStack(label string) (json.RawMessage, int, error) {
+// Returns the response body and HTTP status. On non-2xx status, error is a
+// *AgentError carrying the agent's error JSON; body is also returned.
+func (c *FormaeClient) ForceReconcileStack(ctx context.Context, label string) (json.RawMessage, int, error) {
 	path := fmt.Sprintf("/api/v1/stacks/%s/reconcile", url.PathEscape(label))
-	body, status, err := c.post(path, nil)
+	body, status, err := c.post(ctx, path, nil)
 	if err != nil {
 		return nil, 0, err
 	}
 	if status != http.StatusOK && status != http.StatusAccepted {
-		return body, status, fmt.Errorf("agent returned status %d", status)
+		return body, status, c.statusError(status, body)
 	}
 	return body, status, nil
 }
 
-func (c *FormaeClient) postMultipartWithHeaders(path string, query url.Values, fields map[string]string, fileField, fileName string, fileContent []byte, headers map[string]string) ([]byte, int, error) {
-	u := c.endpoint + path
-	if len(query) > 0 {
-		u += "?" + query.Encode()
-	}
-
+func (c *FormaeClient) postMultipartWithHeaders(ctx context.Context, path string, query url.Values, fields map[string]string, fileField, fileName string, fileContent []byte, headers map[string]string) ([]byte, int, error) {
 	var buf bytes.Buffer
 	w := multipart.NewWriter(&buf)
 
@@ -423,7 +532,7 @@ func (c *FormaeClient) postMultipartWithHeaders(path string, query url.Values, f
 		return nil, 0, fmt.Errorf("failed to close multipart writer: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", u, &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url(path, query), &buf)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -432,16 +541,5 @@ func (c *FormaeClient) postMultipartWithHeaders(path string, query url.Values, f
 		req.Header.Set(k, v)
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	return body, resp.StatusCode, nil
+	return c.do(req)
 }
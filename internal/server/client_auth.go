@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/config"
+)
+
+// tokenCommandTimeout bounds a profile's tokenCommand so a hung credential
+// helper cannot stall a tool call.
+const tokenCommandTimeout = 10 * time.Second
+
+// NewFormaeClientWithAuth builds a FormaeClient whose transport carries the
+// profile's auth settings: a bearer token on every request, and the CA
+// bundle, client certificate and verification mode for TLS. Clients for the
+// same endpoint and settings share one transport, so tool calls reuse its
+// connections and token.
+func NewFormaeClientWithAuth(ctx context.Context, endpoint string, auth config.APIAuth) (*FormaeClient, error) {
+	c := NewFormaeClient(endpoint)
+	if auth.IsZero() {
+		return c, nil
+	}
+	rt, err := agentTransportFor(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+	// Resolve the token now, so a broken tokenCommand fails the tool call
+	// with its own message rather than as an unreachable agent.
+	if _, err := rt.currentToken(ctx); err != nil {
+		return nil, err
+	}
+	c.httpClient.Transport = rt
+	return c, nil
+}
+
+// agentTransports holds the transport for each endpoint and auth settings
+// seen so far. A profile edit changes the settings and so gets a new one.
+var agentTransports = struct {
+	mu sync.Mutex
+	m  map[string]*authTransport
+}{m: map[string]*authTransport{}}
+
+func agentTransportFor(endpoint string, auth config.APIAuth) (*authTransport, error) {
+	key := fmt.Sprintf("%s\x00%#v", endpoint, auth)
+	agentTransports.mu.Lock()
+	defer agentTransports.mu.Unlock()
+	if rt, ok := agentTransports.m[key]; ok {
+		return rt, nil
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := agentTLSConfig(auth)
+	if err != nil {
+		return nil, err
+	}
+	base.TLSClientConfig = tlsConfig
+	rt := &authTransport{base: base, auth: auth}
+	agentTransports.m[key] = rt
+	return rt, nil
+}
+
+// agentTLSConfig builds the TLS client config for the agent connection.
+func agentTLSConfig(auth config.APIAuth) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: auth.InsecureSkipVerify,
+	}
+	if auth.CABundle != "" {
+		pem, err := os.ReadFile(auth.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", auth.CABundle)
+		}
+		cfg.RootCAs = pool
+	}
+	switch {
+	case auth.ClientCert != "" && auth.ClientKey != "":
+		cert, err := tls.LoadX509KeyPair(auth.ClientCert, auth.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case auth.ClientCert != "" || auth.ClientKey != "":
+		return nil, fmt.Errorf("cli.api clientCert and clientKey must be set together")
+	}
+	return cfg, nil
+}
+
+// resolveAgentToken returns the static token, or runs the token command and
+// returns its trimmed stdout.
+func resolveAgentToken(ctx context.Context, auth config.APIAuth) (string, error) {
+	if auth.Token != "" || auth.TokenCommand == "" {
+		return auth.Token, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, tokenCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", auth.TokenCommand)
+	cmd.WaitDelay = subprocessWaitDelay
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("cli.api tokenCommand failed: %s", msg)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("cli.api tokenCommand printed no token")
+	}
+	return token, nil
+}
+
+// tokenExpiryMargin is how long before a token's expiry it is replaced, so
+// it does not run out between being sent and being checked.
+const tokenExpiryMargin = 30 * time.Second
+
+// authTransport sends requests over the profile's TLS settings with its
+// bearer token. A tokenCommand token is kept until it expires (for a JWT) or
+// the agent rejects it with 401; only then does the command run again.
+type authTransport struct {
+	base *http.Transport
+	auth config.APIAuth
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero when the token carries no expiry
+}
+
+// currentToken returns the token to send, running tokenCommand when there
+// is none yet or it has expired.
+func (t *authTransport) currentToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && (t.expires.IsZero() || time.Now().Before(t.expires)) {
+		return t.token, nil
+	}
+	token, err := resolveAgentToken(ctx, t.auth)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expires = token, tokenExpiry(token)
+	return token, nil
+}
+
+// forget drops token if it is still the current one, so the next request
+// runs tokenCommand again.
+func (t *authTransport) forget(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+	}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.currentToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || t.auth.Token != "" || t.auth.TokenCommand == "" {
+		return resp, err
+	}
+	// The agent rejected a command token before its expiry (revoked or
+	// rotated): fetch a fresh one and retry once, if the body can be resent.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	t.forget(token)
+	fresh, err := t.currentToken(req.Context())
+	if err != nil || fresh == token {
+		return resp, nil
+	}
+	retry := req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry = req.Clone(req.Context())
+		retry.Body = body
+	}
+	_ = resp.Body.Close()
+	return t.send(retry, fresh)
+}
+
+func (t *authTransport) send(req *http.Request, token string) (*http.Response, error) {
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// tokenExpiry returns when a JWT bearer token should be replaced, from its
+// exp claim, or the zero time for any other token.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0).Add(-tokenExpiryMargin)
+}
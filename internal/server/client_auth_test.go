@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/config"
+)
+
+// writeSelfSignedCert writes a self-signed client certificate and key as PEM
+// files into dir and returns their paths and the parsed certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (certPath, keyPath string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "formae-mcp-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath = filepath.Join(dir, "client.crt")
+	keyPath = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath, cert
+}
+
+// writeServerCA writes the httptest TLS server's certificate as a CA bundle.
+func writeServerCA(t *testing.T, srv *httptest.Server, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFormaeClientAuth_MTLSAndTokenOnEveryPath(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, clientCert := writeSelfSignedCert(t, dir)
+
+	var seen []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cret" {
+			t.Errorf("%s %s: Authorization = %q", r.Method, r.URL.Path, got)
+		}
+		if len(r.TLS.PeerCertificates) == 0 {
+			t.Errorf("%s %s: no client certificate presented", r.Method, r.URL.Path)
+		}
+		seen = append(seen, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v1/commands":
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"CommandID":"c1"}`))
+		case "/api/v1/stacks":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte(`{"Commands":[]}`))
+		}
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	c, err := NewFormaeClientWithAuth(context.Background(), srv.URL, config.APIAuth{
+		TokenCommand: "printf 's3cret\\n'",
+		CABundle:     writeServerCA(t, srv, dir),
+		ClientCert:   certPath,
+		ClientKey:    keyPath,
+	})
+	if err != nil {
+		t.Fatalf("NewFormaeClientWithAuth: %v", err)
+	}
+
+	if _, err := c.ListStacks(context.Background()); err != nil {
+		t.Errorf("plain GET: %v", err)
+	}
+	if _, err := c.ListCommands(context.Background(), "", "", "formae-mcp"); err != nil {
+		t.Errorf("Client-ID GET: %v", err)
+	}
+	if _, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, []byte(`{}`), "formae-mcp"); err != nil {
+		t.Errorf("multipart submit: %v", err)
+	}
+	if len(seen) != 3 {
+		t.Errorf("requests seen = %v", seen)
+	}
+}
+
+func TestFormaeClientAuth_UntrustedServerRejected(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	c, err := NewFormaeClientWithAuth(context.Background(), srv.URL, config.APIAuth{Token: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListStacks(context.Background()); err == nil {
+		t.Fatal("expected certificate verification failure without a CA bundle")
+	}
+
+	c, err = NewFormaeClientWithAuth(context.Background(), srv.URL, config.APIAuth{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListStacks(context.Background()); err != nil {
+		t.Fatalf("insecureSkipVerify should accept the test certificate: %v", err)
+	}
+}
+
+func TestFormaeClientAuth_ConfigErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		auth config.APIAuth
+		want string
+	}{
+		{"cert without key", config.APIAuth{ClientCert: "/x.crt"}, "must be set together"},
+		{"missing CA bundle", config.APIAuth{CABundle: filepath.Join(t.TempDir(), "nope.pem")}, "CA bundle"},
+		{"failing token command", config.APIAuth{TokenCommand: "echo denied >&2; exit 1"}, "denied"},
+		{"empty token command output", config.APIAuth{TokenCommand: "true"}, "no token"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewFormaeClientWithAuth(context.Background(), "https://agent", tc.auth)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormaeClientAuth_TokenCommandCachedUntilRejected(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	// Prints tok1, tok2, ... counting its own runs.
+	command := "echo x >> " + runs + "; echo tok$(wc -l < " + runs + " | tr -d ' ')"
+
+	accepted := "tok1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	auth := config.APIAuth{TokenCommand: command}
+	countRuns := func() int {
+		data, _ := os.ReadFile(runs)
+		return strings.Count(string(data), "\n")
+	}
+
+	for range 3 {
+		c, err := NewFormaeClientWithAuth(context.Background(), srv.URL, auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ListStacks(context.Background()); err != nil {
+			t.Fatalf("ListStacks: %v", err)
+		}
+	}
+	if n := countRuns(); n != 1 {
+		t.Errorf("got:\n%d tokenCommand runs for 3 clients\nwant:\n1", n)
+	}
+
+	// The agent stops accepting the token: the next request fetches a new
+	// one and is retried with it.
+	accepted = "tok2"
+	c, err := NewFormaeClientWithAuth(context.Background(), srv.URL, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListStacks(context.Background()); err != nil {
+		t.Fatalf("ListStacks after rotation: %v", err)
+	}
+	if n := countRuns(); n != 2 {
+		t.Errorf("got:\n%d tokenCommand runs\nwant:\n2", n)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	jwt := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	if got := tokenExpiry("opaque-token"); !got.IsZero() {
+		t.Errorf("got:\n%v\nwant:\nzero for an opaque token", got)
+	}
+	if got := tokenExpiry(jwt(`{"sub":"x"}`)); !got.IsZero() {
+		t.Errorf("got:\n%v\nwant:\nzero for a JWT without exp", got)
+	}
+	want := time.Unix(2000000000, 0).Add(-tokenExpiryMargin)
+	if got := tokenExpiry(jwt(`{"exp":2000000000}`)); !got.Equal(want) {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
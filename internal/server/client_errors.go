@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error kinds returned by FormaeClient. Match them with errors.Is; the
+// concrete *AgentError carries the status and the agent's response body.
+var (
+	// ErrAgentUnreachable means no HTTP response was received: connection
+	// refused, DNS or TLS failure, or a timeout.
+	ErrAgentUnreachable = errors.New("agent unreachable")
+	// ErrUnauthorized means the agent (or a proxy in front of it) rejected
+	// the request's credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound means the requested object does not exist on the agent.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request conflicts with the agent's current state.
+	ErrConflict = errors.New("conflict")
+	// ErrDriftRejected means the agent refused a command because resources
+	// changed outside formae since the last reconcile.
+	ErrDriftRejected = errors.New("rejected because of drift")
+)
+
+// AgentError is a failed request to the formae agent. Its message tells the
+// user what to do next rather than just echoing the status code.
+type AgentError struct {
+	// Kind is one of the Err* values above, or nil for other statuses.
+	Kind     error
+	Endpoint string
+	Status   int
+	Body     string
+	// Msg, when set, replaces the status-derived description.
+	Msg string
+	// Err is the transport error for ErrAgentUnreachable.
+	Err      error
+	attempts int
+}
+
+func (e *AgentError) Error() string {
+	detail := e.Msg
+	if detail == "" {
+		detail = strings.TrimSpace(e.Body)
+	}
+	switch e.Kind {
+	case ErrAgentUnreachable:
+		tries := ""
+		if e.attempts > 1 {
+			tries = fmt.Sprintf(" after %d attempts", e.attempts)
+		}
+		return fmt.Sprintf("formae agent at %s is unreachable%s: %v. Check that the agent is running (formae agent start) and that the profile's cli.api url and port are correct", e.Endpoint, tries, e.Err)
+	case ErrUnauthorized:
+		return fmt.Sprintf("agent rejected the request's credentials (status %d): %s. Check token/tokenCommand and the client certificate in the profile's cli.api block", e.Status, detail)
+	case ErrDriftRejected:
+		return fmt.Sprintf("agent rejected the command because resources changed outside formae since the last reconcile (status %d): %s. Absorb the changes into the code (fix-code-drift workflow) or re-run with force=true to overwrite them", e.Status, detail)
+	case ErrConflict:
+		return fmt.Sprintf("agent reported a conflict (status %d): %s. Another command may be working on the same resources; check list_commands and retry once it finishes", e.Status, detail)
+	case ErrNotFound:
+		if e.Msg != "" {
+			return e.Msg
+		}
+		return fmt.Sprintf("not found on the agent (status %d): %s", e.Status, detail)
+	}
+	if e.Msg != "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("agent returned status %d: %s", e.Status, detail)
+}
+
+func (e *AgentError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// statusError classifies a non-success agent response.
+func (c *FormaeClient) statusError(status int, body []byte) *AgentError {
+	e := &AgentError{Endpoint: c.endpoint, Status: status, Body: string(body)}
+	switch {
+	case status == http.StatusUnauthorized:
+		e.Kind = ErrUnauthorized
+	case status == http.StatusForbidden && !isJSONBody(body):
+		// The agent answers its own 403s (e.g. a stack without an
+		// auto-reconcile policy) with a JSON error; a bare 403 comes from
+		// the auth layer or a proxy in front of it.
+		e.Kind = ErrUnauthorized
+	case status == http.StatusNotFound:
+		e.Kind = ErrNotFound
+	case status == http.StatusConflict && mentionsDrift(body):
+		e.Kind = ErrDriftRejected
+	case status == http.StatusConflict:
+		e.Kind = ErrConflict
+	}
+	return e
+}
+
+func isJSONBody(body []byte) bool {
+	b := strings.TrimSpace(string(body))
+	return strings.HasPrefix(b, "{") || strings.HasPrefix(b, "[")
+}
+
+func mentionsDrift(body []byte) bool {
+	b := strings.ToLower(string(body))
+	return strings.Contains(b, "drift") || strings.Contains(b, "since last reconcile") ||
+		strings.Contains(b, "since the last reconcile") || strings.Contains(b, "out-of-band")
+}
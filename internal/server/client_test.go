@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"mime"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // newTestFormaeClient creates a FormaeClient pointed at the given httptest.Server.
@@ -43,7 +48,7 @@ func TestSubmitCommandAccepts202(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	body, err := c.SubmitCommand("apply", "reconcile", false, false, nil, "client-1")
+	body, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, nil, "client-1")
 	if err != nil {
 		t.Fatalf("SubmitCommand: unexpected error: %v", err)
 	}
@@ -70,7 +75,7 @@ func TestSubmitCommandAccepts200Simulate(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	body, err := c.SubmitCommand("apply", "reconcile", true, false, nil, "client-1")
+	body, err := c.SubmitCommand(context.Background(), "apply", "reconcile", true, false, nil, "client-1")
 	if err != nil {
 		t.Fatalf("SubmitCommand with simulate=true and 200: unexpected error: %v", err)
 	}
@@ -90,7 +95,7 @@ func TestSubmitCommandRejects200NonSimulate(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	_, err := c.SubmitCommand("apply", "reconcile", false, false, nil, "client-1")
+	_, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, nil, "client-1")
 	if err == nil {
 		t.Fatal("SubmitCommand: expected error for 200 without simulate=true, got nil")
 	}
@@ -105,7 +110,7 @@ func TestSubmitCommandRejectsOtherStatus(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	_, err := c.SubmitCommand("apply", "reconcile", false, false, nil, "client-1")
+	_, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, nil, "client-1")
 	if err == nil {
 		t.Fatal("SubmitCommand: expected error for 500, got nil")
 	}
@@ -125,7 +130,7 @@ func TestDestroyByQueryAccepts202(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	body, err := c.DestroyByQuery("stack=default", false, "client-1")
+	body, err := c.DestroyByQuery(context.Background(), "stack=default", false, "client-1")
 	if err != nil {
 		t.Fatalf("DestroyByQuery: unexpected error: %v", err)
 	}
@@ -152,7 +157,7 @@ func TestDestroyByQueryAccepts200Simulate(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	body, err := c.DestroyByQuery("stack=default", true, "client-1")
+	body, err := c.DestroyByQuery(context.Background(), "stack=default", true, "client-1")
 	if err != nil {
 		t.Fatalf("DestroyByQuery with simulate=true and 200: unexpected error: %v", err)
 	}
@@ -172,7 +177,7 @@ func TestDestroyByQueryRejects200NonSimulate(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	_, err := c.DestroyByQuery("stack=default", false, "client-1")
+	_, err := c.DestroyByQuery(context.Background(), "stack=default", false, "client-1")
 	if err == nil {
 		t.Fatal("DestroyByQuery: expected error for 200 without simulate=true, got nil")
 	}
@@ -187,8 +192,154 @@ func TestDestroyByQueryRejectsOtherStatus(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestFormaeClient(srv)
-	_, err := c.DestroyByQuery("stack=default", false, "client-1")
+	_, err := c.DestroyByQuery(context.Background(), "stack=default", false, "client-1")
 	if err == nil {
 		t.Fatal("DestroyByQuery: expected error for 500, got nil")
 	}
 }
+
+// fastRetry is a retry policy without real backoff delays.
+var fastRetry = retryPolicy{attempts: 3, base: time.Millisecond, max: time.Millisecond}
+
+// TestGetRetriesGatewayErrors verifies that idempotent GETs retry 502/503/504
+// and succeed once the agent answers.
+func TestGetRetriesGatewayErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			if r.Header.Get("Client-ID") != "client-1" {
+				t.Errorf("Client-ID lost on retry: %q", r.Header.Get("Client-ID"))
+			}
+			_, _ = w.Write([]byte(`{"Commands":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestFormaeClient(srv)
+	c.retry = fastRetry
+	if _, err := c.ListCommands(context.Background(), "", "", "client-1"); err != nil {
+		t.Fatalf("ListCommands: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("calls = %d, want 3", n)
+	}
+}
+
+// TestGetGivesUpAfterAttempts verifies the retry bound and that a persistent
+// gateway error surfaces as a status error.
+func TestGetGivesUpAfterAttempts(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer srv.Close()
+
+	c := newTestFormaeClient(srv)
+	c.retry = fastRetry
+	_, err := c.ListStacks(context.Background())
+	var agentErr *AgentError
+	if !errors.As(err, &agentErr) || agentErr.Status != http.StatusGatewayTimeout {
+		t.Fatalf("err = %v, want a 504 AgentError", err)
+	}
+	if n := calls.Load(); n != int32(fastRetry.attempts) {
+		t.Errorf("calls = %d, want %d", n, fastRetry.attempts)
+	}
+}
+
+// TestPostIsNotRetried verifies that non-idempotent requests get one attempt.
+func TestPostIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := newTestFormaeClient(srv)
+	c.retry = fastRetry
+	if _, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, []byte(`{}`), "client-1"); err == nil {
+		t.Fatal("expected error for 502")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+// TestAgentUnreachable verifies that a refused connection is retried and
+// reported as ErrAgentUnreachable with an actionable message.
+func TestAgentUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	endpoint := srv.URL
+	srv.Close()
+
+	c := NewFormaeClient(endpoint)
+	c.retry = fastRetry
+	_, err := c.ListResources(context.Background(), "")
+	if !errors.Is(err, ErrAgentUnreachable) {
+		t.Fatalf("err = %v, want ErrAgentUnreachable", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "after 3 attempts") || !strings.Contains(msg, "formae agent start") {
+		t.Errorf("message not actionable: %s", msg)
+	}
+}
+
+func TestAgentErrorTaxonomy(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error":"invalid token"}`, ErrUnauthorized},
+		{"proxy forbidden", http.StatusForbidden, `Forbidden`, ErrUnauthorized},
+		{"not found", http.StatusNotFound, `{"error":"no such stack"}`, ErrNotFound},
+		{"conflict", http.StatusConflict, `{"error":"stack is locked by command c1"}`, ErrConflict},
+		{"drift", http.StatusConflict, `{"error":"drift detected on 2 resources"}`, ErrDriftRejected},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			c := newTestFormaeClient(srv)
+			_, err := c.SubmitCommand(context.Background(), "apply", "reconcile", false, false, []byte(`{}`), "client-1")
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// The agent's own JSON 403s are not credential failures.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"no auto-reconcile policy"}`))
+	}))
+	defer srv.Close()
+	_, _, err := newTestFormaeClient(srv).ForceReconcileStack(context.Background(), "s")
+	if err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("err = %v, want a plain status error", err)
+	}
+}
+
+func TestQueryTerm(t *testing.T) {
+	for _, tc := range []struct{ field, value, want string }{
+		{"stack", "prod", `stack:"prod"`},
+		{"label", "my bucket", `label:"my bucket"`},
+		{"type", "AWS::S3::Bucket", `type:"AWS::S3::Bucket"`},
+		{"label", `a"b\c`, `label:"a\"b\\c"`},
+		{"stack", "prod managed:false", `stack:"prod managed:false"`},
+	} {
+		if got := queryTerm(tc.field, tc.value); got != tc.want {
+			t.Errorf("queryTerm(%q, %q) = %s, want %s", tc.field, tc.value, got, tc.want)
+		}
+	}
+}
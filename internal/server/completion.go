@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+)
+
+// completionCacheTTL is how long completion candidates are reused before
+// they are fetched again. Short, so a new stack shows up almost at once,
+// while an assistant completing character by character hits the agent once.
+var completionCacheTTL = 30 * time.Second
+
+// maxCompletionValues is the MCP limit on values in one completion result.
+const maxCompletionValues = 100
+
+// completionSource fetches the full candidate list for an argument. profile
+// selects the agent; sources that do not read the agent ignore it. Agent
+// sources are cached per profile, uncached sources are cheap local reads.
+type completionSource struct {
+	name     string
+	fetch    func(ctx context.Context, s *Server, profile string) ([]string, error)
+	perAgent bool
+	uncached bool
+}
+
+var (
+	stackCompletions        = completionSource{name: "stacks", fetch: fetchStackLabels, perAgent: true}
+	targetCompletions       = completionSource{name: "targets", fetch: fetchTargetLabels, perAgent: true}
+	resourceTypeCompletions = completionSource{name: "resource types", fetch: fetchResourceTypes, perAgent: true}
+	pluginCompletions       = completionSource{name: "hub plugins", fetch: fetchHubPluginNames}
+	profileCompletions      = completionSource{name: "profiles", fetch: fetchProfileNames, uncached: true}
+)
+
+// completionRef identifies a completable argument: a prompt name or resource
+// template URI plus the argument (or template variable) name.
+type completionRef struct {
+	ref, arg string
+}
+
+var completionSources = map[completionRef]completionSource{
+	{"formae://stacks/{label}", "label"}:           stackCompletions,
+	{"formae://stacks/{label}/resources", "label"}: stackCompletions,
+	{"formae://stacks/{label}/drift", "label"}:     stackCompletions,
+	{"formae://targets/{label}", "label"}:          targetCompletions,
+	{"formae://resources/{type}/{label}", "type"}:  resourceTypeCompletions,
+	{"check_drift", "stack"}:                       stackCompletions,
+	{"add_resource_type", "resource_type"}:         resourceTypeCompletions,
+	{"build_plugin", "provider"}:                   pluginCompletions,
+}
+
+// handleComplete answers completion/complete for prompt and resource-template
+// arguments from live data. Any argument named "profile" completes profile
+// names; a "profile" argument already filled in selects the agent the other
+// candidates are read from. Completion is best effort: a source that cannot
+// be read yields no candidates rather than an error.
+func (s *Server) handleComplete(ctx context.Context, req *mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+	p := req.Params
+	empty := &mcp.CompleteResult{Completion: mcp.CompletionResultDetails{Values: []string{}}}
+	if p.Ref == nil {
+		return empty, nil
+	}
+	ref := p.Ref.Name
+	if p.Ref.Type == "ref/resource" {
+		ref = p.Ref.URI
+	}
+	source, ok := completionSources[completionRef{ref, p.Argument.Name}]
+	if p.Argument.Name == "profile" {
+		source, ok = profileCompletions, true
+	}
+	if !ok {
+		return empty, nil
+	}
+	var profileName string
+	if p.Context != nil {
+		profileName = p.Context.Arguments["profile"]
+	}
+
+	candidates, err := s.completions.values(ctx, s, source, profileName)
+	if err != nil {
+		slog.DebugContext(ctx, "completion source unavailable", "source", source.name, "error", err)
+		return empty, nil
+	}
+	return completionResult(candidates, p.Argument.Value), nil
+}
+
+// completionResult keeps the candidates starting with prefix (ignoring case),
+// capped at the protocol's limit.
+func completionResult(candidates []string, prefix string) *mcp.CompleteResult {
+	matches := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(prefix)) {
+			matches = append(matches, c)
+		}
+	}
+	res := &mcp.CompleteResult{Completion: mcp.CompletionResultDetails{Values: matches, Total: len(matches)}}
+	if len(matches) > maxCompletionValues {
+		res.Completion.Values = matches[:maxCompletionValues]
+		res.Completion.HasMore = true
+	}
+	return res
+}
+
+// completionCache holds fetched candidate lists per source and profile.
+type completionCache struct {
+	mu      sync.Mutex
+	entries map[completionCacheKey]completionCacheEntry
+}
+
+type completionCacheKey struct {
+	source, profile string
+}
+
+type completionCacheEntry struct {
+	values  []string
+	fetched time.Time
+}
+
+func newCompletionCache() *completionCache {
+	return &completionCache{entries: map[completionCacheKey]completionCacheEntry{}}
+}
+
+func (c *completionCache) values(ctx context.Context, s *Server, source completionSource, profileName string) ([]string, error) {
+	if source.uncached {
+		return source.fetch(ctx, s, profileName)
+	}
+	key := completionCacheKey{source: source.name}
+	if source.perAgent {
+		// The resolved name, so switching the active profile switches
+		// caches too.
+		name, err := s.guardedProfile(profileName)
+		if err != nil {
+			return nil, err
+		}
+		key.profile = name
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(e.fetched) < completionCacheTTL {
+		return e.values, nil
+	}
+	values, err := source.fetch(ctx, s, profileName)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = completionCacheEntry{values: values, fetched: time.Now()}
+	c.mu.Unlock()
+	return values, nil
+}
+
+func fetchStackLabels(ctx context.Context, s *Server, profileName string) ([]string, error) {
+	c, err := s.clientFor(ctx, profileName)
+	if err != nil {
+		return nil, err
+	}
+	stacks, err := c.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0, len(stacks))
+	for _, st := range stacks {
+		labels = append(labels, st.Label)
+	}
+	return sortedUnique(labels), nil
+}
+
+func fetchTargetLabels(ctx context.Context, s *Server, profileName string) ([]string, error) {
+	c, err := s.clientFor(ctx, profileName)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := c.ListTargets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0, len(targets))
+	for _, t := range targets {
+		labels = append(labels, t.Label)
+	}
+	return sortedUnique(labels), nil
+}
+
+// fetchResourceTypes lists the resource types the agent has counted, managed
+// or not, from its stats rather than the whole inventory. Keys that are not
+// type names (a provider-level count) are skipped.
+func fetchResourceTypes(ctx context.Context, s *Server, profileName string) ([]string, error) {
+	c, err := s.clientFor(ctx, profileName)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := c.GetAgentStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	types := slices.Collect(maps.Keys(stats.ManagedResources))
+	types = slices.AppendSeq(types, maps.Keys(stats.UnmanagedResources))
+	types = slices.DeleteFunc(types, func(t string) bool { return !strings.Contains(t, "::") })
+	return sortedUnique(types), nil
+}
+
+func fetchHubPluginNames(ctx context.Context, s *Server, _ string) ([]string, error) {
+	plugins, err := s.hub.SearchPlugins(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		names = append(names, p.Name)
+	}
+	return sortedUnique(names), nil
+}
+
+func fetchProfileNames(context.Context, *Server, string) ([]string, error) {
+	return profile.List()
+}
+
+// sortedUnique sorts values and drops duplicates and empty strings.
+func sortedUnique(values []string) []string {
+	values = slices.DeleteFunc(values, func(v string) bool { return v == "" })
+	slices.Sort(values)
+	return slices.Compact(values)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestCompleteStackLabelsCachedPerProfile(t *testing.T) {
+	var calls atomic.Int32
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			_, _ = fmt.Fprint(w, `[{"Label":"prod"},{"Label":"preview"},{"Label":"dev"}]`)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	for _, prefix := range []string{"p", "PR"} {
+		res, err := session.Complete(context.Background(), &mcp.CompleteParams{
+			Ref:      &mcp.CompleteReference{Type: "ref/resource", URI: "formae://stacks/{label}/drift"},
+			Argument: mcp.CompleteParamsArgument{Name: "label", Value: prefix},
+		})
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		if got := strings.Join(res.Completion.Values, ","); got != "preview,prod" {
+			t.Errorf("Complete(%q) = %s, want preview,prod", prefix, got)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("agent listed stacks %d times, want 1 (cached)", n)
+	}
+}
+
+func TestCompleteStackLabelsFollowActiveProfile(t *testing.T) {
+	stacksAgent := func(label string) *httptest.Server {
+		return mockAgent(t, map[string]http.HandlerFunc{
+			"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprintf(w, `[{"Label":%q}]`, label)
+			},
+		})
+	}
+	staging, prod := stacksAgent("staging-app"), stacksAgent("prod-app")
+	defer staging.Close()
+	defer prod.Close()
+	setActive := withAgentProfiles(t, map[string]string{"staging": staging.URL, "prod": prod.URL})
+	session := connectTestServer(t, "")
+
+	for _, active := range []string{"staging", "prod"} {
+		setActive(active)
+		res, err := session.Complete(context.Background(), &mcp.CompleteParams{
+			Ref:      &mcp.CompleteReference{Type: "ref/prompt", Name: "check_drift"},
+			Argument: mcp.CompleteParamsArgument{Name: "stack", Value: ""},
+		})
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		if want := []string{active + "-app"}; !slices.Equal(res.Completion.Values, want) {
+			t.Errorf("active %s: got:\n%v\nwant:\n%v", active, res.Completion.Values, want)
+		}
+	}
+}
+
+func TestCompleteResourceTypesFromStats(t *testing.T) {
+	// Only the stats are read; mockAgent fails the test on an inventory read.
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stats": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"ManagedResources":{"AWS::S3::Bucket":2,"AWS":1},"UnmanagedResources":{"AWS::S3::Bucket":1,"AWS::EC2::VPC":4}}`)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	res, err := session.Complete(context.Background(), &mcp.CompleteParams{
+		Ref:      &mcp.CompleteReference{Type: "ref/prompt", Name: "add_resource_type"},
+		Argument: mcp.CompleteParamsArgument{Name: "resource_type", Value: "aws::"},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if !slices.Equal(res.Completion.Values, []string{"AWS::EC2::VPC", "AWS::S3::Bucket"}) {
+		t.Errorf("values = %v", res.Completion.Values)
+	}
+}
+
+func TestCompleteProfileNames(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "profiles"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"prod", "staging"} {
+		if err := writeTestFile(filepath.Join(dir, "profiles", name+".pkl"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := connectTestServer(t, "http://localhost:1")
+
+	res, err := session.Complete(context.Background(), &mcp.CompleteParams{
+		Ref:      &mcp.CompleteReference{Type: "ref/prompt", Name: "check_drift"},
+		Argument: mcp.CompleteParamsArgument{Name: "profile", Value: "st"},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if !slices.Equal(res.Completion.Values, []string{"staging"}) {
+		t.Errorf("values = %v", res.Completion.Values)
+	}
+}
+
+func TestCompleteUnavailableSourceIsEmpty(t *testing.T) {
+	session := connectTestServer(t, "http://localhost:1")
+	for _, params := range []*mcp.CompleteParams{
+		{
+			Ref:      &mcp.CompleteReference{Type: "ref/resource", URI: "formae://targets/{label}"},
+			Argument: mcp.CompleteParamsArgument{Name: "label", Value: "p"},
+		},
+		{
+			Ref:      &mcp.CompleteReference{Type: "ref/prompt", Name: "deploy_infrastructure"},
+			Argument: mcp.CompleteParamsArgument{Name: "file_path", Value: "/"},
+		},
+	} {
+		res, err := session.Complete(context.Background(), params)
+		if err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		if len(res.Completion.Values) != 0 {
+			t.Errorf("values = %v, want none", res.Completion.Values)
+		}
+	}
+}
+
+func TestCompletionResultCapsValues(t *testing.T) {
+	candidates := make([]string, 150)
+	for i := range candidates {
+		candidates[i] = fmt.Sprintf("stack-%03d", i)
+	}
+	res := completionResult(candidates, "stack-")
+	if len(res.Completion.Values) != maxCompletionValues || !res.Completion.HasMore || res.Completion.Total != 150 {
+		t.Errorf("got %d values, hasMore=%v, total=%d", len(res.Completion.Values), res.Completion.HasMore, res.Completion.Total)
+	}
+}
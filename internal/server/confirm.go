@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/config"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// errDeclined is returned when the user declines a destructive action the
+// server asked them to confirm.
+var errDeclined = errors.New("the user declined")
+
+// confirmSchema is the form shown with a confirmation: a single checkbox the
+// user must tick, so accepting an unread dialog is not a yes.
+var confirmSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"confirm": map[string]any{
+			"type":        "boolean",
+			"title":       "Confirm",
+			"description": "Tick to go ahead.",
+		},
+	},
+}
+
+// canElicit reports whether the calling client accepts form elicitation.
+func canElicit(req *mcp.CallToolRequest) bool {
+	if req == nil || req.Session == nil {
+		return false
+	}
+	p := req.Session.InitializeParams()
+	if p == nil || p.Capabilities == nil || p.Capabilities.Elicitation == nil {
+		return false
+	}
+	caps := p.Capabilities.Elicitation
+	// A client declaring neither mode predates modes and supports forms.
+	return caps.Form != nil || caps.URL == nil
+}
+
+// confirmWithUser asks the user directly, through MCP elicitation, to approve
+// a destructive tool call before it runs. Clients without elicitation are not
+// asked; for them the confirmation the tool description demands of the
+// assistant still applies. The answer is logged either way it goes. A decline,
+// a cancel, or a failed request refuses the call.
+func confirmWithUser(ctx context.Context, req *mcp.CallToolRequest, message string) error {
+	if !canElicit(req) {
+		return nil
+	}
+	tool := req.Params.Name
+	res, err := req.Session.Elicit(ctx, &mcp.ElicitParams{Message: message, RequestedSchema: confirmSchema})
+	if err != nil {
+		slog.WarnContext(ctx, "confirmation request failed", "tool", tool, "error", err)
+		return fmt.Errorf("%s needs confirmation, but asking the user failed: %w", tool, err)
+	}
+	confirmed := res.Action == "accept" && res.Content["confirm"] == true
+	slog.InfoContext(ctx, "confirmation answered", "tool", tool, "message", message, "action", res.Action, "confirmed", confirmed)
+	if !confirmed {
+		noteAuditDeclined(ctx)
+		return fmt.Errorf("%s not run: %w (%s). Do not retry unless the user asks again", tool, errDeclined, message)
+	}
+	return nil
+}
+
+// describeAgent names the agent a call with profileName would reach, for a
+// confirmation message: the profile, or the forced endpoint.
+func (s *Server) describeAgent(profileName string) string {
+	if profileName != "" {
+		return "profile " + profileName
+	}
+	if s.forcedEndpoint != "" {
+		return "the agent at " + s.forcedEndpoint
+	}
+	if active, err := s.activeProfile(); err == nil {
+		return "profile " + active
+	}
+	return "the default agent"
+}
+
+// destroySummary turns a simulated destroy into the confirmation question,
+// e.g. "Destroy 14 resources in stack prod-db on profile prod?". what names
+// the file or query when the simulation could not be read.
+func destroySummary(plan *tools.ChangePlan, what, agent string) string {
+	if plan == nil {
+		return fmt.Sprintf("Destroy the resources of %s on %s?", what, agent)
+	}
+	var stacks []string
+	for _, g := range plan.Groups {
+		if g.Stack != "" && !slices.Contains(stacks, g.Stack) {
+			stacks = append(stacks, g.Stack)
+		}
+	}
+	where := ""
+	switch len(stacks) {
+	case 0:
+	case 1:
+		where = " in stack " + stacks[0]
+	default:
+		where = " in stacks " + strings.Join(stacks, ", ")
+	}
+	return fmt.Sprintf("Destroy %s%s on %s?", pluralize(plan.Summary.Delete, "resource"), where, agent)
+}
+
+// cancelSummary turns the commands a cancel would hit into the confirmation
+// question. With a query, the matching commands still running are counted.
+func cancelSummary(ctx context.Context, c *FormaeClient, query, agent string) string {
+	if query == "" {
+		return fmt.Sprintf("Cancel the most recent in-progress command on %s?", agent)
+	}
+	list, err := c.ListCommands(ctx, query, "", "formae-mcp")
+	if err != nil {
+		return fmt.Sprintf("Cancel the commands matching %q on %s?", query, agent)
+	}
+	running := 0
+	for _, cmd := range list.Commands {
+		if !isTerminalCommandState(cmd.State) {
+			running++
+		}
+	}
+	return fmt.Sprintf("Cancel %s matching %q on %s?", pluralize(running, "in-progress command"), query, agent)
+}
+
+// profileWriteDiffLines caps the changed lines a profile write confirmation
+// shows.
+const profileWriteDiffLines = 40
+
+// profileWriteSummary turns a profile rewrite into the confirmation question,
+// showing the changed lines (tokens redacted) so the user sees what changes,
+// such as the cli.api endpoint or auth block.
+func profileWriteSummary(name, path, current, next string) string {
+	changes := lineDiff(config.RedactTokens(current), config.RedactTokens(next))
+	if len(lineDiff(current, next)) > len(changes) {
+		changes = append(changes, "(a token value changes; not shown)")
+	}
+	if len(changes) == 0 {
+		return fmt.Sprintf("Overwrite profile %q (%s)? Its content is unchanged.", name, path)
+	}
+	if len(changes) > profileWriteDiffLines {
+		more := len(changes) - profileWriteDiffLines
+		changes = append(changes[:profileWriteDiffLines], fmt.Sprintf("… and %s", pluralize(more, "more changed line")))
+	}
+	return fmt.Sprintf("Overwrite profile %q (%s) with these changes?\n%s", name, path, strings.Join(changes, "\n"))
+}
+
+// lineDiff lists the lines removed from a ("- ") and added in b ("+ "), in
+// order, from a longest common subsequence of their lines.
+func lineDiff(a, b string) []string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	// lcs[i][j] is the LCS length of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+x[i])
+			i++
+		default:
+			out = append(out, "+ "+y[j])
+			j++
+		}
+	}
+	return out
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// connectTestServerWithElicitation is connectTestServer with a client that
+// answers elicitation requests with answer and records the messages it was
+// shown.
+func connectTestServerWithElicitation(t *testing.T, agentURL string, answer *mcp.ElicitResult) (*mcp.ClientSession, func() []string) {
+	t.Helper()
+	ctx := context.Background()
+	s := New(agentURL)
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	var mu sync.Mutex
+	var asked []string
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, &mcp.ClientOptions{
+		ElicitationHandler: func(_ context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			asked = append(asked, req.Params.Message)
+			return answer, nil
+		},
+	})
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), asked...)
+	}
+}
+
+// destroyAgent answers simulated destroys with two deletes in stack prod and
+// counts real destroy submissions.
+func destroyAgent(t *testing.T, submitted *int) string {
+	t.Helper()
+	var mu sync.Mutex
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"POST /api/v1/commands": func(w http.ResponseWriter, r *http.Request) {
+			if parseSimulateField(t, r) == "true" {
+				_, _ = fmt.Fprint(w, `{"Simulation":{"ChangesRequired":true,"Command":{"Command":"destroy","ResourceUpdates":[
+					{"ResourceLabel":"db","ResourceType":"AWS::RDS::DBInstance","StackName":"prod","Operation":"delete"},
+					{"ResourceLabel":"logs","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"delete"}]}}}`)
+				return
+			}
+			mu.Lock()
+			*submitted++
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = fmt.Fprint(w, `{"CommandID":"cmd-destroy-1"}`)
+		},
+	})
+	t.Cleanup(agent.Close)
+	return agent.URL
+}
+
+func TestDestroyFormaAsksUserWithSimulationSummary(t *testing.T) {
+	submitted := 0
+	session, asked := connectTestServerWithElicitation(t, destroyAgent(t, &submitted),
+		&mcp.ElicitResult{Action: "accept", Content: map[string]any{"confirm": true}})
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "stack:prod"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	if got := asked(); len(got) != 1 || !strings.HasPrefix(got[0], "Destroy 2 resources in stack prod on the agent at http://") {
+		t.Errorf("asked %q", got)
+	}
+	if submitted != 1 {
+		t.Errorf("destroy submitted %d times, want 1", submitted)
+	}
+}
+
+func TestDestroyFormaRefusedWhenUserDeclines(t *testing.T) {
+	for name, answer := range map[string]*mcp.ElicitResult{
+		"decline":   {Action: "decline"},
+		"unchecked": {Action: "accept", Content: map[string]any{"confirm": false}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			submitted := 0
+			session, _ := connectTestServerWithElicitation(t, destroyAgent(t, &submitted), answer)
+			result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+				Name:      "destroy_forma",
+				Arguments: map[string]any{"query": "stack:prod"},
+			})
+			if err != nil {
+				t.Fatalf("CallTool failed: %v", err)
+			}
+			if !result.IsError || !strings.Contains(textContent(t, result), "declined") {
+				t.Errorf("expected a declined error, got: %s", textContent(t, result))
+			}
+			if submitted != 0 {
+				t.Errorf("destroy submitted %d times after the user declined", submitted)
+			}
+		})
+	}
+}
+
+func TestDestroyFormaWithoutElicitationIsNotAsked(t *testing.T) {
+	submitted := 0
+	session := connectTestServer(t, destroyAgent(t, &submitted))
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "stack:prod"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError || submitted != 1 {
+		t.Errorf("expected the destroy to be submitted once, got %d (error: %v)", submitted, result.IsError)
+	}
+}
+
+func TestDeleteProfileRefusedWhenUserDeclines(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	session, asked := connectTestServerWithElicitation(t, "http://localhost:1", &mcp.ElicitResult{Action: "cancel"})
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "delete_profile",
+		Arguments: map[string]any{"name": "staging"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || !strings.Contains(textContent(t, result), "declined") {
+		t.Errorf("expected a declined error, got: %s", textContent(t, result))
+	}
+	if got := asked(); len(got) != 1 || got[0] != `Delete profile "staging"?` {
+		t.Errorf("asked %q", got)
+	}
+}
+
+func TestWriteProfileAsksUserWithChangedLines(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	path := filepath.Join(dir, "profiles", "staging.pkl")
+	current := "cli {\n  api {\n    url = \"http://staging\"\n    port = 49684\n    auth {\n      token = \"s3cret\"\n    }\n  }\n}\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(path, current); err != nil {
+		t.Fatal(err)
+	}
+
+	session, asked := connectTestServerWithElicitation(t, "http://localhost:1", &mcp.ElicitResult{Action: "decline"})
+	next := strings.Replace(strings.Replace(current, "http://staging", "http://evil.example", 1), "s3cret", "other", 1)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "write_profile",
+		Arguments: map[string]any{"name": "staging", "content": next},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError {
+		t.Fatalf("expected a declined error, got: %s", textContent(t, result))
+	}
+	got := asked()
+	if len(got) != 1 {
+		t.Fatalf("asked %q", got)
+	}
+	for _, want := range []string{
+		`- url = "http://staging"`,
+		`+ url = "http://evil.example"`,
+		"a token value changes",
+	} {
+		if !strings.Contains(strings.ReplaceAll(got[0], "    ", ""), want) {
+			t.Errorf("got:\n%s\nwant:\na line %s", got[0], want)
+		}
+	}
+	if strings.Contains(got[0], "s3cret") || strings.Contains(got[0], "other") {
+		t.Errorf("got:\n%s\nwant:\ntokens redacted", got[0])
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc\n", "a\nB\nc\nd\n")
+	if want := []string{"- b", "+ B", "+ d"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+	if got := lineDiff("a\n", "a\n"); len(got) != 0 {
+		t.Errorf("got:\n%q\nwant:\nno changes", got)
+	}
+}
+
+func TestDestroySummary(t *testing.T) {
+	plan := &tools.ChangePlan{
+		Summary: tools.ChangePlanSummary{Delete: 3},
+		Groups:  []tools.ChangePlanGroup{{Stack: "a"}, {Stack: "b"}, {Stack: "a"}},
+	}
+	if got := destroySummary(plan, "f.pkl", "profile dev"); got != "Destroy 3 resources in stacks a, b on profile dev?" {
+		t.Errorf("got %q", got)
+	}
+	if got := destroySummary(nil, "f.pkl", "profile dev"); got != "Destroy the resources of f.pkl on profile dev?" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// defaultToolTimeout bounds a tool call that has no entry in toolTimeouts.
+const defaultToolTimeout = 2 * time.Minute
+
+// toolTimeouts overrides defaultToolTimeout for tools whose work is known to
+// be quicker or slower than a typical agent query. Tools that run
+// `formae eval` across the workspace or submit commands get longer budgets.
+var toolTimeouts = map[string]time.Duration{
+	"check_health":                      15 * time.Second,
+	"apply_forma":                       5 * time.Minute,
+	"destroy_forma":                     5 * time.Minute,
+	"extract_resources":                 5 * time.Minute,
+	"list_changes_since_last_reconcile": 5 * time.Minute,
+	"diff_resource_drift":               5 * time.Minute,
+	"absorb_drift":                      5 * time.Minute,
+	"create_inline_policy":              5 * time.Minute,
+	"create_standalone_policy":          5 * time.Minute,
+	"attach_standalone_policy":          5 * time.Minute,
+	"detach_standalone_policy":          5 * time.Minute,
+	"delete_standalone_policy":          5 * time.Minute,
+	// wait_for_command enforces its own timeout_seconds; leave it headroom.
+	"wait_for_command": maxWaitTimeout + time.Minute,
+}
+
+// subprocessWaitDelay bounds how long output pipes of a killed formae process
+// may stay open; children it spawned (e.g. the PKL evaluator) can otherwise
+// keep a cancelled call waiting.
+const subprocessWaitDelay = 2 * time.Second
+
+func toolTimeout(name string) time.Duration {
+	if d, ok := toolTimeouts[name]; ok {
+		return d
+	}
+	return defaultToolTimeout
+}
+
+// toolDeadlineMiddleware gives every tools/call a deadline on top of the
+// request context, which the SDK already cancels when the client sends
+// notifications/cancelled. Handlers pass the context on to agent requests and
+// formae subprocesses, so either way the work stops.
+func toolDeadlineMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		call, ok := req.(*mcp.CallToolRequest)
+		if !ok || call.Params == nil {
+			return next(ctx, method, req)
+		}
+		d := toolTimeout(call.Params.Name)
+		ctx, cancel := context.WithTimeoutCause(ctx, d, fmt.Errorf("%s did not finish within %s", call.Params.Name, d))
+		defer cancel()
+		return next(ctx, method, req)
+	}
+}
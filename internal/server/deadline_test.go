@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestToolDeadlineCancelsAgentRequest(t *testing.T) {
+	toolTimeouts["list_stacks"] = 50 * time.Millisecond
+	t.Cleanup(func() { delete(toolTimeouts, "list_stacks") })
+
+	released := make(chan struct{})
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				close(released)
+			case <-time.After(5 * time.Second):
+			}
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	start := time.Now()
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_stacks"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || !strings.Contains(textContent(t, result), "list_stacks did not finish within 50ms") {
+		t.Fatalf("expected a deadline error, got: %s", textContent(t, result))
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %s; the deadline was not enforced", elapsed)
+	}
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Error("agent request was not cancelled")
+	}
+}
+
+func TestToolTimeout(t *testing.T) {
+	if got := toolTimeout("list_targets"); got != defaultToolTimeout {
+		t.Errorf("list_targets = %s, want the default", got)
+	}
+	if got := toolTimeout("wait_for_command"); got <= maxWaitTimeout {
+		t.Errorf("wait_for_command = %s, must exceed its own max timeout %s", got, maxWaitTimeout)
+	}
+}
+
+func TestRetryBackoffStopsOnCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newTestFormaeClient(srv)
+	c.retry = retryPolicy{attempts: 10, base: time.Hour, max: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.ListStacks(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if errors.Is(err, ErrAgentUnreachable) {
+		t.Error("a cancelled request must not be reported as an unreachable agent")
+	}
+}
+
+func TestFormaeEvalKilledOnCancel(t *testing.T) {
+	bin := t.TempDir()
+	// No exec: the shell's child keeps the output pipe open after the kill.
+	script := "#!/bin/sh\nsleep 30\n"
+	if err := os.WriteFile(filepath.Join(bin, "formae"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := formaeEval(ctx, "main.pkl")
+	if err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Fatalf("err = %v, want a stopped error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("formae eval ran for %s after cancellation", elapsed)
+	}
+}
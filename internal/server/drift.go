@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+)
+
+// driftConcurrency bounds how many per-stack drift requests run against the
+// agent at once when list_changes_since_last_reconcile checks every stack.
+// A variable so tests can observe the bound.
+var driftConcurrency = 8
+
+// stackDrift is one stack's entry in the all-stack drift result. Exactly one
+// of ModifiedResources and Error is set.
+type stackDrift struct {
+	Stack             string          `json:"Stack"`
+	ModifiedResources json.RawMessage `json:"ModifiedResources,omitempty"`
+	Error             string          `json:"Error,omitempty"`
+
+	count int
+}
+
+// collectStackDrift fetches drift for each stack with a bounded worker pool.
+// A failure on one stack is recorded on its entry instead of aborting the
+// rest. Stacks without modifications are dropped; the remaining entries keep
+// the order of stacks.
+func collectStackDrift(ctx context.Context, c *FormaeClient, stacks []string) []stackDrift {
+	results := make([]stackDrift, len(stacks))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(driftConcurrency, len(stacks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fetchStackDrift(ctx, c, stacks[i])
+			}
+		}()
+	}
+dispatch:
+	for i := range stacks {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			for j := i; j < len(stacks); j++ {
+				results[j] = stackDrift{Stack: stacks[j], Error: ctx.Err().Error()}
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	kept := results[:0]
+	for _, r := range results {
+		if r.Error != "" || r.count > 0 {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func fetchStackDrift(ctx context.Context, c *FormaeClient, stack string) stackDrift {
+	drift, err := c.ListChangesSinceLastReconcile(ctx, stack)
+	if err != nil {
+		return stackDrift{Stack: stack, Error: err.Error()}
+	}
+	modified := drift.ModifiedResources
+	if modified == nil {
+		modified = []model.ModifiedResource{}
+	}
+	data, err := json.Marshal(modified)
+	if err != nil {
+		return stackDrift{Stack: stack, Error: fmt.Sprintf("failed to marshal drift: %v", err)}
+	}
+	return stackDrift{Stack: stack, ModifiedResources: data, count: len(modified)}
+}
+
+// driftSummary counts the outcome of an all-stack drift check.
+type driftSummary struct {
+	checked   int
+	drifted   int
+	resources int
+	failed    int
+}
+
+func summarizeStackDrift(checked int, results []stackDrift) driftSummary {
+	s := driftSummary{checked: checked}
+	for _, r := range results {
+		if r.Error != "" {
+			s.failed++
+			continue
+		}
+		s.drifted++
+		s.resources += r.count
+	}
+	return s
+}
+
+func (s driftSummary) header() string {
+	h := fmt.Sprintf("Checked %d stacks: %d with changes since last reconcile (%d modified resources)", s.checked, s.drifted, s.resources)
+	if s.failed > 0 {
+		h += fmt.Sprintf(", %d could not be checked (see Error entries)", s.failed)
+	}
+	return h + "."
+}
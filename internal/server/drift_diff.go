@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// driftHistoryDepth is how many recent apply commands on a stack are searched
+// for a resource's last applied desired properties.
+const driftHistoryDepth = "50"
+
+type resourceKey struct{ typ, label string }
+
+func (s *Server) handleDiffResourceDrift(ctx context.Context, _ *mcp.CallToolRequest, input tools.DiffResourceDriftInput) (*mcp.CallToolResult, any, error) {
+	if input.Stack == "" {
+		return errorResult(fmt.Errorf("stack is required")), nil, nil
+	}
+	c, err := s.clientFor(ctx, input.Profile)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	drifts, err := stackResourceDrift(ctx, c, input.Stack)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	out := tools.DiffResourceDriftOutput{Stack: input.Stack, Resources: []tools.ResourceDriftDiff{}}
+	for _, d := range drifts {
+		out.Resources = append(out.Resources, d.diff)
+	}
+	return diffDriftResult(out)
+}
+
+// resourceDrift is one modified resource's diff plus its desired and current
+// synced properties, which absorb_drift needs to render whole property values.
+type resourceDrift struct {
+	diff    tools.ResourceDriftDiff
+	desired any
+	current any
+}
+
+// stackResourceDrift diffs every resource the agent reports as modified on
+// the stack against its last applied desired state.
+func stackResourceDrift(ctx context.Context, c *FormaeClient, stack string) ([]resourceDrift, error) {
+	drift, err := c.ListChangesSinceLastReconcile(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	if len(drift.ModifiedResources) == 0 {
+		return nil, nil
+	}
+
+	desired, err := lastAppliedProperties(ctx, c, stack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command history for stack %s: %w", stack, err)
+	}
+
+	var out []resourceDrift
+	for _, m := range drift.ModifiedResources {
+		d := resourceDrift{diff: tools.ResourceDriftDiff{Type: m.Type, Label: m.Label, Operation: m.Operation}}
+		want, ok := desired[resourceKey{m.Type, m.Label}]
+		if ok {
+			d.diff.DesiredFrom = "command " + want.commandID
+			d.desired = want.properties
+		}
+		if planAction(m.Operation) == actionDelete {
+			d.diff.Note = "resource was deleted outside formae; there is no current state to compare"
+			out = append(out, d)
+			continue
+		}
+		current, err := currentProperties(ctx, c, stack, m.Type, m.Label)
+		switch {
+		case err != nil:
+			d.diff.Note = fmt.Sprintf("could not read current state: %v", err)
+		case !ok:
+			d.current = current
+			d.diff.Note = fmt.Sprintf("no applied desired state found in the last %s apply commands on this stack", driftHistoryDepth)
+		default:
+			d.current = current
+			// Only what the desired state declared can have drifted: the
+			// synced state also holds read-only, generated and defaulted
+			// fields.
+			d.diff.Changes = diffDeclared(want.properties, current)
+			if len(d.diff.Changes) == 0 {
+				d.diff.Note = "current properties match the last applied desired state"
+			}
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+type desiredState struct {
+	commandID  string
+	properties any
+}
+
+// lastAppliedProperties walks the stack's recent successful apply commands,
+// newest first as the agent returns them, and records the first properties
+// seen for each resource: what formae last pushed as the desired state. The
+// agent's command history does not say which applies were reconciles, so a
+// patch counts as much as a reconcile.
+func lastAppliedProperties(ctx context.Context, c *FormaeClient, stack string) (map[resourceKey]desiredState, error) {
+	resp, err := c.ListCommands(ctx, "command:apply "+queryTerm("stack", stack), driftHistoryDepth, "formae-mcp")
+	if err != nil {
+		return nil, err
+	}
+	desired := map[resourceKey]desiredState{}
+	for _, cmd := range resp.Commands {
+		for _, u := range cmd.ResourceUpdates {
+			if u.StackName != "" && u.StackName != stack {
+				continue
+			}
+			if !isFinishedUpdateState(u.State) || isFailedUpdateState(u.State) {
+				continue
+			}
+			if planAction(u.Operation) == actionDelete {
+				continue
+			}
+			key := resourceKey{u.ResourceType, u.ResourceLabel}
+			if _, seen := desired[key]; seen {
+				continue
+			}
+			props := decodeProperties(u.Properties)
+			if props == nil {
+				continue
+			}
+			desired[key] = desiredState{commandID: cmd.CommandID, properties: props}
+		}
+	}
+	return desired, nil
+}
+
+// currentProperties fetches the synced properties of one resource.
+func currentProperties(ctx context.Context, c *FormaeClient, stack, typ, label string) (any, error) {
+	list, err := c.ListResources(ctx, queryTerm("stack", stack)+" "+queryTerm("type", typ)+" "+queryTerm("label", label))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		if r.Label != label || r.Type != typ {
+			continue
+		}
+		if r.Properties == nil {
+			return nil, fmt.Errorf("resource has no properties")
+		}
+		return decodeProperties(r.Properties), nil
+	}
+	return nil, fmt.Errorf("resource %s %s not found in stack %s", typ, label, stack)
+}
+
+// decodeProperties decodes a Properties value, which the agent sends either
+// as a JSON object or as a string holding one.
+func decodeProperties(raw json.RawMessage) any {
+	v := decodeJSONValue(raw)
+	if s, ok := v.(string); ok {
+		return decodeJSONValue(json.RawMessage(s))
+	}
+	return v
+}
+
+func diffDriftResult(out tools.DiffResourceDriftOutput) (*mcp.CallToolResult, any, error) {
+	body, err := json.Marshal(out)
+	if err != nil {
+		return errorResult(fmt.Errorf("marshal output: %w", err)), nil, nil
+	}
+	return jsonResult(body), nil, nil
+}
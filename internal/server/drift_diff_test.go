@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+func TestDiffResourceDrift(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/prod/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[
+				{"Stack":"prod","Type":"AWS::S3::Bucket","Label":"logs","Operation":"update"},
+				{"Stack":"prod","Type":"AWS::SQS::Queue","Label":"jobs","Operation":"delete"},
+				{"Stack":"prod","Type":"AWS::SNS::Topic","Label":"alerts","Operation":"update"}]}`)
+		},
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			if q := r.URL.Query().Get("query"); q != `command:apply stack:"prod"` {
+				t.Errorf("commands query = %q", q)
+			}
+			// Newest first: cmd-2 is the last apply of logs; cmd-1 is older.
+			_, _ = fmt.Fprint(w, `{"Commands":[
+				{"CommandID":"cmd-2","State":"Success","ResourceUpdates":[
+					{"ResourceLabel":"logs","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"update","State":"Success",
+					 "Properties":"{\"Versioning\":\"Enabled\",\"Tags\":[{\"Key\":\"env\",\"Value\":\"prod\"}]}"}]},
+				{"CommandID":"cmd-1","State":"Success","ResourceUpdates":[
+					{"ResourceLabel":"logs","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"create","State":"Success",
+					 "Properties":{"Versioning":"Suspended"}}]}]}`)
+		},
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("query") {
+			case `stack:"prod" type:"AWS::S3::Bucket" label:"logs"`:
+				_, _ = fmt.Fprint(w, `[{"Label":"logs","Type":"AWS::S3::Bucket","Stack":"prod",
+					"Properties":{"Versioning":"Enabled","Tags":[{"Key":"env","Value":"staging"}]}}]`)
+			default:
+				_, _ = fmt.Fprint(w, `[{"Label":"alerts","Type":"AWS::SNS::Topic","Stack":"prod","Properties":{}}]`)
+			}
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "diff_resource_drift",
+		Arguments: map[string]any{"stack": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	var out tools.DiffResourceDriftOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Resources) != 3 {
+		t.Fatalf("resources = %+v", out.Resources)
+	}
+
+	logs := out.Resources[0]
+	if logs.DesiredFrom != "command cmd-2" {
+		t.Errorf("desired_from = %q, want the newest command", logs.DesiredFrom)
+	}
+	if len(logs.Changes) != 1 || logs.Changes[0].Path != "/Tags/0/Value" ||
+		logs.Changes[0].Old != "prod" || logs.Changes[0].New != "staging" {
+		t.Errorf("changes = %+v", logs.Changes)
+	}
+	if jobs := out.Resources[1]; jobs.Note == "" || len(jobs.Changes) != 0 {
+		t.Errorf("deleted resource should carry a note, got %+v", jobs)
+	}
+	if alerts := out.Resources[2]; alerts.Note == "" {
+		t.Errorf("resource without history should carry a note, got %+v", alerts)
+	}
+}
+
+func TestDiffResourceDrift_NoDrift(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/prod/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[]}`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "diff_resource_drift",
+		Arguments: map[string]any{"stack": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError || textContent(t, result) != `{"stack":"prod","resources":[]}` {
+		t.Fatalf("unexpected result: %s", textContent(t, result))
+	}
+}
+
+func TestDiffResourceDrift_IgnoresServerSideFields(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/prod/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[{"Stack":"prod","Type":"AWS::S3::Bucket","Label":"logs","Operation":"update"}]}`)
+		},
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			// A patch that only set versioning and one tag key.
+			_, _ = fmt.Fprint(w, `{"Commands":[{"CommandID":"cmd-1","State":"Success","ResourceUpdates":[
+				{"ResourceLabel":"logs","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"update","State":"Success",
+				 "Properties":{"Versioning":"Enabled","Encryption":{"Algorithm":"AES256"}}}]}]}`)
+		},
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			// Synced state adds read-only, generated and defaulted fields,
+			// at the top level and nested.
+			_, _ = fmt.Fprint(w, `[{"Label":"logs","Type":"AWS::S3::Bucket","Stack":"prod","Properties":{
+				"Versioning":"Enabled","Encryption":{"Algorithm":"AES256","BucketKeyEnabled":false},
+				"Arn":"arn:aws:s3:::logs","DomainName":"logs.s3.amazonaws.com","CreationDate":"2026-01-01T00:00:00Z"}}]`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "diff_resource_drift",
+		Arguments: map[string]any{"stack": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	var out tools.DiffResourceDriftOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Resources) != 1 {
+		t.Fatalf("resources = %+v", out.Resources)
+	}
+	if logs := out.Resources[0]; len(logs.Changes) != 0 || logs.Note != "current properties match the last applied desired state" {
+		t.Errorf("got:\n%+v\nwant:\nno changes for fields the desired state never set", logs)
+	}
+}
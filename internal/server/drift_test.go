@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestListChangesSinceLastReconcile_BoundedFanOut(t *testing.T) {
+	const stackCount = 20
+	var inFlight, peak atomic.Int32
+	handlers := map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			var labels []string
+			for i := 0; i < stackCount; i++ {
+				labels = append(labels, fmt.Sprintf(`{"Label":"s%02d"}`, i))
+			}
+			_, _ = fmt.Fprint(w, "["+strings.Join(labels, ",")+"]")
+		},
+	}
+	for i := 0; i < stackCount; i++ {
+		label := fmt.Sprintf("s%02d", i)
+		handlers["GET /api/v1/stacks/"+label+"/changes-since-last-reconcile"] = func(w http.ResponseWriter, r *http.Request) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			switch label {
+			case "s03":
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = fmt.Fprint(w, `{"error":"boom"}`)
+			case "s07", "s12":
+				_, _ = fmt.Fprintf(w, `{"ModifiedResources":[{"Stack":%q,"Type":"AWS::S3::Bucket","Label":"b","Operation":"update"}]}`, label)
+			default:
+				_, _ = fmt.Fprint(w, `{"ModifiedResources":[]}`)
+			}
+		}
+	}
+	agent := mockAgent(t, handlers)
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_changes_since_last_reconcile"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("one failing stack must not fail the call: %s", textContent(t, result))
+	}
+	if p := peak.Load(); p > int32(driftConcurrency) || p < 2 {
+		t.Errorf("peak concurrency = %d, want between 2 and %d", p, driftConcurrency)
+	}
+
+	header, body, ok := strings.Cut(textContent(t, result), "\n\n")
+	if !ok {
+		t.Fatalf("expected header and body, got %s", textContent(t, result))
+	}
+	want := "Checked 20 stacks: 2 with changes since last reconcile (2 modified resources), 1 could not be checked (see Error entries)."
+	if header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	var entries []stackDrift
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Stack)
+	}
+	if strings.Join(got, ",") != "s03,s07,s12" {
+		t.Errorf("entries = %v, want failed and drifted stacks in order", got)
+	}
+	if entries[0].Error == "" {
+		t.Error("expected s03 to carry its error")
+	}
+}
+
+func TestListChangesSinceLastReconcile_AllStacksFail(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"a"}]`)
+		},
+		"GET /api/v1/stacks/a/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_changes_since_last_reconcile"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError {
+		t.Fatalf("expected error when every stack fails, got %s", textContent(t, result))
+	}
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/featuregate"
+)
+
+// Eval cache modes, chosen with FORMAE_MCP_EVAL_CACHE.
+const (
+	evalCacheOff    = "off"    // run formae eval every time
+	evalCacheMemory = "memory" // keep results for the life of the process (default)
+	evalCacheDisk   = "disk"   // also keep them under .formae/eval-cache in the workspace root
+)
+
+// The disk store holds evaluated formae, which can carry whatever the PKL
+// reads in (secrets included), so its directory ignores itself in git.
+const evalCacheGitignore = "# Written by formae-mcp: cached formae eval output, which may hold secrets.\n*\n"
+
+func evalCacheMode() string {
+	switch m := os.Getenv("FORMAE_MCP_EVAL_CACHE"); m {
+	case evalCacheOff, evalCacheDisk:
+		return m
+	default:
+		return evalCacheMemory
+	}
+}
+
+// evalFailedError is a PKL file that formae eval rejected (a non-zero exit),
+// as opposed to an eval that was cancelled or could not start. The policy
+// tools skip files that fail — a workspace is full of modules that are not
+// formae — and report them. Failures are never cached: a rejection can be
+// transient (a package:// download, a network read), and a file that is not a
+// forma fails fast anyway.
+type evalFailedError struct {
+	Path   string
+	Stderr string
+}
+
+func (e *evalFailedError) Error() string {
+	return fmt.Sprintf("formae eval failed for %s: %s", e.Path, e.Stderr)
+}
+
+// evalResult is a cached eval output, as stored on disk.
+type evalResult struct {
+	Output json.RawMessage `json:"output"`
+}
+
+// evalCache memoizes formae eval per file under a content-addressed key
+// (see evalKey), so an unchanged file is evaluated once, and any change to
+// it, to a file it imports or reads, to its PklProject dependencies or to
+// the formae version invalidates the result.
+type evalCache struct {
+	mu sync.Mutex
+	// entries holds the latest result per file; a changed file replaces
+	// its entry rather than accumulating one per version.
+	entries map[string]evalCacheEntry
+}
+
+type evalCacheEntry struct {
+	key    string
+	result evalResult
+}
+
+func newEvalCache() *evalCache {
+	return &evalCache{entries: map[string]evalCacheEntry{}}
+}
+
+// evalResults is the process-wide eval cache behind currentEvalFunc.
+var evalResults = newEvalCache()
+
+// eval returns path's cached output when its key still matches, else calls
+// run and caches its output if it succeeds. root is the workspace root holding path,
+// for the disk store. Files whose inputs cannot all be tracked (see evalKey)
+// are always evaluated.
+func (c *evalCache) eval(ctx context.Context, path, root string, run EvalFunc) ([]byte, error) {
+	mode := evalCacheMode()
+	if mode == evalCacheOff {
+		return run(path)
+	}
+	key, ok := evalKey(path)
+	if !ok {
+		return run(path)
+	}
+
+	c.mu.Lock()
+	e, hit := c.entries[path]
+	c.mu.Unlock()
+	if hit && e.key == key {
+		return e.result.Output, nil
+	}
+	disk := mode == evalCacheDisk && root != ""
+	if disk {
+		if r, ok := readDiskEval(root, key); ok {
+			c.store(path, key, r)
+			return r.Output, nil
+		}
+	}
+
+	out, err := run(path)
+	if err != nil {
+		return out, err
+	}
+	r := evalResult{Output: out}
+	c.store(path, key, r)
+	if disk {
+		if werr := writeDiskEval(root, key, r); werr != nil {
+			slog.DebugContext(ctx, "eval cache: disk write failed", "file", path, "error", werr)
+		}
+	}
+	return out, nil
+}
+
+func (c *evalCache) store(path, key string, r evalResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = evalCacheEntry{key: key, result: r}
+}
+
+// diskEvalPath is where the disk store keeps a result.
+func diskEvalPath(root, key string) string {
+	return filepath.Join(root, ".formae", "eval-cache", key+".json")
+}
+
+func readDiskEval(root, key string) (evalResult, bool) {
+	data, err := os.ReadFile(diskEvalPath(root, key))
+	if err != nil {
+		return evalResult{}, false
+	}
+	var r evalResult
+	if err := json.Unmarshal(data, &r); err != nil || len(r.Output) == 0 {
+		return evalResult{}, false
+	}
+	return r, true
+}
+
+// writeDiskEval stores a result through a rename, so a concurrent reader
+// never sees a partial file. The directory is private to the user and
+// carries a .gitignore, since the output may hold secrets.
+func writeDiskEval(root, key string, r evalResult) error {
+	p := diskEvalPath(root, key)
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(ignore, []byte(evalCacheGitignore), 0o644); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// pklDependencyRe finds the modules and resources a PKL module pulls in:
+// import/import* clauses and expressions, amends, extends, and
+// read/read?/read* of a literal URI. Matches inside comments only make the
+// key stricter.
+var pklDependencyRe = regexp.MustCompile(`\b(import\*?|amends|extends|read[*?]?)\s*\(?\s*"([^"\\]*)"`)
+
+// evalKey is the cache key for evaluating path: a hash of the formae version,
+// the file's path and content, the content of every local file it imports,
+// amends, extends or reads (transitively), and the enclosing PklProject and
+// PklProject.deps.json. ok is false when an input cannot be tracked — a
+// remote or environment URI, or a recursive glob — and the file must be
+// evaluated every time.
+func evalKey(path string) (key string, ok bool) {
+	version, _ := featuregate.Detect()
+	h := sha256.New()
+	fmt.Fprintf(h, "formae-mcp eval cache v2\nformae %s\n", version)
+	if !hashPKLModule(h, path, map[string]bool{}) {
+		return "", false
+	}
+	if proj := enclosingPklProject(filepath.Dir(path)); proj != "" {
+		for _, name := range []string{"PklProject", "PklProject.deps.json"} {
+			hashFile(h, filepath.Join(proj, name))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// hashPKLModule hashes path and, recursively, the local files it depends on.
+func hashPKLModule(h hash.Hash, path string, visited map[string]bool) bool {
+	if visited[path] {
+		return true
+	}
+	visited[path] = true
+	content, ok := hashFile(h, path)
+	if !ok {
+		return true // a missing import fails the eval; its absence is keyed
+	}
+	dir := filepath.Dir(path)
+	for _, m := range pklDependencyRe.FindAllStringSubmatch(string(content), -1) {
+		keyword, uri := m[1], m[2]
+		deps, ok := localDependencies(dir, uri, strings.HasSuffix(keyword, "*"))
+		if !ok {
+			return false
+		}
+		for _, dep := range deps {
+			if strings.HasPrefix(keyword, "read") || filepath.Ext(dep) != ".pkl" {
+				hashFile(h, dep)
+			} else if !hashPKLModule(h, dep, visited) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hashFile writes path and its content (or its absence) to h.
+func hashFile(h hash.Hash, path string) ([]byte, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(h, "missing %s\n", path)
+		return nil, false
+	}
+	fmt.Fprintf(h, "file %s %d\n", path, len(content))
+	h.Write(content)
+	return content, true
+}
+
+// localDependencies resolves a dependency URI written in a module in dir to
+// the local files it names. Standard-library (pkl:), package:// and project
+// (@dep) URIs yield none: they are pinned by the formae version and
+// PklProject.deps.json. ok is false for inputs that cannot be tracked.
+func localDependencies(dir, uri string, glob bool) ([]string, bool) {
+	var path string
+	switch scheme := uriScheme(uri); {
+	case scheme == "pkl" || scheme == "package" || strings.HasPrefix(uri, "@"):
+		return nil, true
+	case scheme == "file":
+		path = strings.TrimPrefix(strings.TrimPrefix(uri, "file://"), "file:")
+	case scheme != "":
+		return nil, false
+	default:
+		path = filepath.FromSlash(uri)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if !glob {
+		return []string{path}, true
+	}
+	if strings.Contains(uri, "**") {
+		return nil, false
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, false
+	}
+	return matches, true
+}
+
+// uriScheme returns the scheme of a PKL module URI, or "" for a path.
+func uriScheme(uri string) string {
+	i := strings.Index(uri, ":")
+	if i <= 0 || strings.ContainsAny(uri[:i], "/.\\") {
+		return ""
+	}
+	return uri[:i]
+}
+
+// enclosingPklProject returns the nearest directory at or above dir holding
+// a PklProject file, or "".
+func enclosingPklProject(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "PklProject")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingEval evaluates to the file's content and counts runs per file.
+func countingEval(runs map[string]int) EvalFunc {
+	return func(path string) ([]byte, error) {
+		runs[path]++
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(`"` + string(content) + `"`), nil
+	}
+}
+
+func TestEvalCacheInvalidatesOnInputChanges(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	root := t.TempDir()
+	main := filepath.Join(root, "main.pkl")
+	lib := filepath.Join(root, "lib", "vars.pkl")
+	data := filepath.Join(root, "lib", "data.txt")
+	deps := filepath.Join(root, "PklProject.deps.json")
+	for path, content := range map[string]string{
+		main:                              "import \"lib/vars.pkl\"\nx = vars.y",
+		lib:                               "y = read(\"data.txt\").text",
+		data:                              "1",
+		filepath.Join(root, "PklProject"): "amends \"pkl:Project\"",
+		deps:                              `{"resolvedDependencies":{}}`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeTestFile(path, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newEvalCache()
+	runs := map[string]int{}
+	eval := countingEval(runs)
+	ctx := context.Background()
+	evalMain := func() {
+		t.Helper()
+		if _, err := c.eval(ctx, main, root, eval); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evalMain()
+	evalMain()
+	if runs[main] != 1 {
+		t.Fatalf("unchanged file evaluated %d times, want 1", runs[main])
+	}
+
+	steps := []struct {
+		name, path, content string
+	}{
+		{"file", main, "import \"lib/vars.pkl\"\nx = vars.y + 1"},
+		{"import", lib, "y = read(\"data.txt\").text + \"!\""},
+		{"read resource", data, "2"},
+		{"deps.json", deps, `{"resolvedDependencies":{"x":{}}}`},
+	}
+	for i, step := range steps {
+		if err := writeTestFile(step.path, step.content); err != nil {
+			t.Fatal(err)
+		}
+		evalMain()
+		evalMain()
+		if want := i + 2; runs[main] != want {
+			t.Errorf("after changing the %s: %d evals, want %d", step.name, runs[main], want)
+		}
+	}
+
+	withFakeVersion(t, "0.89.0")
+	evalMain()
+	if want := len(steps) + 2; runs[main] != want {
+		t.Errorf("after a formae upgrade: %d evals, want %d", runs[main], want)
+	}
+}
+
+func TestEvalCacheFailures(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	root := t.TempDir()
+	path := filepath.Join(root, "vars.pkl")
+	if err := writeTestFile(path, "x = 1"); err != nil {
+		t.Fatal(err)
+	}
+	c := newEvalCache()
+	ctx := context.Background()
+
+	runs := 0
+	cancelled := func(string) ([]byte, error) { runs++; return nil, context.Canceled }
+	for range 2 {
+		if _, err := c.eval(ctx, path, root, cancelled); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want the cancellation", err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("a cancelled eval was cached")
+	}
+
+	// A rejection may be transient (a package:// download, say), so it is
+	// evaluated again next time.
+	runs = 0
+	rejected := func(p string) ([]byte, error) { runs++; return nil, &evalFailedError{Path: p, Stderr: "not a forma"} }
+	for range 2 {
+		_, err := c.eval(ctx, path, root, rejected)
+		var failed *evalFailedError
+		if !errors.As(err, &failed) || failed.Stderr != "not a forma" {
+			t.Fatalf("got %v, want the eval failure", err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("a rejected file was evaluated %d times, want every time", runs)
+	}
+}
+
+func TestEvalCacheSkipsUntrackableInputs(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"remote.pkl": `import "https://example.com/vars.pkl"`,
+		"env.pkl":    `x = read("env:HOME")`,
+		"glob.pkl":   `import* "**/*.pkl"`,
+	} {
+		path := filepath.Join(root, name)
+		if err := writeTestFile(path, content); err != nil {
+			t.Fatal(err)
+		}
+		c := newEvalCache()
+		runs := map[string]int{}
+		for range 2 {
+			if _, err := c.eval(context.Background(), path, root, countingEval(runs)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if runs[path] != 2 {
+			t.Errorf("%s: evaluated %d times, want every time", name, runs[path])
+		}
+	}
+}
+
+func TestEvalCacheDiskStore(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	t.Setenv("FORMAE_MCP_EVAL_CACHE", "disk")
+	root := t.TempDir()
+	path := filepath.Join(root, "main.pkl")
+	if err := writeTestFile(path, "x = 1"); err != nil {
+		t.Fatal(err)
+	}
+	runs := map[string]int{}
+	if _, err := newEvalCache().eval(context.Background(), path, root, countingEval(runs)); err != nil {
+		t.Fatal(err)
+	}
+	// A new process (a fresh in-memory cache) reads the result from disk.
+	out, err := newEvalCache().eval(context.Background(), path, root, countingEval(runs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs[path] != 1 || string(out) != `"x = 1"` {
+		t.Errorf("got %s after %d evals, want the stored result after 1", out, runs[path])
+	}
+	if files, _ := filepath.Glob(filepath.Join(root, ".formae", "eval-cache", "*.json")); len(files) != 1 {
+		t.Errorf("want one stored result, got %v", files)
+	}
+	ignore, err := os.ReadFile(filepath.Join(root, ".formae", "eval-cache", ".gitignore"))
+	if err != nil || !strings.Contains(string(ignore), "\n*\n") {
+		t.Errorf("got .gitignore %q (%v), want one ignoring the whole cache", ignore, err)
+	}
+}
+
+func TestEvalCacheOff(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	t.Setenv("FORMAE_MCP_EVAL_CACHE", "off")
+	root := t.TempDir()
+	path := filepath.Join(root, "main.pkl")
+	if err := writeTestFile(path, "x = 1"); err != nil {
+		t.Fatal(err)
+	}
+	c := newEvalCache()
+	runs := map[string]int{}
+	for range 2 {
+		if _, err := c.eval(context.Background(), path, root, countingEval(runs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs[path] != 2 {
+		t.Errorf("evaluated %d times with the cache off, want 2", runs[path])
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/guardrail"
+	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// guardedProfile names the profile a call reaches, for the guardrail check:
+// the per-call profile, else the active one. A forced endpoint has none, and
+// neither has a config dir without an active profile. An active profile that
+// cannot be read is an error, so profile-scoped guardrails never pass a call
+// whose target is unknown.
+func (s *Server) guardedProfile(profileName string) (string, error) {
+	if profileName != "" || s.forcedEndpoint != "" {
+		return profileName, nil
+	}
+	active, err := s.activeProfile()
+	if errors.Is(err, profile.ErrNotInitialized) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot tell which profile the call targets: %w", err)
+	}
+	return active, nil
+}
+
+// simulatedPlan runs submit as a simulation and returns its change plan, or
+// nil when the simulation fails or cannot be read.
+func simulatedPlan(command string, submit func(simulate bool) (json.RawMessage, error)) *tools.ChangePlan {
+	body, err := submit(true)
+	if err != nil {
+		return nil
+	}
+	plan, err := buildChangePlan(command, body)
+	if err != nil {
+		return nil
+	}
+	return &plan
+}
+
+// formaSubjects lists the stacks and resources an evaluated forma declares.
+func formaSubjects(formaJSON []byte) []guardrail.Subject {
+	var f struct {
+		Stacks []struct {
+			Label string `json:"Label"`
+		} `json:"Stacks"`
+		Resources []struct {
+			Label string `json:"Label"`
+			Stack string `json:"Stack"`
+		} `json:"Resources"`
+	}
+	if err := json.Unmarshal(formaJSON, &f); err != nil {
+		return nil
+	}
+	var subjects []guardrail.Subject
+	for _, st := range f.Stacks {
+		subjects = append(subjects, guardrail.Subject{Stack: st.Label})
+	}
+	for _, r := range f.Resources {
+		subjects = append(subjects, guardrail.Subject{Stack: r.Stack, Label: r.Label})
+	}
+	return subjects
+}
+
+// planSubjects lists the resources a simulation resolved, with their stacks.
+func planSubjects(plan *tools.ChangePlan) []guardrail.Subject {
+	if plan == nil {
+		return nil
+	}
+	var subjects []guardrail.Subject
+	for _, g := range plan.Groups {
+		for _, c := range g.Changes {
+			subjects = append(subjects, guardrail.Subject{Stack: g.Stack, Label: c.Label})
+		}
+	}
+	return subjects
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// withGuardrails points the guardrail config at a temp file holding content.
+func withGuardrails(t *testing.T, content string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "guardrails.json")
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FORMAE_MCP_GUARDRAILS", p)
+}
+
+func TestDestroyFormaRefusesProtectedStackResolvedBySimulation(t *testing.T) {
+	withGuardrails(t, `{"protectedStacks":["pro*"],"allowOverride":true}`)
+	submitted := 0
+	session := connectTestServer(t, destroyAgent(t, &submitted))
+
+	// The query names no stack; the simulation resolves it to stack prod.
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "type:AWS::S3::Bucket"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || !strings.Contains(textContent(t, result), `stack "prod" matches protected pattern "pro*"`) {
+		t.Errorf("expected a guardrail refusal, got: %s", textContent(t, result))
+	}
+	if submitted != 0 {
+		t.Errorf("destroy submitted %d times despite the guardrail", submitted)
+	}
+
+	result, err = session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "type:AWS::S3::Bucket", "override_protection": true},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError || submitted != 1 {
+		t.Errorf("expected the override to submit the destroy, got %d submissions: %s", submitted, textContent(t, result))
+	}
+}
+
+func TestDestroyFormaRefusesUnresolvedQueryUnderGuardrails(t *testing.T) {
+	withGuardrails(t, `{"protectedLabels":["*-primary"]}`)
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"POST /api/v1/commands": func(w http.ResponseWriter, r *http.Request) {
+			if parseSimulateField(t, r) != "true" {
+				t.Error("a real destroy reached the agent")
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "stack:prod", "override_protection": true},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	text := textContent(t, result)
+	if !result.IsError || !strings.Contains(text, "could not be determined") || !strings.Contains(text, "Overrides are disabled") {
+		t.Errorf("expected a non-overridable refusal, got: %s", text)
+	}
+}
+
+func TestForceReconcileStackRefusesProtectedStack(t *testing.T) {
+	withGuardrails(t, `{"protectedStacks":["prod-*"]}`)
+	session := connectTestServer(t, "http://localhost:1")
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "force_reconcile_stack",
+		Arguments: map[string]any{"stack": "prod-db"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || !strings.Contains(textContent(t, result), "force_reconcile_stack refused by guardrails") {
+		t.Errorf("expected a guardrail refusal, got: %s", textContent(t, result))
+	}
+}
+
+func TestFormaSubjects(t *testing.T) {
+	got := formaSubjects([]byte(`{"Stacks":[{"Label":"prod"}],"Resources":[{"Label":"db","Stack":"prod","Type":"AWS::RDS::DBInstance"}]}`))
+	if len(got) != 2 || got[0].Stack != "prod" || got[0].Label != "" || got[1].Label != "db" || got[1].Stack != "prod" {
+		t.Errorf("formaSubjects = %+v", got)
+	}
+}
+
+func TestGuardrailsRefuseWhenTheActiveProfileIsUnreadable(t *testing.T) {
+	withGuardrails(t, `{"protectedProfiles":["prod"]}`)
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	// An active pointer that is not a valid profile name.
+	if err := writeTestFile(filepath.Join(dir, "active"), "../prod\n"); err != nil {
+		t.Fatal(err)
+	}
+	session := connectTestServer(t, "")
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "force_reconcile_stack",
+		Arguments: map[string]any{"stack": "dev"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if text := textContent(t, result); !result.IsError || !strings.Contains(text, "cannot tell which profile") {
+		t.Errorf("got:\n%s\nwant:\na refusal because the active profile cannot be read", text)
+	}
+
+	s := New("")
+	if _, err := s.guardedProfile(""); err == nil {
+		t.Error("got:\nnil\nwant:\nthe active profile error")
+	}
+	if err := os.Remove(filepath.Join(dir, "active")); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := s.guardedProfile(""); err != nil || name != "" {
+		t.Errorf("got:\n%q, %v\nwant:\nno profile and no error without an active pointer", name, err)
+	}
+}
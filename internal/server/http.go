@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// httpSessionTimeout closes streamable-HTTP sessions that have been idle
+// this long, so a shared server does not accumulate sessions from clients
+// that went away without sending DELETE.
+const httpSessionTimeout = 30 * time.Minute
+
+// httpShutdownTimeout bounds how long RunHTTP waits for in-flight requests
+// (including open SSE streams) to drain after the context is cancelled.
+var httpShutdownTimeout = 10 * time.Second
+
+// HTTPHandler returns an http.Handler serving the MCP streamable-HTTP transport
+// at /mcp plus a /healthz liveness endpoint. Every client gets its own MCP
+// session keyed by the Mcp-Session-Id header, served by its own Server (see
+// sessionServer), so plan tokens, subscriptions and the profile use_profile
+// selects stay with the session. Browser requests from other sites are
+// refused (see checkOrigin); there is no other authentication, so anyone who
+// can reach the address can call every tool.
+func (s *Server) HTTPHandler() http.Handler {
+	mcpHandler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server {
+		return s.sessionServer().mcpServer
+	}, &mcp.StreamableHTTPOptions{
+		SessionTimeout: httpSessionTimeout,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.Handle("/mcp", checkOrigin(allowedOrigins(), mcpHandler))
+	return mux
+}
+
+// allowedOrigins reads FORMAE_MCP_ALLOWED_ORIGINS: comma-separated origins
+// (scheme://host[:port]) that may call /mcp from a browser in addition to
+// loopback ones.
+func allowedOrigins() map[string]bool {
+	allowed := map[string]bool{}
+	for _, o := range strings.Split(os.Getenv("FORMAE_MCP_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			allowed[o] = true
+		}
+	}
+	return allowed
+}
+
+// checkOrigin refuses requests whose Origin header names a site other than
+// a loopback one or one in allowed, so a web page the user happens to open
+// cannot drive the server's tools (cross-site requests, DNS rebinding).
+// MCP clients other than browsers send no Origin and are let through.
+func checkOrigin(allowed map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && !allowed[origin] && !isLoopbackOrigin(origin) {
+			http.Error(w, "origin not allowed: "+origin, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopbackOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleHealthz reports that this formae-mcp process is up. It deliberately
+// does not probe the formae agent — use the check_health tool for that — so a
+// load balancer does not take the MCP server out of rotation when the agent
+// restarts.
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// RunHTTP serves the MCP streamable-HTTP transport on addr until ctx is
+// cancelled, then shuts the listener down gracefully. An address without a
+// host (":8080") listens on loopback only; name a host or 0.0.0.0 to accept
+// connections from other machines. It returns nil after a clean shutdown.
+func (s *Server) RunHTTP(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", listenAddr(addr))
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	slog.InfoContext(ctx, "serving MCP streamable HTTP", "url", "http://"+ln.Addr().String()+"/mcp")
+	return s.serveHTTP(ctx, ln)
+}
+
+// listenAddr binds a host-less address to loopback.
+func listenAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// serveHTTP is RunHTTP over an existing listener, so tests can bind :0.
+func (s *Server) serveHTTP(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.HTTPHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Requests do not derive from ctx: cancelling it starts Shutdown,
+		// which lets in-flight tool calls finish within httpShutdownTimeout.
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Open SSE streams never finish on their own; give them a bounded grace
+	// period, then force-close whatever is left.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestHTTPHandler_Healthz(t *testing.T) {
+	srv := httptest.NewServer(New("http://localhost:1").HTTPHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if strings.TrimSpace(string(body)) != "ok" {
+		t.Errorf("body = %q, want ok", body)
+	}
+}
+
+func TestHTTPHandler_StreamableSessions(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/health": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		},
+	})
+	defer agent.Close()
+
+	srv := httptest.NewServer(New(agent.URL).HTTPHandler())
+	defer srv.Close()
+
+	// Two clients against the same server get independent sessions.
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, nil)
+		session, err := client.Connect(context.Background(), &mcp.StreamableClientTransport{Endpoint: srv.URL + "/mcp"}, nil)
+		if err != nil {
+			t.Fatalf("client.Connect failed: %v", err)
+		}
+		ids[session.ID()] = true
+
+		result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "check_health"})
+		if err != nil {
+			t.Fatalf("CallTool failed: %v", err)
+		}
+		if result.IsError {
+			t.Fatalf("expected success, got error: %s", textContent(t, result))
+		}
+		_ = session.Close()
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected 2 distinct session IDs, got %v", ids)
+	}
+}
+
+func TestHTTPHandler_UseProfileStaysInItsSession(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	health := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	var hitA, hitB atomic.Int32
+	agentA := mockAgent(t, map[string]http.HandlerFunc{"GET /api/v1/health": func(w http.ResponseWriter, r *http.Request) {
+		hitA.Add(1)
+		health(w, r)
+	}})
+	defer agentA.Close()
+	agentB := mockAgent(t, map[string]http.HandlerFunc{"GET /api/v1/health": func(w http.ResponseWriter, r *http.Request) {
+		hitB.Add(1)
+		health(w, r)
+	}})
+	defer agentB.Close()
+	setActive := withAgentProfiles(t, map[string]string{"a": agentA.URL, "b": agentB.URL})
+	setActive("a")
+
+	srv := httptest.NewServer(New("").HTTPHandler())
+	t.Cleanup(srv.Close) // after the sessions close
+	connect := func() *mcp.ClientSession {
+		client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, nil)
+		session, err := client.Connect(context.Background(), &mcp.StreamableClientTransport{Endpoint: srv.URL + "/mcp"}, nil)
+		if err != nil {
+			t.Fatalf("client.Connect failed: %v", err)
+		}
+		t.Cleanup(func() { _ = session.Close() })
+		return session
+	}
+	call := func(session *mcp.ClientSession, name string, args map[string]any) {
+		t.Helper()
+		res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: name, Arguments: args})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.IsError {
+			t.Fatalf("%s: %s", name, textContent(t, res))
+		}
+	}
+	first, second := connect(), connect()
+
+	call(first, "use_profile", map[string]any{"name": "b"})
+	call(first, "check_health", nil)
+	if a, b := hitA.Load(), hitB.Load(); a != 0 || b != 1 {
+		t.Errorf("got:\nagent a %d, agent b %d calls\nwant:\nthe switched session on agent b", a, b)
+	}
+	call(second, "check_health", nil)
+	if a, b := hitA.Load(), hitB.Load(); a != 1 || b != 1 {
+		t.Errorf("got:\nagent a %d, agent b %d calls\nwant:\nthe other session still on the active profile's agent a", a, b)
+	}
+	if got, _ := os.ReadFile(filepath.Join(os.Getenv("FORMAE_CONFIG_DIR"), "active")); strings.TrimSpace(string(got)) != "a" {
+		t.Errorf("got:\nactive profile %q\nwant:\na, untouched", got)
+	}
+}
+
+func TestServeHTTP_ShutsDownOnCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- New("http://localhost:1").serveHTTP(ctx, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serveHTTP returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveHTTP did not return after cancel")
+	}
+}
+
+func TestHTTPHandler_MCPOnlyAtMCPPath(t *testing.T) {
+	srv := httptest.NewServer(New("http://localhost:1").HTTPHandler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got:\n%d for POST /\nwant:\n404", resp.StatusCode)
+	}
+}
+
+func TestHTTPHandler_ChecksOrigin(t *testing.T) {
+	t.Setenv("FORMAE_MCP_ALLOWED_ORIGINS", "https://console.example.com")
+	srv := httptest.NewServer(New("http://localhost:1").HTTPHandler())
+	defer srv.Close()
+
+	for origin, want := range map[string]bool{
+		"https://evil.example":        false,
+		"http://attacker.test:8080":   false,
+		"https://console.example.com": true,
+		"http://localhost:3000":       true,
+		"http://127.0.0.1:8080":       true,
+		"":                            true,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/mcp", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if got := resp.StatusCode != http.StatusForbidden; got != want {
+			t.Errorf("Origin %q: got:\nstatus %d\nwant:\nallowed=%t", origin, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		":8080":         "127.0.0.1:8080",
+		"0.0.0.0:8080":  "0.0.0.0:8080",
+		"10.0.0.5:9000": "10.0.0.5:9000",
+		"[::1]:8080":    "[::1]:8080",
+	} {
+		if got := listenAddr(in); got != want {
+			t.Errorf("listenAddr(%q): got:\n%s\nwant:\n%s", in, got, want)
+		}
+	}
+}
+
+func TestServeHTTP_DrainsInFlightCallsOnCancel(t *testing.T) {
+	prev := httpShutdownTimeout
+	httpShutdownTimeout = 2 * time.Second
+	t.Cleanup(func() { httpShutdownTimeout = prev })
+
+	started := make(chan struct{})
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/health": func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		},
+	})
+	defer agent.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- New(agent.URL).serveHTTP(ctx, ln) }()
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, nil)
+	session, err := client.Connect(context.Background(), &mcp.StreamableClientTransport{Endpoint: "http://" + ln.Addr().String() + "/mcp"}, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	defer func() { _ = session.Close() }()
+
+	go func() {
+		<-started
+		cancel()
+	}()
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "check_health"})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("got:\n%s\nwant:\nthe in-flight call to finish despite the shutdown", textContent(t, result))
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveHTTP did not return after cancel")
+	}
+}
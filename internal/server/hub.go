@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,10 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 )
 
 const defaultHubBaseURL = "https://hub.platform.engineering"
@@ -51,12 +56,12 @@ type HubPluginDetail struct {
 	GithubRepoURL string `json:"github_repo_url"`
 }
 
-func (c *HubClient) SearchPlugins(query string) ([]HubPlugin, error) {
+func (c *HubClient) SearchPlugins(ctx context.Context, query string) ([]HubPlugin, error) {
 	u := c.baseURL + "/api/v1/plugins"
 	if query != "" {
 		u += "?q=" + url.QueryEscape(query)
 	}
-	resp, err := c.httpClient.Get(u)
+	resp, err := c.get(ctx, u)
 	if err != nil {
 		return nil, fmt.Errorf("hub request failed: %w", err)
 	}
@@ -84,9 +89,9 @@ func (c *HubClient) SearchPlugins(query string) ([]HubPlugin, error) {
 	return filtered, nil
 }
 
-func (c *HubClient) GetPlugin(name string) (HubPluginDetail, error) {
+func (c *HubClient) GetPlugin(ctx context.Context, name string) (HubPluginDetail, error) {
 	var d HubPluginDetail
-	resp, err := c.httpClient.Get(c.baseURL + "/api/v1/plugins/" + url.PathEscape(name))
+	resp, err := c.get(ctx, c.baseURL+"/api/v1/plugins/"+url.PathEscape(name))
 	if err != nil {
 		return d, fmt.Errorf("hub request failed: %w", err)
 	}
@@ -100,6 +105,29 @@ func (c *HubClient) GetPlugin(name string) (HubPluginDetail, error) {
 	return d, nil
 }
 
+// get issues a GET bound to ctx, so a cancelled tool call stops waiting on
+// the hub or GitHub.
+func (c *HubClient) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, span := telemetry.StartHTTP(req)
+	defer span.End()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			telemetry.Fail(req.Context(), span, telemetry.ErrHub, err.Error())
+		}
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		telemetry.Fail(req.Context(), span, telemetry.ErrHub, resp.Status, attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	return resp, nil
+}
+
 func containsFold(haystack, needle string) bool {
 	return len(needle) == 0 ||
 		len(haystack) >= len(needle) &&
@@ -159,9 +187,9 @@ func ownerRepo(repoURL string) (string, string, error) {
 }
 
 // tagExists checks whether a git tag exists on the repo.
-func (c *HubClient) tagExists(owner, repo, tag string) bool {
+func (c *HubClient) tagExists(ctx context.Context, owner, repo, tag string) bool {
 	u := fmt.Sprintf("%s/repos/%s/%s/git/refs/tags/%s", c.githubBase(), owner, repo, url.PathEscape(tag))
-	resp, err := c.httpClient.Get(u)
+	resp, err := c.get(ctx, u)
 	if err != nil {
 		return false
 	}
@@ -171,12 +199,12 @@ func (c *HubClient) tagExists(owner, repo, tag string) bool {
 
 // resolveRef returns the tag matching version (trying "v<version>" then
 // "<version>"), or "" when none exists (caller falls back to default branch).
-func (c *HubClient) resolveRef(owner, repo, version string) string {
+func (c *HubClient) resolveRef(ctx context.Context, owner, repo, version string) string {
 	if version == "" {
 		return ""
 	}
 	for _, cand := range []string{"v" + version, version} {
-		if c.tagExists(owner, repo, cand) {
+		if c.tagExists(ctx, owner, repo, cand) {
 			return cand
 		}
 	}
@@ -184,7 +212,7 @@ func (c *HubClient) resolveRef(owner, repo, version string) string {
 }
 
 // listExamplesForRepo lists /examples entries at the given ref ("" = default branch).
-func (c *HubClient) listExamplesForRepo(repoURL, ref string) ([]Example, error) {
+func (c *HubClient) listExamplesForRepo(ctx context.Context, repoURL, ref string) ([]Example, error) {
 	owner, repo, err := ownerRepo(repoURL)
 	if err != nil {
 		return nil, err
@@ -193,7 +221,7 @@ func (c *HubClient) listExamplesForRepo(repoURL, ref string) ([]Example, error)
 	if ref != "" {
 		u += "?ref=" + url.QueryEscape(ref)
 	}
-	resp, err := c.httpClient.Get(u)
+	resp, err := c.get(ctx, u)
 	if err != nil {
 		return nil, fmt.Errorf("github request failed: %w", err)
 	}
@@ -225,16 +253,16 @@ func (c *HubClient) listExamplesForRepo(repoURL, ref string) ([]Example, error)
 
 // listExamplesResolved resolves the version to a ref and lists examples there,
 // falling back to the default branch (versionMatched=false) when no tag matches.
-func (c *HubClient) listExamplesResolved(repoURL, version string) (ListExamplesResult, error) {
+func (c *HubClient) listExamplesResolved(ctx context.Context, repoURL, version string) (ListExamplesResult, error) {
 	var res ListExamplesResult
 	owner, repo, err := ownerRepo(repoURL)
 	if err != nil {
 		return res, err
 	}
-	ref := c.resolveRef(owner, repo, version)
+	ref := c.resolveRef(ctx, owner, repo, version)
 	res.RefUsed = ref
 	res.VersionMatched = ref != ""
-	exs, err := c.listExamplesForRepo(repoURL, ref)
+	exs, err := c.listExamplesForRepo(ctx, repoURL, ref)
 	if err != nil {
 		return res, err
 	}
@@ -244,10 +272,10 @@ func (c *HubClient) listExamplesResolved(repoURL, version string) (ListExamplesR
 
 // ListExamples resolves the plugin's repo + catalog trust info, then lists
 // version-matched examples. version "" means "use the latest / default branch".
-func (c *HubClient) ListExamples(pluginName, version string) (ListExamplesResult, error) {
+func (c *HubClient) ListExamples(ctx context.Context, pluginName, version string) (ListExamplesResult, error) {
 	var res ListExamplesResult
 	res.Plugin = pluginName
-	d, err := c.GetPlugin(pluginName)
+	d, err := c.GetPlugin(ctx, pluginName)
 	if err != nil {
 		return res, err
 	}
@@ -255,7 +283,7 @@ func (c *HubClient) ListExamples(pluginName, version string) (ListExamplesResult
 		return res, fmt.Errorf("plugin %q has no github_repo_url", pluginName)
 	}
 	// Trust info comes from the list endpoint (detail has no originator).
-	if cat, err := c.SearchPlugins(pluginName); err == nil {
+	if cat, err := c.SearchPlugins(ctx, pluginName); err == nil {
 		for _, p := range cat {
 			if p.Name == pluginName {
 				res.OriginatorDomain = p.Originator.Domain
@@ -267,7 +295,7 @@ func (c *HubClient) ListExamples(pluginName, version string) (ListExamplesResult
 			}
 		}
 	}
-	out, err := c.listExamplesResolved(d.GithubRepoURL, version)
+	out, err := c.listExamplesResolved(ctx, d.GithubRepoURL, version)
 	if err != nil {
 		return res, err
 	}
@@ -278,12 +306,12 @@ func (c *HubClient) ListExamples(pluginName, version string) (ListExamplesResult
 }
 
 // GetExample fetches the PKL files in /examples/<name> at the version-matched ref.
-func (c *HubClient) GetExample(pluginName, exampleName, version string) (GetExampleResult, error) {
+func (c *HubClient) GetExample(ctx context.Context, pluginName, exampleName, version string) (GetExampleResult, error) {
 	var res GetExampleResult
 	res.Plugin = pluginName
 	res.Example = exampleName
 
-	d, err := c.GetPlugin(pluginName)
+	d, err := c.GetPlugin(ctx, pluginName)
 	if err != nil {
 		return res, err
 	}
@@ -292,7 +320,7 @@ func (c *HubClient) GetExample(pluginName, exampleName, version string) (GetExam
 	}
 
 	// Trust info + version resolution from catalog.
-	if cat, err := c.SearchPlugins(pluginName); err == nil {
+	if cat, err := c.SearchPlugins(ctx, pluginName); err == nil {
 		for _, p := range cat {
 			if p.Name == pluginName {
 				res.OriginatorDomain = p.Originator.Domain
@@ -309,7 +337,7 @@ func (c *HubClient) GetExample(pluginName, exampleName, version string) (GetExam
 	if err != nil {
 		return res, err
 	}
-	ref := c.resolveRef(owner, repo, version)
+	ref := c.resolveRef(ctx, owner, repo, version)
 	res.RefUsed = ref
 	res.VersionMatched = ref != ""
 
@@ -318,7 +346,7 @@ func (c *HubClient) GetExample(pluginName, exampleName, version string) (GetExam
 	if ref != "" {
 		u += "?ref=" + url.QueryEscape(ref)
 	}
-	resp, err := c.httpClient.Get(u)
+	resp, err := c.get(ctx, u)
 	if err != nil {
 		return res, fmt.Errorf("github request failed: %w", err)
 	}
@@ -343,7 +371,7 @@ func (c *HubClient) GetExample(pluginName, exampleName, version string) (GetExam
 		if e.DownloadURL == "" {
 			continue
 		}
-		fileResp, err := c.httpClient.Get(e.DownloadURL)
+		fileResp, err := c.get(ctx, e.DownloadURL)
 		if err != nil {
 			return res, fmt.Errorf("download %s: %w", e.Name, err)
 		}
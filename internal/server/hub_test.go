@@ -47,7 +47,7 @@ func TestHubClientSearchPlugins(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("")
+	plugins, err := c.SearchPlugins(context.Background(), "")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -79,7 +79,7 @@ func TestHubClientSearchPluginsWithFilter(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("k8s")
+	plugins, err := c.SearchPlugins(context.Background(), "k8s")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -111,7 +111,7 @@ func TestHubClientSearchPluginsFilterByNamespace(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("AWS")
+	plugins, err := c.SearchPlugins(context.Background(), "AWS")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -140,7 +140,7 @@ func TestHubClientSearchPluginsFilterByCategory(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("compute")
+	plugins, err := c.SearchPlugins(context.Background(), "compute")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -169,7 +169,7 @@ func TestHubClientSearchPluginsNoMatch(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("nonexistent")
+	plugins, err := c.SearchPlugins(context.Background(), "nonexistent")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -194,7 +194,7 @@ func TestHubClientSearchPluginsFilterBySummary(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("Kubernetes")
+	plugins, err := c.SearchPlugins(context.Background(), "Kubernetes")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -222,7 +222,7 @@ func TestHubClientSearchPluginsFilterByQualifiedName(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	plugins, err := c.SearchPlugins("platform.engineering/k8s")
+	plugins, err := c.SearchPlugins(context.Background(), "platform.engineering/k8s")
 	if err != nil {
 		t.Fatalf("SearchPlugins: %v", err)
 	}
@@ -244,7 +244,7 @@ func TestHubClientGetPlugin(t *testing.T) {
 	defer srv.Close()
 
 	c := &HubClient{baseURL: srv.URL, httpClient: srv.Client()}
-	d, err := c.GetPlugin("k8s")
+	d, err := c.GetPlugin(context.Background(), "k8s")
 	if err != nil {
 		t.Fatalf("GetPlugin: %v", err)
 	}
@@ -317,7 +317,7 @@ func TestHubClientListExamplesSortsAndFlags(t *testing.T) {
 	defer gh.Close()
 
 	c := &HubClient{githubBaseURL: gh.URL, httpClient: gh.Client()}
-	exs, err := c.listExamplesForRepo("https://github.com/platform-engineering-labs/formae-plugin-aws", "")
+	exs, err := c.listExamplesForRepo(context.Background(), "https://github.com/platform-engineering-labs/formae-plugin-aws", "")
 	if err != nil {
 		t.Fatalf("listExamplesForRepo: %v", err)
 	}
@@ -365,7 +365,7 @@ func TestHubClientListExamplesVersionMatched(t *testing.T) {
 	defer gh.Close()
 
 	c := &HubClient{githubBaseURL: gh.URL, httpClient: gh.Client()}
-	res, err := c.listExamplesResolved("https://github.com/platform-engineering-labs/formae-plugin-aws", "0.1.5")
+	res, err := c.listExamplesResolved(context.Background(), "https://github.com/platform-engineering-labs/formae-plugin-aws", "0.1.5")
 	if err != nil {
 		t.Fatalf("listExamplesResolved: %v", err)
 	}
@@ -392,7 +392,7 @@ func TestHubClientListExamplesVersionFallback(t *testing.T) {
 	defer gh.Close()
 
 	c := &HubClient{githubBaseURL: gh.URL, httpClient: gh.Client()}
-	res, err := c.listExamplesResolved("https://github.com/platform-engineering-labs/formae-plugin-aws", "0.9.9")
+	res, err := c.listExamplesResolved(context.Background(), "https://github.com/platform-engineering-labs/formae-plugin-aws", "0.9.9")
 	if err != nil {
 		t.Fatalf("listExamplesResolved: %v", err)
 	}
@@ -25,7 +25,8 @@ For deeper coverage, read formae://docs/concepts.
 1. **Always simulate before applying**: Use simulate=true to preview changes.
 2. **Drift handling**: The agent continuously syncs with cloud state. Drift can be overwritten (force-reconcile) or absorbed.
 3. **Discovery**: The agent finds unmanaged resources that can be imported.
-4. **Commands are async**: Apply/destroy run asynchronously. Use get_command_status or list_commands to monitor.
+4. **Commands are async**: Apply/destroy run asynchronously. Use wait_for_command to block until one finishes, or get_command_status / list_commands to check on it.
+5. **Audit trail**: Every mutating call made through this server is logged. Use list_audit_log when the user asks what was changed from here, and by which session.
 
 ## The IaC Language
 
@@ -58,7 +59,11 @@ A **profile** is a named formae config (endpoint + targets) selecting which agen
 
 - **Targeting your work** → pass ` + "`profile`" + ` on each call. Never call ` + "`use_profile`" + ` just to prepare a session.
 - **` + "`use_profile`" + ` (switching the active profile)** → only when the user **explicitly** asks to change their default environment/agent (e.g. "make prod my default"). It is not a per-session setup step.
-- **Which tools accept ` + "`profile`" + `**: the agent-touching tools — apply_forma, destroy_forma, cancel_commands, force_sync, force_discover, force_check_ttl, force_reconcile_stack, list_resources, list_stacks, list_targets, list_policies, list_commands, get_command_status, get_agent_stats, check_health, list_changes_since_last_reconcile, extract_resources. **Do not pass ` + "`profile`" + ` to** the plugin-hub tools (search_hub_plugins, get_hub_plugin, list_plugin_examples, get_plugin_example) or create_inline_policy — they do not support it and the call will be rejected.
+- **Which tools accept ` + "`profile`" + `**: the agent-touching tools — apply_forma, destroy_forma, cancel_commands, force_sync, force_discover, force_check_ttl, force_reconcile_stack, list_resources, list_stacks, list_targets, list_policies, list_commands, get_command_status, wait_for_command, get_agent_stats, check_health, list_changes_since_last_reconcile, diff_resource_drift, absorb_drift, extract_resources. **Do not pass ` + "`profile`" + ` to** the plugin-hub tools (search_hub_plugins, get_hub_plugin, list_plugin_examples, get_plugin_example) or create_inline_policy — they do not support it and the call will be rejected.
+
+## Live State Resources
+
+The active profile's agent state is also readable as MCP resources, for attaching as context without a tool call: formae://stacks/{label}, formae://stacks/{label}/resources, formae://targets/{label}, formae://commands/{id} and formae://resources/{type}/{label} (percent-encode the type's colons: AWS%%3A%%3AS3%%3A%%3ABucket). formae://stacks/{label}/drift lists a stack's changes since its last reconcile. formae://commands/{id} and formae://stacks/{label}/drift support resources/subscribe: the server polls the agent and sends notifications/resources/updated when the command changes state or the stack's drift changes, so a long apply does not need to be polled. Use the tools when you need a different profile, filters or paging.
 
 ## Query Syntax
 
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// diffJSON compares two decoded JSON documents and returns one change per
+// differing leaf, keyed by RFC 6901 JSON pointer. Objects are compared key by
+// key in sorted order and arrays index by index, so the result is stable.
+func diffJSON(old, new any) []tools.PropertyChange {
+	var out []tools.PropertyChange
+	diffJSONAt("", old, new, false, &out)
+	return out
+}
+
+// diffDeclared is diffJSON limited to the object keys old declares, at every
+// level: keys only new holds are not changes. It compares a desired state,
+// often partial, with a synced one that also carries read-only,
+// server-generated and defaulted fields the desired state never set.
+func diffDeclared(old, new any) []tools.PropertyChange {
+	var out []tools.PropertyChange
+	diffJSONAt("", old, new, true, &out)
+	return out
+}
+
+func diffJSONAt(path string, old, new any, declaredOnly bool, out *[]tools.PropertyChange) {
+	switch {
+	case old == nil && new == nil:
+		return
+	case old == nil:
+		*out = append(*out, tools.PropertyChange{Path: pointerOrRoot(path), Op: "add", New: new})
+		return
+	case new == nil:
+		*out = append(*out, tools.PropertyChange{Path: pointerOrRoot(path), Op: "remove", Old: old})
+		return
+	}
+
+	oldMap, oldIsMap := old.(map[string]any)
+	newMap, newIsMap := new.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		if !declaredOnly {
+			for k := range newMap {
+				if _, ok := oldMap[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffJSONAt(path+"/"+escapePointerToken(k), oldMap[k], newMap[k], declaredOnly, out)
+		}
+		return
+	}
+
+	oldArr, oldIsArr := old.([]any)
+	newArr, newIsArr := new.([]any)
+	if oldIsArr && newIsArr {
+		for i := 0; i < max(len(oldArr), len(newArr)); i++ {
+			var o, n any
+			if i < len(oldArr) {
+				o = oldArr[i]
+			}
+			if i < len(newArr) {
+				n = newArr[i]
+			}
+			diffJSONAt(path+"/"+strconv.Itoa(i), o, n, declaredOnly, out)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*out = append(*out, tools.PropertyChange{Path: pointerOrRoot(path), Op: "replace", Old: old, New: new})
+	}
+}
+
+// lookupPointer resolves an RFC 6901 pointer against a decoded document.
+func lookupPointer(doc any, pointer string) (any, bool) {
+	if pointer == "" || pointer == "/" {
+		return doc, true
+	}
+	cur := doc
+	for _, tok := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		tok = unescapePointerToken(tok)
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[tok]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// decodeJSONValue decodes raw JSON into generic values; empty or invalid input
+// yields nil.
+func decodeJSONValue(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+func pointerOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func escapePointerToken(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func unescapePointerToken(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// loggerName is the "logger" field of the notifications/message the server
+// sends.
+const loggerName = "formae-mcp"
+
+type sessionKey struct{}
+
+// sessionContextMiddleware puts the session a request arrived on into its
+// context, so log records made while handling it (with slog's *Context
+// functions) reach that session only.
+func sessionContextMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		if ss, ok := req.GetSession().(*mcp.ServerSession); ok {
+			ctx = withLogSessions(ctx, ss)
+		}
+		return next(ctx, method, req)
+	}
+}
+
+// withLogSessions routes the log records made with ctx to sessions only, for
+// work done outside a request on behalf of those sessions. With no sessions
+// the records reach no session at all.
+func withLogSessions(ctx context.Context, sessions ...*mcp.ServerSession) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessions)
+}
+
+// LogHandler returns a slog.Handler that writes every record to base and
+// also sends it as an MCP notifications/message: to the session whose
+// request the record's context belongs to (see withLogSessions), or to every
+// session when it has none (startup and config warnings). Background work
+// must log with a context naming its sessions, or its records reach all. Each session only receives records at
+// or above the level it set with logging/setLevel, and none before it sets
+// one. Install it with slog.SetDefault so records from every package are
+// routed; base must not be slog's default handler, which writes back
+// through the log package.
+func (s *Server) LogHandler(base slog.Handler) slog.Handler {
+	return &sessionLogHandler{server: s.mcpServer, base: base}
+}
+
+type sessionLogHandler struct {
+	server *mcp.Server
+	base   slog.Handler
+	attrs  []slog.Attr // from WithAttrs, keys already qualified by group
+	group  string      // dotted prefix from WithGroup
+}
+
+func (h *sessionLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.base.Enabled(ctx, level) {
+		return true
+	}
+	// Sessions filter by their own level; only build records when one may
+	// want them.
+	for range h.server.Sessions() {
+		return true
+	}
+	return false
+}
+
+func (h *sessionLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	if h.base.Enabled(ctx, r.Level) {
+		err = h.base.Handle(ctx, r)
+	}
+	params := &mcp.LoggingMessageParams{Logger: loggerName, Level: mcpLevel(r.Level), Data: h.data(r)}
+	if sessions, ok := ctx.Value(sessionKey{}).([]*mcp.ServerSession); ok {
+		for _, ss := range sessions {
+			_ = ss.Log(ctx, params)
+		}
+		return err
+	}
+	for ss := range h.server.Sessions() {
+		_ = ss.Log(ctx, params)
+	}
+	return err
+}
+
+func (h *sessionLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.base = h.base.WithAttrs(attrs)
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: h.group + a.Key, Value: a.Value})
+	}
+	return &h2
+}
+
+func (h *sessionLogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.base = h.base.WithGroup(name)
+	h2.group = h.group + name + "."
+	return &h2
+}
+
+// data renders a record as the notification payload: its message and
+// attributes as one JSON object.
+func (h *sessionLogHandler) data(r slog.Record) map[string]any {
+	data := map[string]any{"message": r.Message}
+	for _, a := range h.attrs {
+		addAttr(data, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(data, h.group, a)
+		return true
+	})
+	return data
+}
+
+func addAttr(data map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addAttr(data, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	switch x := v.Any().(type) {
+	case error:
+		data[prefix+a.Key] = x.Error()
+	case json.Marshaler, string, bool, int64, uint64, float64:
+		data[prefix+a.Key] = x
+	default:
+		data[prefix+a.Key] = v.String()
+	}
+}
+
+// mcpLevel maps a slog level onto the MCP (syslog) levels.
+func mcpLevel(l slog.Level) mcp.LoggingLevel {
+	switch {
+	case l >= slog.LevelError:
+		return "error"
+	case l >= slog.LevelWarn:
+		return "warning"
+	case l >= slog.LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// logEvalStderr logs what `formae eval` wrote to stderr (PKL warnings and
+// deprecations as well as errors), so it reaches the client's log pane.
+func logEvalStderr(ctx context.Context, path string, stderr []byte) {
+	if s := strings.TrimSpace(string(stderr)); s != "" {
+		slog.InfoContext(ctx, "formae eval wrote to stderr", "file", path, "stderr", s)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connectLoggingClient connects a client to s that records the log
+// notifications it receives.
+func connectLoggingClient(t *testing.T, s *Server) (*mcp.ClientSession, func() []*mcp.LoggingMessageParams) {
+	t.Helper()
+	ctx := context.Background()
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	var mu sync.Mutex
+	var got []*mcp.LoggingMessageParams
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, &mcp.ClientOptions{
+		LoggingMessageHandler: func(_ context.Context, req *mcp.LoggingMessageRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, req.Params)
+		},
+	})
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession, func() []*mcp.LoggingMessageParams {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*mcp.LoggingMessageParams(nil), got...)
+	}
+}
+
+// waitForLogs polls until messages returns at least n notifications.
+func waitForLogs(t *testing.T, messages func() []*mcp.LoggingMessageParams, n int) []*mcp.LoggingMessageParams {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := messages()
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogHandlerHonoursSessionLevel(t *testing.T) {
+	s := New("http://127.0.0.1:1")
+	session, messages := connectLoggingClient(t, s)
+	logger := slog.New(s.LogHandler(slog.DiscardHandler))
+	ctx := context.Background()
+
+	logger.Warn("before setLevel")
+	if err := session.SetLoggingLevel(ctx, &mcp.SetLoggingLevelParams{Level: "warning"}); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("below the level")
+	logger.With("profile", "prod").WithGroup("req").Warn("deprecated", "attempt", 2)
+
+	waitForLogs(t, messages, 1)
+	time.Sleep(50 * time.Millisecond) // anything else would have arrived by now
+	got := messages()
+	if len(got) != 1 {
+		t.Fatalf("want exactly the warning after setLevel, got %d messages", len(got))
+	}
+	data, _ := got[0].Data.(map[string]any)
+	if got[0].Level != "warning" || got[0].Logger != loggerName || data["message"] != "deprecated" ||
+		data["profile"] != "prod" || data["req.attempt"] != float64(2) {
+		t.Errorf("unexpected notification %+v", got[0])
+	}
+}
+
+func TestRetryLogsReachOnlyTheCallingSession(t *testing.T) {
+	prevRetry := defaultRetryPolicy
+	defaultRetryPolicy.base, defaultRetryPolicy.max = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { defaultRetryPolicy = prevRetry })
+
+	calls := 0
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		},
+	})
+	t.Cleanup(agent.Close)
+
+	s := New(agent.URL)
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(s.LogHandler(slog.DiscardHandler)))
+	t.Cleanup(func() { slog.SetDefault(prevLogger) })
+
+	caller, callerLogs := connectLoggingClient(t, s)
+	other, otherLogs := connectLoggingClient(t, s)
+	ctx := context.Background()
+	for _, cs := range []*mcp.ClientSession{caller, other} {
+		if err := cs.SetLoggingLevel(ctx, &mcp.SetLoggingLevelParams{Level: "info"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := caller.CallTool(ctx, &mcp.CallToolParams{Name: "list_stacks", Arguments: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := waitForLogs(t, callerLogs, 1)
+	if len(got) == 0 {
+		t.Fatal("calling session got no retry log")
+	}
+	if data, _ := got[0].Data.(map[string]any); data["message"] != "agent request failed, retrying" || data["reason"] != "status 503" {
+		t.Errorf("unexpected notification %+v", got[0])
+	}
+	if n := len(otherLogs()); n != 0 {
+		t.Errorf("other session got %d log notifications", n)
+	}
+}
+
+func TestWatcherLogsReachOnlyTheSubscribingSession(t *testing.T) {
+	prevRetry := defaultRetryPolicy
+	defaultRetryPolicy.base, defaultRetryPolicy.max = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { defaultRetryPolicy = prevRetry })
+	prevPoll := subscriptionPollInterval
+	subscriptionPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() { subscriptionPollInterval = prevPoll })
+
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	})
+	t.Cleanup(agent.Close)
+
+	s := New(agent.URL)
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(s.LogHandler(slog.DiscardHandler)))
+	t.Cleanup(func() { slog.SetDefault(prevLogger) })
+
+	subscriber, subscriberLogs := connectLoggingClient(t, s)
+	other, otherLogs := connectLoggingClient(t, s)
+	ctx := context.Background()
+	for _, cs := range []*mcp.ClientSession{subscriber, other} {
+		if err := cs.SetLoggingLevel(ctx, &mcp.SetLoggingLevelParams{Level: "debug"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := subscriber.Subscribe(ctx, &mcp.SubscribeParams{URI: "formae://commands/cmd-1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for a poll's own log, past the ones the subscribe request made.
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.ContainsFunc(subscriberLogs(), func(m *mcp.LoggingMessageParams) bool {
+		data, _ := m.Data.(map[string]any)
+		return data["message"] == "resource watcher: read failed"
+	}) {
+		if time.Now().After(deadline) {
+			t.Fatal("subscribing session got no watcher log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := otherLogs(); len(got) != 0 {
+		t.Errorf("got:\n%d log notifications, first %+v\nwant:\nnone for the session that did not subscribe", len(got), got[0].Data)
+	}
+}
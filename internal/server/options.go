@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Options select which tools a Server exposes.
+type Options struct {
+	// ReadOnly registers only tools annotated ReadOnlyHint, so the server can
+	// observe infrastructure but has no tool that changes it.
+	ReadOnly bool
+	// Tools further restricts the registered tools by name.
+	Tools ToolFilter
+}
+
+// ToolFilter selects tools by name with glob patterns (path.Match syntax). A
+// tool is kept when Allow is empty or one of its patterns matches, and no Deny
+// pattern matches.
+type ToolFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+// ParseToolFilter parses a --tools value: comma-separated name patterns, each
+// allowing the tools it matches, or denying them when prefixed with "-".
+// "list_*,get_*" keeps only those tools; "-destroy_forma,-force_*" keeps all
+// but those.
+func ParseToolFilter(spec string) (ToolFilter, error) {
+	var f ToolFilter
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		deny := strings.HasPrefix(entry, "-")
+		pattern := strings.TrimPrefix(entry, "-")
+		if pattern == "" {
+			if entry == "" {
+				continue
+			}
+			return ToolFilter{}, fmt.Errorf("--tools: empty pattern in %q", spec)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ToolFilter{}, fmt.Errorf("--tools: bad pattern %q: %w", pattern, err)
+		}
+		if deny {
+			f.Deny = append(f.Deny, pattern)
+		} else {
+			f.Allow = append(f.Allow, pattern)
+		}
+	}
+	return f, nil
+}
+
+// IsZero reports whether the filter keeps every tool.
+func (f ToolFilter) IsZero() bool {
+	return len(f.Allow) == 0 && len(f.Deny) == 0
+}
+
+// Allows reports whether the filter keeps the named tool.
+func (f ToolFilter) Allows(name string) bool {
+	if len(f.Allow) > 0 && !matchesAny(f.Allow, name) {
+		return false
+	}
+	return !matchesAny(f.Deny, name)
+}
+
+// String renders the filter in --tools syntax.
+func (f ToolFilter) String() string {
+	entries := append([]string{}, f.Allow...)
+	for _, p := range f.Deny {
+		entries = append(entries, "-"+p)
+	}
+	return strings.Join(entries, ",")
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addTool registers a tool unless the server's options leave it out.
+func addTool[In, Out any](s *Server, t *mcp.Tool, h mcp.ToolHandlerFor[In, Out]) {
+	s.declaredTools = append(s.declaredTools, t.Name)
+	readOnly := t.Annotations != nil && t.Annotations.ReadOnlyHint
+	if s.opts.ReadOnly && !readOnly {
+		return
+	}
+	if !s.opts.Tools.Allows(t.Name) {
+		return
+	}
+	mcp.AddTool(s.mcpServer, t, h)
+}
+
+// warnUnmatchedToolPatterns logs --tools patterns that match no tool the
+// server has, which are usually typos that silently hide (or fail to hide) a
+// tool.
+func (s *Server) warnUnmatchedToolPatterns() {
+	for _, p := range slices.Concat(s.opts.Tools.Allow, s.opts.Tools.Deny) {
+		if !slices.ContainsFunc(s.declaredTools, func(name string) bool { return matchesAny([]string{p}, name) }) {
+			slog.Warn("--tools pattern matches no tool", "pattern", p)
+		}
+	}
+}
+
+// readOnlyInstructions is appended to the server instructions in read-only mode.
+const readOnlyInstructions = `
+
+## Read-Only Mode
+
+This server was started read-only: only tools that observe infrastructure are available. There is no apply, destroy, cancel, force_*, profile-switching or policy-planning tool. Do not attempt changes through other means; when the user wants one, show them what to run themselves (e.g. the formae CLI command).`
+
+// instructionsFor adjusts the server instructions to the tools opts expose.
+func instructionsFor(opts Options) string {
+	text := serverInstructions
+	if opts.ReadOnly {
+		text += readOnlyInstructions
+	}
+	if !opts.Tools.IsZero() {
+		text += fmt.Sprintf("\n\n## Restricted Tools\n\nThis server was started with --tools %s, so some tools mentioned above may not be available. Use only the tools the server lists.", opts.Tools)
+	}
+	return text
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connectTestServerWithOptions is connectTestServer for a server built with opts.
+func connectTestServerWithOptions(t *testing.T, opts Options) *mcp.ClientSession {
+	t.Helper()
+	ctx := context.Background()
+	s := NewWithOptions("http://localhost:1", opts)
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, nil)
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession
+}
+
+func listedTools(t *testing.T, session *mcp.ClientSession) []*mcp.Tool {
+	t.Helper()
+	res, err := session.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	return res.Tools
+}
+
+func TestReadOnlyModeRegistersOnlyReadOnlyTools(t *testing.T) {
+	session := connectTestServerWithOptions(t, Options{ReadOnly: true})
+	listed := listedTools(t, session)
+	all := listedTools(t, connectTestServer(t, "http://localhost:1"))
+
+	readOnly := 0
+	for _, tool := range all {
+		if tool.Annotations != nil && tool.Annotations.ReadOnlyHint {
+			readOnly++
+		}
+	}
+	if len(listed) != readOnly {
+		t.Errorf("read-only server lists %d tools, want the %d read-only ones", len(listed), readOnly)
+	}
+	for _, tool := range listed {
+		if tool.Annotations == nil || !tool.Annotations.ReadOnlyHint {
+			t.Errorf("read-only server registered mutation tool %s", tool.Name)
+		}
+	}
+
+	res, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "destroy_forma",
+		Arguments: map[string]any{"query": "stack:prod"},
+	})
+	if err == nil && !res.IsError {
+		t.Error("destroy_forma is callable on a read-only server")
+	}
+	if !strings.Contains(session.InitializeResult().Instructions, "## Read-Only Mode") {
+		t.Error("instructions do not mention read-only mode")
+	}
+}
+
+func TestToolFilterSelectsTools(t *testing.T) {
+	f, err := ParseToolFilter("list_*,-list_profiles,check_health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := connectTestServerWithOptions(t, Options{Tools: f})
+	var names []string
+	for _, tool := range listedTools(t, session) {
+		names = append(names, tool.Name)
+	}
+	if !slices.Contains(names, "list_stacks") || !slices.Contains(names, "check_health") {
+		t.Errorf("allowed tools missing: %v", names)
+	}
+	for _, name := range names {
+		if name == "list_profiles" || !(strings.HasPrefix(name, "list_") || name == "check_health") {
+			t.Errorf("tool %s should have been filtered out", name)
+		}
+	}
+	if !strings.Contains(session.InitializeResult().Instructions, "--tools list_*,check_health,-list_profiles") {
+		t.Error("instructions do not mention the tool filter")
+	}
+}
+
+func TestToolFilterAllows(t *testing.T) {
+	f := ToolFilter{Deny: []string{"force_*", "destroy_forma"}}
+	for name, want := range map[string]bool{"list_stacks": true, "force_sync": false, "destroy_forma": false} {
+		if got := f.Allows(name); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
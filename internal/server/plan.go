@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// planTokenTTL bounds how long a simulation stays approvable. A plan older
+// than this is stale enough that infrastructure may have moved underneath it.
+const planTokenTTL = time.Hour
+
+// planInputs are what a plan token covers besides the forma itself: the
+// flags that change how the agent treats it, and the agent it goes to.
+type planInputs struct {
+	mode    string
+	force   bool
+	profile string // the per-call profile, else the active one at the time
+	// endpoint is the agent the plan was simulated against, so switching the
+	// active profile between simulate and apply cannot retarget the apply.
+	endpoint string
+}
+
+// planRecord is what a simulate recorded about the plan it issued a token for,
+// so a mismatched apply can say which input differs.
+type planRecord struct {
+	filePath string
+	inputs   planInputs
+	issued   time.Time
+}
+
+// planStore holds the plan tokens issued by apply_forma simulations. Tokens
+// are single-use: take retires a token as it approves the apply.
+type planStore struct {
+	mu    sync.Mutex
+	plans map[string]planRecord
+	now   func() time.Time
+}
+
+func newPlanStore() *planStore {
+	return &planStore{plans: make(map[string]planRecord), now: time.Now}
+}
+
+// planToken hashes exactly what a real apply would submit: the evaluated forma
+// JSON plus the flags that change how the agent treats it and where it goes.
+// Fields are length-prefixed so no two inputs share an encoding.
+func planToken(formaJSON []byte, in planInputs) string {
+	h := sha256.New()
+	for _, part := range [][]byte{formaJSON, []byte(in.mode), []byte(fmt.Sprintf("%t", in.force)), []byte(in.profile), []byte(in.endpoint)} {
+		_, _ = fmt.Fprintf(h, "%d:", len(part))
+		_, _ = h.Write(part)
+	}
+	return "plan-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// issue records a simulated plan and returns its token.
+func (p *planStore) issue(formaJSON []byte, filePath string, in planInputs) string {
+	token := planToken(formaJSON, in)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for t, rec := range p.plans {
+		if now.Sub(rec.issued) > planTokenTTL {
+			delete(p.plans, t)
+		}
+	}
+	p.plans[token] = planRecord{filePath: filePath, inputs: in, issued: now}
+	return token
+}
+
+// take checks that token was issued by a prior simulation and that the
+// evaluated file, flags and target still hash to it, and retires it in the
+// same step, so concurrent applies cannot both pass with one token. A token
+// that does not match is left in place.
+func (p *planStore) take(token string, formaJSON []byte, filePath string, in planInputs) error {
+	if token == "" {
+		return fmt.Errorf("plan_token is required for a real apply: run apply_forma with simulate=true first, confirm the plan with the user, then pass the returned plan_token")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec, ok := p.plans[token]
+	if !ok || p.now().Sub(rec.issued) > planTokenTTL {
+		return fmt.Errorf("plan token %s is unknown, expired, or already used: re-run apply_forma with simulate=true and confirm the new plan", token)
+	}
+	if r := rec.inputs; r != in {
+		return fmt.Errorf("plan token was issued for mode=%s force=%t profile=%q agent=%s, but this apply uses mode=%s force=%t profile=%q agent=%s: re-run the simulation with the same arguments",
+			r.mode, r.force, r.profile, r.endpoint, in.mode, in.force, in.profile, in.endpoint)
+	}
+	if planToken(formaJSON, in) != token {
+		return fmt.Errorf("%s has changed since it was simulated: re-run apply_forma with simulate=true and confirm the new plan", filePath)
+	}
+	delete(p.plans, token)
+	return nil
+}
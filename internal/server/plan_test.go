@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var planTokenRe = regexp.MustCompile(`plan_token: (plan-[0-9a-f]{64})`)
+
+func simulateForToken(t *testing.T, session *mcp.ClientSession, args map[string]any) string {
+	t.Helper()
+	sim := map[string]any{"simulate": true}
+	for k, v := range args {
+		sim[k] = v
+	}
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "apply_forma", Arguments: sim})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("simulate failed: %s", textContent(t, result))
+	}
+	for _, c := range result.Content {
+		if tc, ok := c.(*mcp.TextContent); ok {
+			if m := planTokenRe.FindStringSubmatch(tc.Text); m != nil {
+				return m[1]
+			}
+		}
+	}
+	t.Fatalf("no plan_token in simulate result: %+v", result.Content)
+	return ""
+}
+
+func applyWithToken(t *testing.T, session *mcp.ClientSession, args map[string]any, token string) *mcp.CallToolResult {
+	t.Helper()
+	real := map[string]any{"simulate": false}
+	for k, v := range args {
+		real[k] = v
+	}
+	if token != "" {
+		real["plan_token"] = token
+	}
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "apply_forma", Arguments: real})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	return result
+}
+
+func TestApplyForma_PlanToken(t *testing.T) {
+	var applied atomic.Int32
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"POST /api/v1/commands": func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("simulate") == "true" {
+				w.WriteHeader(http.StatusOK)
+				_, _ = fmt.Fprint(w, `{"Simulation":{"ChangesRequired":true}}`)
+				return
+			}
+			applied.Add(1)
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = fmt.Fprint(w, `{"CommandID":"cmd-1"}`)
+		},
+	})
+	defer agent.Close()
+
+	file := t.TempDir() + "/main.json"
+	if err := writeTestFile(file, `{"Stacks":[{"Label":"prod"}]}`); err != nil {
+		t.Fatal(err)
+	}
+	args := map[string]any{"file_path": file, "mode": "reconcile"}
+
+	t.Run("missing token refused", func(t *testing.T) {
+		session := connectTestServer(t, agent.URL)
+		result := applyWithToken(t, session, args, "")
+		if !result.IsError || !strings.Contains(textContent(t, result), "plan_token is required") {
+			t.Fatalf("expected plan_token required error, got %s", textContent(t, result))
+		}
+	})
+
+	t.Run("matching token applies once", func(t *testing.T) {
+		session := connectTestServer(t, agent.URL)
+		token := simulateForToken(t, session, args)
+		before := applied.Load()
+		result := applyWithToken(t, session, args, token)
+		if result.IsError {
+			t.Fatalf("expected apply to succeed, got %s", textContent(t, result))
+		}
+		if applied.Load() != before+1 {
+			t.Fatal("expected the agent to receive the apply")
+		}
+		// Single use.
+		result = applyWithToken(t, session, args, token)
+		if !result.IsError || !strings.Contains(textContent(t, result), "already used") {
+			t.Fatalf("expected reused token to be refused, got %s", textContent(t, result))
+		}
+	})
+
+	t.Run("changed file refused", func(t *testing.T) {
+		session := connectTestServer(t, agent.URL)
+		token := simulateForToken(t, session, args)
+		if err := writeTestFile(file, `{"Stacks":[{"Label":"prod"},{"Label":"extra"}]}`); err != nil {
+			t.Fatal(err)
+		}
+		before := applied.Load()
+		result := applyWithToken(t, session, args, token)
+		if !result.IsError || !strings.Contains(textContent(t, result), "has changed since it was simulated") {
+			t.Fatalf("expected changed-file error, got %s", textContent(t, result))
+		}
+		if applied.Load() != before {
+			t.Fatal("agent must not receive an apply for a changed file")
+		}
+	})
+
+	t.Run("different flags refused", func(t *testing.T) {
+		session := connectTestServer(t, agent.URL)
+		token := simulateForToken(t, session, args)
+		forced := map[string]any{"file_path": file, "mode": "reconcile", "force": true}
+		result := applyWithToken(t, session, forced, token)
+		if !result.IsError || !strings.Contains(textContent(t, result), "force=false") {
+			t.Fatalf("expected flag mismatch error, got %s", textContent(t, result))
+		}
+	})
+}
+
+func TestPlanStore_Expires(t *testing.T) {
+	p := newPlanStore()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	in := planInputs{mode: "patch", endpoint: "http://agent:1"}
+	token := p.issue([]byte(`{}`), "/f.json", in)
+	now = now.Add(planTokenTTL + time.Minute)
+	if err := p.take(token, []byte(`{}`), "/f.json", in); err == nil {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestPlanStore_TakeIsSingleUse(t *testing.T) {
+	p := newPlanStore()
+	in := planInputs{mode: "patch", endpoint: "http://agent:1"}
+	token := p.issue([]byte(`{}`), "/f.json", in)
+
+	// A mismatched apply leaves the token for the right one.
+	other := in
+	other.endpoint = "http://other:1"
+	if err := p.take(token, []byte(`{}`), "/f.json", other); err == nil || !strings.Contains(err.Error(), "agent=http://other:1") {
+		t.Fatalf("got:\n%v\nwant:\nan agent mismatch", err)
+	}
+
+	var wg sync.WaitGroup
+	var taken atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.take(token, []byte(`{}`), "/f.json", in) == nil {
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := taken.Load(); n != 1 {
+		t.Errorf("got:\n%d applies approved by one token\nwant:\n1", n)
+	}
+}
+
+func TestPlanToken_CoversInputs(t *testing.T) {
+	in := planInputs{mode: "reconcile"}
+	base := planToken([]byte(`{"a":1}`), in)
+	for name, other := range map[string]string{
+		"json":     planToken([]byte(`{"a":2}`), in),
+		"mode":     planToken([]byte(`{"a":1}`), planInputs{mode: "patch"}),
+		"force":    planToken([]byte(`{"a":1}`), planInputs{mode: "reconcile", force: true}),
+		"profile":  planToken([]byte(`{"a":1}`), planInputs{mode: "reconcile", profile: "prod"}),
+		"endpoint": planToken([]byte(`{"a":1}`), planInputs{mode: "reconcile", endpoint: "http://agent:1"}),
+	} {
+		if other == base {
+			t.Errorf("changing %s did not change the token", name)
+		}
+	}
+}
+
+func TestApplyForma_PlanTokenBoundToActiveProfile(t *testing.T) {
+	simulateOnly := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("simulate") != "true" {
+			t.Errorf("real apply reached %s", r.Host)
+		}
+		_, _ = fmt.Fprint(w, `{"Simulation":{"ChangesRequired":true}}`)
+	}
+	staging := mockAgent(t, map[string]http.HandlerFunc{"POST /api/v1/commands": simulateOnly})
+	defer staging.Close()
+	prod := mockAgent(t, map[string]http.HandlerFunc{"POST /api/v1/commands": simulateOnly})
+	defer prod.Close()
+
+	setActive := withAgentProfiles(t, map[string]string{"staging": staging.URL, "prod": prod.URL})
+
+	file := t.TempDir() + "/main.json"
+	if err := writeTestFile(file, `{"Stacks":[{"Label":"prod"}]}`); err != nil {
+		t.Fatal(err)
+	}
+	args := map[string]any{"file_path": file, "mode": "reconcile"}
+
+	setActive("staging")
+	session := connectTestServer(t, "")
+	token := simulateForToken(t, session, args)
+	setActive("prod")
+	result := applyWithToken(t, session, args, token)
+	if !result.IsError || !strings.Contains(textContent(t, result), `profile="staging"`) {
+		t.Fatalf("got:\n%s\nwant:\nthe apply refused because the active profile changed", textContent(t, result))
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
 	"github.com/platform-engineering-labs/formae-mcp/internal/featuregate"
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
 	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
 )
 
@@ -16,14 +17,20 @@ import (
 // depending on the formae binary. Production code uses formaeEval.
 var injectedEvalForTest EvalFunc
 
-func currentEvalFunc() EvalFunc {
+// currentEvalFunc returns the EvalFunc for a tool call searching ws:
+// formaeEval bound to ctx, so cancelling the call kills any running
+// `formae eval`, behind the eval cache.
+func currentEvalFunc(ctx context.Context, ws workspace) EvalFunc {
 	if injectedEvalForTest != nil {
 		return injectedEvalForTest
 	}
-	return formaeEval
+	run := func(path string) ([]byte, error) { return formaeEval(ctx, path) }
+	return func(path string) ([]byte, error) {
+		return evalResults.eval(ctx, path, ws.rootOf(path), run)
+	}
 }
 
-func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequest, input tools.CreateInlinePolicyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleCreateInlinePolicy(ctx context.Context, req *mcp.CallToolRequest, input tools.CreateInlinePolicyInput) (*mcp.CallToolResult, any, error) {
 	if err := validateCreateInlinePolicyInput(input); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -37,9 +44,9 @@ func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequ
 		}
 	}
 
-	cwd, err := os.Getwd()
+	ws, err := workspaceFor(ctx, req)
 	if err != nil {
-		return errorResult(fmt.Errorf("getwd: %w", err)), nil, nil
+		return errorResult(err), nil, nil
 	}
 
 	// A stack may hold only one policy per type. Setting an inline policy on a
@@ -49,9 +56,9 @@ func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequ
 	// conflict. An unreachable agent is not fatal; this tool's real work is
 	// local file planning, so a transport failure downgrades to "no standalone
 	// policies known from the agent" and the source check still runs.
-	var inventory []policyInventoryItem
+	var inventory []model.Policy
 	if input.Operation == "set" {
-		if items, err := s.fetchPolicies(); err == nil {
+		if items, err := s.fetchPolicies(ctx); err == nil {
 			inventory = items
 		}
 		for _, item := range inventory {
@@ -72,12 +79,14 @@ func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequ
 	}
 
 	filePath := input.FormaFile
+	var notes []string
 	if filePath == "" {
-		resolved, err := resolveStackFile(cwd, input.Stack, currentEvalFunc())
+		resolved, diags, err := resolveStackFile(ctx, ws, input.Stack, currentEvalFunc(ctx, ws))
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
 		filePath = resolved
+		notes = diags.notes(ws)
 	}
 
 	if err := checkPolicySchemaSupport(filePath); err != nil {
@@ -93,7 +102,7 @@ func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequ
 	// attached to this stack in source but not yet applied.
 	if input.Operation == "set" {
 		for _, lbl := range resolvableLabelsInPoliciesBlock(string(source), input.Stack) {
-			if t, ok := s.standaloneTypeOf(lbl, inventory, cwd); ok && t == input.PolicyType {
+			if t, ok := s.standaloneTypeOf(ctx, lbl, inventory, ws); ok && t == input.PolicyType {
 				return errorResult(fmt.Errorf(
 					"stack %q already has standalone policy %q of type %s attached in source; a stack cannot "+
 						"hold both an inline and a standalone policy of the same type. Detach %q first "+
@@ -116,7 +125,7 @@ func (s *Server) handleCreateInlinePolicy(_ context.Context, _ *mcp.CallToolRequ
 		InsertionAnchorEnd:    plan.InsertionAnchorEnd,
 		ExistingPolicySnippet: plan.ExistingPolicySnippet,
 		ImportsToAdd:          plan.ImportsToAdd,
-		Notes:                 plan.Notes,
+		Notes:                 append(plan.Notes, notes...),
 	}
 	body, err := json.Marshal(out)
 	if err != nil {
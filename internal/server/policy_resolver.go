@@ -1,12 +1,21 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 )
 
 // EvalFunc evaluates a single PKL file and returns its JSON output.
@@ -14,21 +23,34 @@ type EvalFunc func(path string) ([]byte, error)
 
 // stackNotFoundError indicates no PKL file declared the requested stack.
 type stackNotFoundError struct {
-	Stack string
+	Stack       string
+	Diagnostics evalDiagnostics
+	Workspace   workspace
 }
 
 func (e *stackNotFoundError) Error() string {
-	return fmt.Sprintf("no PKL file in workspace declares stack %q", e.Stack)
+	msg := fmt.Sprintf("no PKL file in workspace declares stack %q", e.Stack)
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += "; " + d
+	}
+	return msg
 }
 
-// stackAmbiguousError indicates multiple PKL files declared the same stack label.
+// stackAmbiguousError indicates multiple PKL files declared the same stack
+// label. Candidates are the first ambiguityLimit of them in walk order.
 type stackAmbiguousError struct {
-	Stack      string
-	Candidates []string
+	Stack       string
+	Candidates  []string
+	Diagnostics evalDiagnostics
+	Workspace   workspace
 }
 
 func (e *stackAmbiguousError) Error() string {
-	return fmt.Sprintf("multiple PKL files declare stack %q: %v", e.Stack, e.Candidates)
+	msg := fmt.Sprintf("multiple PKL files declare stack %q: %s", e.Stack, e.Workspace.describe(e.Candidates))
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += "; " + d
+	}
+	return msg
 }
 
 // skippedDirs are directories that walkPKLFiles never recurses into.
@@ -72,48 +94,195 @@ func walkPKLFiles(root string) ([]string, error) {
 // formaPredicate answers a yes/no question about an evaluated forma document.
 type formaPredicate func(formaJSON []byte) bool
 
-// resolveFormaFileBy walks the workspace, evaluates each PKL file, and returns
-// every file whose forma satisfies pred, in walk order. Files that fail to
-// evaluate are skipped silently — a workspace routinely contains PKL modules
-// that are not standalone formae (vars, templates, partial imports).
-func resolveFormaFileBy(root string, eval EvalFunc, pred formaPredicate) ([]string, error) {
-	files, err := walkPKLFiles(root)
-	if err != nil {
-		return nil, fmt.Errorf("walk workspace: %w", err)
+// evalDiagnostic is a workspace PKL file that failed to evaluate while the
+// resolver searched it.
+type evalDiagnostic struct {
+	File string
+	Err  error
+}
+
+// evalDiagnostics are the files a search could not look into, in walk order.
+// A workspace routinely holds PKL modules that are not standalone formae
+// (vars, templates, partial imports), so they are not errors in themselves;
+// the resolvers report them with every outcome — in the errors, or alongside
+// the file found — in case a file sought is among them.
+type evalDiagnostics []evalDiagnostic
+
+// describe renders the diagnostics as a sentence for an error message or a
+// tool note, or "" when there are none. Only the first line of each error (of formae's stderr,
+// for an eval it rejected) is kept.
+func (d evalDiagnostics) describe(ws workspace) string {
+	if len(d) == 0 {
+		return ""
 	}
-	var matches []string
-	for _, file := range files {
-		out, evalErr := eval(file)
-		if evalErr != nil {
-			continue
+	parts := make([]string, len(d))
+	for i, diag := range d {
+		msg := diag.Err.Error()
+		var failed *evalFailedError
+		if errors.As(diag.Err, &failed) && strings.TrimSpace(failed.Stderr) != "" {
+			msg = failed.Stderr
 		}
-		if pred(out) {
-			matches = append(matches, file)
+		msg, _, _ = strings.Cut(strings.TrimSpace(msg), "\n")
+		parts[i] = fmt.Sprintf("%s (%s)", ws.describe([]string{diag.File}), msg)
+	}
+	noun := "files"
+	if len(d) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d PKL %s could not be evaluated and were not searched: %s", len(d), noun, strings.Join(parts, "; "))
+}
+
+// notes returns the diagnostics as tool output notes: none, or the describe
+// sentence.
+func (d evalDiagnostics) notes(ws workspace) []string {
+	if len(d) == 0 {
+		return nil
+	}
+	return []string{d.describe(ws)}
+}
+
+// evalWorkers is how many PKL files the resolver evaluates at once: the
+// FORMAE_MCP_EVAL_WORKERS environment variable, or up to 4 on machines with
+// that many CPUs. Each evaluation is a formae subprocess.
+func evalWorkers() int {
+	if n, err := strconv.Atoi(os.Getenv("FORMAE_MCP_EVAL_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+	return min(runtime.GOMAXPROCS(0), 4)
+}
+
+// fileEval is a file whose evaluated forma evalFiles kept, with what its
+// callback derived from it.
+type fileEval[T any] struct {
+	File  string
+	Value T
+}
+
+// evalFiles evaluates files on a bounded pool of workers and returns, in walk
+// order, the files keep accepts with the value it derived from each, and the
+// files that failed to evaluate. With limit > 0 it stops after the first limit
+// accepted files in walk order: files after the limit-th are not started, and
+// results from those already running are dropped, so the outcome does not
+// depend on scheduling. When ctx ends no further files are started and the
+// search returns ctx's cause, since its outcome would be incomplete.
+func evalFiles[T any](ctx context.Context, files []string, eval EvalFunc, limit int, keep func(formaJSON []byte) (T, bool)) ([]fileEval[T], evalDiagnostics, error) {
+	type outcome struct {
+		value T
+		kept  bool
+		err   error
+	}
+	outcomes := make([]outcome, len(files))
+
+	var mu sync.Mutex
+	next := 0
+	// cutoff is one past the limit-th accepted file found so far; nothing
+	// from there on can be among the first limit.
+	cutoff := len(files)
+	var wg sync.WaitGroup
+	for range min(evalWorkers(), len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				if i >= cutoff || ctx.Err() != nil {
+					mu.Unlock()
+					return
+				}
+				next++
+				mu.Unlock()
+
+				var o outcome
+				out, err := eval(files[i])
+				if err != nil {
+					o.err = err
+				} else {
+					o.value, o.kept = keep(out)
+				}
+
+				mu.Lock()
+				outcomes[i] = o
+				if o.kept && limit > 0 && i < cutoff {
+					kept := 0
+					for j := 0; j <= i; j++ {
+						if outcomes[j].kept {
+							kept++
+						}
+					}
+					if kept >= limit {
+						cutoff = i + 1
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("workspace search stopped: %w", context.Cause(ctx))
+	}
+
+	var results []fileEval[T]
+	var diags evalDiagnostics
+	for i := range cutoff {
+		switch o := outcomes[i]; {
+		case o.err != nil:
+			diags = append(diags, evalDiagnostic{File: files[i], Err: o.err})
+		case o.kept && (limit <= 0 || len(results) < limit):
+			results = append(results, fileEval[T]{File: files[i], Value: o.value})
 		}
 	}
-	return matches, nil
+	return results, diags, nil
 }
 
-// resolveStackFile returns the single PKL file declaring the named stack.
-// Returns *stackNotFoundError if no file declares it, *stackAmbiguousError if
-// more than one does.
-func resolveStackFile(root, stackLabel string, eval EvalFunc) (string, error) {
-	matches, err := resolveFormaFileBy(root, eval, func(formaJSON []byte) bool {
-		return formaJSONHasStack(formaJSON, stackLabel)
+// resolveFormaFileBy walks the workspace, evaluates its PKL files in parallel,
+// and returns the files whose forma satisfies pred, in walk order, with the
+// files that failed to evaluate. limit > 0 stops the search at that many
+// matches.
+func resolveFormaFileBy(ctx context.Context, ws workspace, eval EvalFunc, pred formaPredicate, limit int) ([]string, evalDiagnostics, error) {
+	files, err := ws.pklFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	found, diags, err := evalFiles(ctx, files, eval, limit, func(formaJSON []byte) (struct{}, bool) {
+		return struct{}{}, pred(formaJSON)
 	})
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	matches := make([]string, len(found))
+	for i, f := range found {
+		matches[i] = f.File
+	}
+	return matches, diags, nil
+}
+
+// resolveStackFile returns the single PKL file declaring the named stack, with
+// the files that could not be evaluated on the way. Returns
+// *stackNotFoundError if no file declares it, *stackAmbiguousError if more
+// than one does.
+func resolveStackFile(ctx context.Context, ws workspace, stackLabel string, eval EvalFunc) (string, evalDiagnostics, error) {
+	matches, diags, err := resolveFormaFileBy(ctx, ws, eval, func(formaJSON []byte) bool {
+		return formaJSONHasStack(formaJSON, stackLabel)
+	}, ambiguityLimit)
+	if err != nil {
+		return "", nil, err
 	}
 	switch len(matches) {
 	case 0:
-		return "", &stackNotFoundError{Stack: stackLabel}
+		return "", nil, &stackNotFoundError{Stack: stackLabel, Diagnostics: diags, Workspace: ws}
 	case 1:
-		return matches[0], nil
+		return matches[0], diags, nil
 	default:
-		return "", &stackAmbiguousError{Stack: stackLabel, Candidates: matches}
+		return "", nil, &stackAmbiguousError{Stack: stackLabel, Candidates: matches, Diagnostics: diags, Workspace: ws}
 	}
 }
 
+// ambiguityLimit is how many matches the single-file resolvers search for:
+// a second match is enough to know the answer is ambiguous.
+const ambiguityLimit = 2
+
 // formaJSONHasStack returns true if the given forma JSON declares a stack with
 // the given label.
 func formaJSONHasStack(formaJSON []byte, label string) bool {
@@ -133,13 +302,25 @@ func formaJSONHasStack(formaJSON []byte, label string) bool {
 	return false
 }
 
-// formaeEval is the production EvalFunc — invokes `formae eval` on the file.
-func formaeEval(path string) ([]byte, error) {
-	cmd := exec.Command("formae", "eval", path, "--output-schema", "json", "--output-consumer", "machine")
+// formaeEval invokes `formae eval` on the file; the process is killed when ctx
+// ends. currentEvalFunc binds it to a tool call's context as the production
+// EvalFunc.
+func formaeEval(ctx context.Context, path string) ([]byte, error) {
+	args := []string{"eval", path, "--output-schema", "json", "--output-consumer", "machine"}
+	cmdCtx, span := telemetry.StartSubprocess(ctx, args...)
+	cmd := exec.CommandContext(cmdCtx, "formae", args...)
+	cmd.WaitDelay = subprocessWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	out, err := cmd.Output()
+	telemetry.End(cmdCtx, span, telemetry.ErrSubprocess, err)
+	logEvalStderr(ctx, path, stderr.Bytes())
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("formae eval failed for %s: %s", path, string(exitErr.Stderr))
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("formae eval stopped for %s: %w", path, context.Cause(ctx))
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, &evalFailedError{Path: path, Stderr: stderr.String()}
 		}
 		return nil, fmt.Errorf("formae eval failed for %s: %w", path, err)
 	}
@@ -150,23 +331,36 @@ func formaeEval(path string) ([]byte, error) {
 // named standalone policy. The agent may still know about it — the source is
 // what is missing.
 type policySourceNotFoundError struct {
-	Policy string
+	Policy      string
+	Diagnostics evalDiagnostics
+	Workspace   workspace
 }
 
 func (e *policySourceNotFoundError) Error() string {
-	return fmt.Sprintf("no PKL file in the workspace declares standalone policy %q "+
+	msg := fmt.Sprintf("no PKL file in the workspace declares standalone policy %q "+
 		"(the agent knows the policy, but its source declaration could not be located)", e.Policy)
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += "; " + d
+	}
+	return msg
 }
 
 // policySourceAmbiguousError indicates multiple PKL files declare the same
-// standalone policy label.
+// standalone policy label. Candidates are the first ambiguityLimit of them in
+// walk order.
 type policySourceAmbiguousError struct {
-	Policy     string
-	Candidates []string
+	Policy      string
+	Candidates  []string
+	Diagnostics evalDiagnostics
+	Workspace   workspace
 }
 
 func (e *policySourceAmbiguousError) Error() string {
-	return fmt.Sprintf("multiple PKL files declare standalone policy %q: %v", e.Policy, e.Candidates)
+	msg := fmt.Sprintf("multiple PKL files declare standalone policy %q: %s", e.Policy, e.Workspace.describe(e.Candidates))
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += "; " + d
+	}
+	return msg
 }
 
 // formaJSONHasPolicy reports whether the evaluated forma declares a standalone
@@ -191,42 +385,55 @@ func formaJSONHasPolicy(formaJSON []byte, label string) bool {
 
 // resolveStandalonePolicyFile returns the single PKL file declaring the named
 // standalone policy. Mirrors resolveStackFile over the Policies array.
-func resolveStandalonePolicyFile(root, policyLabel string, eval EvalFunc) (string, error) {
-	matches, err := resolveFormaFileBy(root, eval, func(formaJSON []byte) bool {
+func resolveStandalonePolicyFile(ctx context.Context, ws workspace, policyLabel string, eval EvalFunc) (string, evalDiagnostics, error) {
+	matches, diags, err := resolveFormaFileBy(ctx, ws, eval, func(formaJSON []byte) bool {
 		return formaJSONHasPolicy(formaJSON, policyLabel)
-	})
+	}, ambiguityLimit)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	switch len(matches) {
 	case 0:
-		return "", &policySourceNotFoundError{Policy: policyLabel}
+		return "", nil, &policySourceNotFoundError{Policy: policyLabel, Diagnostics: diags, Workspace: ws}
 	case 1:
-		return matches[0], nil
+		return matches[0], diags, nil
 	default:
-		return "", &policySourceAmbiguousError{Policy: policyLabel, Candidates: matches}
+		return "", nil, &policySourceAmbiguousError{Policy: policyLabel, Candidates: matches, Diagnostics: diags, Workspace: ws}
 	}
 }
 
 // mainFormaFileNotFoundError indicates no evaluable PKL file in the workspace
 // declares any stack, so there is nowhere sensible to put a standalone policy.
-type mainFormaFileNotFoundError struct{}
+type mainFormaFileNotFoundError struct {
+	Diagnostics evalDiagnostics
+	Workspace   workspace
+}
 
 func (e *mainFormaFileNotFoundError) Error() string {
-	return "no forma file with stacks found in the workspace; " +
+	msg := "no forma file with stacks found in the workspace; " +
 		"pass forma_file explicitly to say where the standalone policy should be declared"
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += ". " + d
+	}
+	return msg
 }
 
 // mainFormaFileAmbiguousError indicates several files tie for "most stacks".
 // The skill is expected to present the candidates and ask the user once.
 type mainFormaFileAmbiguousError struct {
-	Candidates []string
-	StackCount int
+	Candidates  []string
+	StackCount  int
+	Diagnostics evalDiagnostics
+	Workspace   workspace
 }
 
 func (e *mainFormaFileAmbiguousError) Error() string {
-	return fmt.Sprintf("cannot identify a single main forma file: %d files each declare %d stacks: %v. "+
-		"Pass forma_file explicitly to choose one", len(e.Candidates), e.StackCount, e.Candidates)
+	msg := fmt.Sprintf("cannot identify a single main forma file: %d files each declare %d stacks: %s. "+
+		"Pass forma_file explicitly to choose one", len(e.Candidates), e.StackCount, e.Workspace.describe(e.Candidates))
+	if d := e.Diagnostics.describe(e.Workspace); d != "" {
+		msg += ". " + d
+	}
+	return msg
 }
 
 // countStacksInFormaJSON returns the number of stacks an evaluated forma
@@ -244,40 +451,41 @@ func countStacksInFormaJSON(formaJSON []byte) int {
 }
 
 // resolveMainFormaFile picks the workspace's main forma file — the one
-// declaring the most stacks. Files that fail to evaluate or declare no stacks
-// are ignored. A tie for the top count is an ambiguity error, never a guess.
-func resolveMainFormaFile(root string, eval EvalFunc) (string, error) {
-	files, err := walkPKLFiles(root)
+// declaring the most stacks. Files that declare no stacks are ignored, and
+// those that fail to evaluate are returned with the outcome, since one of them
+// may be the real main file. A tie for the top count is an ambiguity error,
+// never a guess.
+func resolveMainFormaFile(ctx context.Context, ws workspace, eval EvalFunc) (string, evalDiagnostics, error) {
+	files, err := ws.pklFiles()
+	if err != nil {
+		return "", nil, err
+	}
+	withStacks, diags, err := evalFiles(ctx, files, eval, 0, func(formaJSON []byte) (int, bool) {
+		count := countStacksInFormaJSON(formaJSON)
+		return count, count > 0
+	})
 	if err != nil {
-		return "", fmt.Errorf("walk workspace: %w", err)
+		return "", nil, err
 	}
 
 	best := 0
 	var winners []string
-	for _, file := range files {
-		out, evalErr := eval(file)
-		if evalErr != nil {
-			continue
-		}
-		count := countStacksInFormaJSON(out)
-		if count == 0 {
-			continue
-		}
+	for _, f := range withStacks {
 		switch {
-		case count > best:
-			best = count
-			winners = []string{file}
-		case count == best:
-			winners = append(winners, file)
+		case f.Value > best:
+			best = f.Value
+			winners = []string{f.File}
+		case f.Value == best:
+			winners = append(winners, f.File)
 		}
 	}
 
 	switch {
 	case len(winners) == 0:
-		return "", &mainFormaFileNotFoundError{}
+		return "", nil, &mainFormaFileNotFoundError{Diagnostics: diags, Workspace: ws}
 	case len(winners) == 1:
-		return winners[0], nil
+		return winners[0], diags, nil
 	default:
-		return "", &mainFormaFileAmbiguousError{Candidates: winners, StackCount: best}
+		return "", nil, &mainFormaFileAmbiguousError{Candidates: winners, StackCount: best, Diagnostics: diags, Workspace: ws}
 	}
 }
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestWalkPKLFiles(t *testing.T) {
@@ -39,7 +43,7 @@ func TestResolveStackFileSingleMatch(t *testing.T) {
 		}
 		return []byte(`{"Stacks":[{"Label":"production"}]}`), nil
 	}
-	got, err := resolveStackFile(root, "lifeline", eval)
+	got, _, err := resolveStackFile(t.Context(), workspace{root}, "lifeline", eval)
 	if err != nil {
 		t.Fatalf("resolveStackFile failed: %v", err)
 	}
@@ -53,7 +57,7 @@ func TestResolveStackFileNotFound(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[{"Label":"other"}]}`), nil
 	}
-	_, err := resolveStackFile(root, "lifeline", eval)
+	_, _, err := resolveStackFile(t.Context(), workspace{root}, "lifeline", eval)
 	var nfErr *stackNotFoundError
 	if !errors.As(err, &nfErr) {
 		t.Fatalf("expected stackNotFoundError, got %T: %v", err, err)
@@ -65,7 +69,7 @@ func TestResolveStackFileMultipleMatches(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
 	}
-	_, err := resolveStackFile(root, "lifeline", eval)
+	_, _, err := resolveStackFile(t.Context(), workspace{root}, "lifeline", eval)
 	var ambErr *stackAmbiguousError
 	if !errors.As(err, &ambErr) {
 		t.Fatalf("expected stackAmbiguousError, got %T: %v", err, err)
@@ -83,13 +87,177 @@ func TestResolveStackFileEvalErrorsSkipped(t *testing.T) {
 		}
 		return nil, fmt.Errorf("malformed PKL")
 	}
-	got, err := resolveStackFile(root, "lifeline", eval)
+	got, diags, err := resolveStackFile(t.Context(), workspace{root}, "lifeline", eval)
 	if err != nil {
 		t.Fatalf("resolveStackFile failed: %v", err)
 	}
 	if !strings.HasSuffix(got, filepath.Join("nested", "sub.pkl")) {
 		t.Errorf("expected nested/sub.pkl, got %s", got)
 	}
+	if len(diags) != 1 || !strings.HasSuffix(diags[0].File, "main.pkl") {
+		t.Errorf("got:\n%v\nwant:\none diagnostic for main.pkl alongside the match", diags)
+	}
+}
+
+func TestResolveStackFileAmbiguousReportsEvalFailures(t *testing.T) {
+	ws, files := evalFixture(t, 3)
+	eval := func(path string) ([]byte, error) {
+		if path == files[1] {
+			return nil, fmt.Errorf("malformed PKL")
+		}
+		return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+	}
+	_, _, err := resolveStackFile(t.Context(), ws, "lifeline", eval)
+	var ambErr *stackAmbiguousError
+	if !errors.As(err, &ambErr) {
+		t.Fatalf("expected stackAmbiguousError, got %T: %v", err, err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "1 PKL file could not be evaluated") || !strings.Contains(msg, "f01.pkl (malformed PKL)") {
+		t.Errorf("got:\n%s\nwant:\nthe failing file alongside the candidates", msg)
+	}
+}
+
+func TestResolveStackFileStopsOnCancel(t *testing.T) {
+	t.Setenv("FORMAE_MCP_EVAL_WORKERS", "1")
+	ws, _ := evalFixture(t, 10)
+	ctx, cancel := context.WithCancel(t.Context())
+	var evaluated atomic.Int32
+	eval := func(path string) ([]byte, error) {
+		if evaluated.Add(1) == 2 {
+			cancel()
+		}
+		return nil, fmt.Errorf("formae eval stopped for %s: %w", path, context.Canceled)
+	}
+	_, _, err := resolveStackFile(ctx, ws, "lifeline", eval)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got:\n%T: %v\nwant:\nthe cancellation", err, err)
+	}
+	var nfErr *stackNotFoundError
+	if errors.As(err, &nfErr) {
+		t.Errorf("got:\n%v\nwant:\nno not-found error for a search that did not finish", err)
+	}
+	if n := evaluated.Load(); n != 2 {
+		t.Errorf("got:\n%d files evaluated\nwant:\n2, none started after the cancellation", n)
+	}
+}
+
+func TestResolveStackFileNotFoundReportsEvalFailures(t *testing.T) {
+	root := filepath.Join("..", "..", "testdata", "policy", "walker_fixture")
+	eval := func(path string) ([]byte, error) {
+		if strings.HasSuffix(path, "main.pkl") {
+			return nil, &evalFailedError{Path: path, Stderr: "–– Pkl Error ––\nCannot find module `vars.pkl`."}
+		}
+		return []byte(`{"Stacks":[{"Label":"other"}]}`), nil
+	}
+	_, _, err := resolveStackFile(t.Context(), workspace{root}, "lifeline", eval)
+	var nfErr *stackNotFoundError
+	if !errors.As(err, &nfErr) {
+		t.Fatalf("expected stackNotFoundError, got %T: %v", err, err)
+	}
+	if len(nfErr.Diagnostics) != 1 || !strings.HasSuffix(nfErr.Diagnostics[0].File, "main.pkl") {
+		t.Fatalf("got:\n%v\nwant:\none diagnostic for main.pkl", nfErr.Diagnostics)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "1 PKL file could not be evaluated") || !strings.Contains(msg, "main.pkl (–– Pkl Error ––)") {
+		t.Errorf("got:\n%s\nwant:\nthe failing file and the first line of its error", msg)
+	}
+}
+
+// evalFixture writes n PKL files to a temporary workspace, named so that walk
+// order is index order, and returns the workspace and the files.
+func evalFixture(t *testing.T, n int) (workspace, []string) {
+	t.Helper()
+	root := t.TempDir()
+	files := make([]string, n)
+	for i := range files {
+		files[i] = filepath.Join(root, fmt.Sprintf("f%02d.pkl", i))
+		if err := writeTestFile(files[i], ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workspace{root}, files
+}
+
+func TestResolveFormaFileByParallelKeepsWalkOrder(t *testing.T) {
+	t.Setenv("FORMAE_MCP_EVAL_WORKERS", "4")
+	ws, files := evalFixture(t, 16)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	eval := func(path string) ([]byte, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		// Later files finish first, so completion order is the reverse of
+		// walk order.
+		i := sort.SearchStrings(files, path)
+		time.Sleep(time.Duration(len(files)-i) * time.Millisecond)
+		switch {
+		case i%5 == 0:
+			return nil, fmt.Errorf("malformed PKL")
+		case i%2 == 1:
+			return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+		}
+		return []byte(`{"Stacks":[{"Label":"other"}]}`), nil
+	}
+
+	matches, diags, err := resolveFormaFileBy(t.Context(), ws, eval, func(formaJSON []byte) bool {
+		return formaJSONHasStack(formaJSON, "lifeline")
+	}, 0)
+	if err != nil {
+		t.Fatalf("resolveFormaFileBy failed: %v", err)
+	}
+	want := []string{files[1], files[3], files[7], files[9], files[11], files[13]}
+	if fmt.Sprint(matches) != fmt.Sprint(want) {
+		t.Errorf("got:\n%v\nwant:\n%v", matches, want)
+	}
+	var failed []string
+	for _, d := range diags {
+		failed = append(failed, d.File)
+	}
+	if wantFailed := []string{files[0], files[5], files[10], files[15]}; fmt.Sprint(failed) != fmt.Sprint(wantFailed) {
+		t.Errorf("got:\n%v\nwant:\n%v", failed, wantFailed)
+	}
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("got:\n%d evaluations at once\nwant:\nbetween 2 and 4", maxInFlight)
+	}
+}
+
+func TestResolveFormaFileByLimitStopsEarly(t *testing.T) {
+	t.Setenv("FORMAE_MCP_EVAL_WORKERS", "2")
+	ws, files := evalFixture(t, 40)
+
+	var evaluated atomic.Int32
+	eval := func(path string) ([]byte, error) {
+		evaluated.Add(1)
+		i := sort.SearchStrings(files, path)
+		if i == 0 {
+			// The first match arrives last; the result must still lead.
+			time.Sleep(20 * time.Millisecond)
+		}
+		if i == 0 || i == 3 || i == 4 || i == 30 {
+			return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+		}
+		return []byte(`{"Stacks":[]}`), nil
+	}
+
+	matches, _, err := resolveFormaFileBy(t.Context(), ws, eval, func(formaJSON []byte) bool {
+		return formaJSONHasStack(formaJSON, "lifeline")
+	}, 2)
+	if err != nil {
+		t.Fatalf("resolveFormaFileBy failed: %v", err)
+	}
+	if want := []string{files[0], files[3]}; fmt.Sprint(matches) != fmt.Sprint(want) {
+		t.Errorf("got:\n%v\nwant:\n%v", matches, want)
+	}
+	if n := evaluated.Load(); n >= 30 {
+		t.Errorf("got:\n%d files evaluated\nwant:\nthe search to stop well before the last match", n)
+	}
 }
 
 func TestResolveStandalonePolicyFileSingleMatch(t *testing.T) {
@@ -100,7 +268,7 @@ func TestResolveStandalonePolicyFileSingleMatch(t *testing.T) {
 		}
 		return []byte(`{"Stacks":[{"Label":"production"}],"Policies":[]}`), nil
 	}
-	got, err := resolveStandalonePolicyFile(root, "ephemeral-1h", eval)
+	got, _, err := resolveStandalonePolicyFile(t.Context(), workspace{root}, "ephemeral-1h", eval)
 	if err != nil {
 		t.Fatalf("resolveStandalonePolicyFile failed: %v", err)
 	}
@@ -114,7 +282,7 @@ func TestResolveStandalonePolicyFileNotFound(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[],"Policies":[{"Label":"other","Type":"ttl"}]}`), nil
 	}
-	_, err := resolveStandalonePolicyFile(root, "ephemeral-1h", eval)
+	_, _, err := resolveStandalonePolicyFile(t.Context(), workspace{root}, "ephemeral-1h", eval)
 	var nfErr *policySourceNotFoundError
 	if !errors.As(err, &nfErr) {
 		t.Fatalf("got:\n%T (%v)\nwant:\n*policySourceNotFoundError", err, err)
@@ -126,7 +294,7 @@ func TestResolveStandalonePolicyFileAmbiguous(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[],"Policies":[{"Label":"ephemeral-1h","Type":"ttl"}]}`), nil
 	}
-	_, err := resolveStandalonePolicyFile(root, "ephemeral-1h", eval)
+	_, _, err := resolveStandalonePolicyFile(t.Context(), workspace{root}, "ephemeral-1h", eval)
 	var ambErr *policySourceAmbiguousError
 	if !errors.As(err, &ambErr) {
 		t.Fatalf("got:\n%T (%v)\nwant:\n*policySourceAmbiguousError", err, err)
@@ -152,7 +320,7 @@ func TestResolveMainFormaFilePicksMostStacks(t *testing.T) {
 		}
 		return []byte(`{"Stacks":[{"Label":"d"}]}`), nil
 	}
-	got, err := resolveMainFormaFile(root, eval)
+	got, _, err := resolveMainFormaFile(t.Context(), workspace{root}, eval)
 	if err != nil {
 		t.Fatalf("resolveMainFormaFile failed: %v", err)
 	}
@@ -166,7 +334,7 @@ func TestResolveMainFormaFileTieIsAmbiguous(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[{"Label":"a"},{"Label":"b"}]}`), nil
 	}
-	_, err := resolveMainFormaFile(root, eval)
+	_, _, err := resolveMainFormaFile(t.Context(), workspace{root}, eval)
 	var ambErr *mainFormaFileAmbiguousError
 	if !errors.As(err, &ambErr) {
 		t.Fatalf("got:\n%T (%v)\nwant:\n*mainFormaFileAmbiguousError", err, err)
@@ -184,7 +352,7 @@ func TestResolveMainFormaFileNoStacksAnywhere(t *testing.T) {
 	eval := func(path string) ([]byte, error) {
 		return []byte(`{"Stacks":[]}`), nil
 	}
-	_, err := resolveMainFormaFile(root, eval)
+	_, _, err := resolveMainFormaFile(t.Context(), workspace{root}, eval)
 	var nfErr *mainFormaFileNotFoundError
 	if !errors.As(err, &nfErr) {
 		t.Fatalf("got:\n%T (%v)\nwant:\n*mainFormaFileNotFoundError", err, err)
@@ -199,7 +367,7 @@ func TestResolveMainFormaFileSkipsUnevaluableFiles(t *testing.T) {
 		}
 		return nil, fmt.Errorf("malformed PKL")
 	}
-	got, err := resolveMainFormaFile(root, eval)
+	got, _, err := resolveMainFormaFile(t.Context(), workspace{root}, eval)
 	if err != nil {
 		t.Fatalf("resolveMainFormaFile failed: %v", err)
 	}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -227,26 +228,27 @@ func planDeleteStandalonePolicy(source string, spec StandalonePolicySpec) (Stand
 // know the policy yet — a declaration written to source but not yet applied is
 // legitimately absent from the agent inventory.
 //
-// Returns found=false when no file declares it. Propagates the ambiguity error
-// when several files do, since that is a real problem the user must resolve.
-func standalonePolicyTypeFromWorkspace(root, label string, eval EvalFunc) (string, bool, error) {
-	path, err := resolveStandalonePolicyFile(root, label, eval)
+// Returns found=false when no file declares it, and either way the files that
+// could not be evaluated on the way. Propagates the ambiguity error when
+// several files do, since that is a real problem the user must resolve.
+func standalonePolicyTypeFromWorkspace(ctx context.Context, ws workspace, label string, eval EvalFunc) (string, bool, evalDiagnostics, error) {
+	path, diags, err := resolveStandalonePolicyFile(ctx, ws, label, eval)
 	if err != nil {
 		var notFound *policySourceNotFoundError
 		if errors.As(err, &notFound) {
-			return "", false, nil
+			return "", false, notFound.Diagnostics, nil
 		}
-		return "", false, err
+		return "", false, nil, err
 	}
 	source, err := os.ReadFile(path)
 	if err != nil {
-		return "", false, fmt.Errorf("read %s: %w", path, err)
+		return "", false, nil, fmt.Errorf("read %s: %w", path, err)
 	}
 	decl, ok := findStandalonePolicyDeclaration(string(source), label)
 	if !ok || decl.PolicyType == "" {
-		return "", false, nil
+		return "", false, diags, nil
 	}
-	return decl.PolicyType, true, nil
+	return decl.PolicyType, true, diags, nil
 }
 
 // standalonePolicyReferencesInSource returns the workspace PKL files that
@@ -255,10 +257,10 @@ func standalonePolicyTypeFromWorkspace(root, label string, eval EvalFunc) (strin
 // would leave a dangling reference that fails or recreates inconsistent state
 // on the next apply — even when the agent reports the policy as unattached
 // because the attachment has not been applied yet.
-func standalonePolicyReferencesInSource(root, label string) ([]string, error) {
-	files, err := walkPKLFiles(root)
+func standalonePolicyReferencesInSource(ws workspace, label string) ([]string, error) {
+	files, err := ws.pklFiles()
 	if err != nil {
-		return nil, fmt.Errorf("walk workspace: %w", err)
+		return nil, err
 	}
 	var referencing []string
 	for _, file := range files {
@@ -10,6 +10,7 @@ import (
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
 	"github.com/platform-engineering-labs/formae-mcp/internal/featuregate"
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
 	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
 )
 
@@ -30,17 +31,6 @@ func guardStandalonePolicy(policyType string) error {
 	return nil
 }
 
-// policyInventoryItem mirrors apimodel.PolicyInventoryItem from the formae
-// agent (GET /api/v1/policies). Config is the marshalled domain policy:
-// TTL     -> {"Type":"ttl","Label":"...","TTLSeconds":3600,"OnDependents":"abort"}
-// AutoRec -> {"Type":"auto-reconcile","Label":"...","IntervalSeconds":300}
-type policyInventoryItem struct {
-	Label          string          `json:"Label"`
-	Type           string          `json:"Type"`
-	Config         json.RawMessage `json:"Config"`
-	AttachedStacks []string        `json:"AttachedStacks"`
-}
-
 // policyConfig is the union of both policy configs, for reading Config.
 type policyConfig struct {
 	TTLSeconds      int64  `json:"TTLSeconds"`
@@ -62,19 +52,15 @@ func mcpPolicyType(agentType string) string {
 // fetchPolicies reads the agent's standalone policy inventory from the
 // active/default profile's agent (empty profile = active/default, matching the
 // server's per-call client resolution).
-func (s *Server) fetchPolicies() ([]policyInventoryItem, error) {
-	c, err := s.clientFor("")
+func (s *Server) fetchPolicies(ctx context.Context) ([]model.Policy, error) {
+	c, err := s.clientFor(ctx, "")
 	if err != nil {
 		return nil, err
 	}
-	body, err := c.ListPolicies()
+	items, err := c.ListPolicies(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("list policies from agent: %w", err)
 	}
-	var items []policyInventoryItem
-	if err := json.Unmarshal(body, &items); err != nil {
-		return nil, fmt.Errorf("parse policy inventory: %w", err)
-	}
 	return items, nil
 }
 
@@ -82,11 +68,11 @@ func (s *Server) fetchPolicies() ([]policyInventoryItem, error) {
 // consulting the agent inventory first and falling back to the workspace source
 // (for policies declared but not yet applied). Returns ok=false when the label
 // resolves nowhere.
-func (s *Server) standaloneTypeOf(label string, items []policyInventoryItem, cwd string) (string, bool) {
+func (s *Server) standaloneTypeOf(ctx context.Context, label string, items []model.Policy, ws workspace) (string, bool) {
 	if item, known := findPolicyByLabel(items, label); known {
 		return mcpPolicyType(item.Type), true
 	}
-	t, found, err := standalonePolicyTypeFromWorkspace(cwd, label, currentEvalFunc())
+	t, found, _, err := standalonePolicyTypeFromWorkspace(ctx, ws, label, currentEvalFunc(ctx, ws))
 	if err != nil || !found {
 		return "", false
 	}
@@ -94,17 +80,17 @@ func (s *Server) standaloneTypeOf(label string, items []policyInventoryItem, cwd
 }
 
 // findPolicyByLabel returns the inventory entry for a label.
-func findPolicyByLabel(items []policyInventoryItem, label string) (policyInventoryItem, bool) {
+func findPolicyByLabel(items []model.Policy, label string) (model.Policy, bool) {
 	for _, item := range items {
 		if item.Label == label {
 			return item, true
 		}
 	}
-	return policyInventoryItem{}, false
+	return model.Policy{}, false
 }
 
 // policyLabelsOf renders the known labels for an error message.
-func policyLabelsOf(items []policyInventoryItem) []string {
+func policyLabelsOf(items []model.Policy) []string {
 	labels := make([]string, 0, len(items))
 	for _, item := range items {
 		labels = append(labels, item.Label)
@@ -135,7 +121,7 @@ func validateStandalonePolicyFields(label, policyType string, ttlSeconds int64,
 	return nil
 }
 
-func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallToolRequest, input tools.CreateStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleCreateStandalonePolicy(ctx context.Context, req *mcp.CallToolRequest, input tools.CreateStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
 	if err := validateStandalonePolicyFields(input.Label, input.PolicyType, input.TTLSeconds, input.OnDependents, input.IntervalSeconds); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -143,16 +129,16 @@ func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		return errorResult(err), nil, nil
 	}
 
-	cwd, err := os.Getwd()
+	ws, err := workspaceFor(ctx, req)
 	if err != nil {
-		return errorResult(fmt.Errorf("getwd: %w", err)), nil, nil
+		return errorResult(err), nil, nil
 	}
 
 	// A label must be unique across the whole project, and against deployed
 	// state. The agent inventory is authoritative for what already exists, so
 	// check it first: a policy the agent already knows must not be re-declared,
 	// even if the current workspace source does not (yet) contain it.
-	if agentItems, err := s.fetchPolicies(); err == nil {
+	if agentItems, err := s.fetchPolicies(ctx); err == nil {
 		if _, known := findPolicyByLabel(agentItems, input.Label); known {
 			out := tools.CreateStandalonePolicyOutput{
 				Operation: "noop",
@@ -174,14 +160,15 @@ func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	// sharing one is an invalid project state. Check the whole workspace before
 	// planning, since the declaration may live in a file other than the one we
 	// are about to edit.
-	if existing, err := resolveStandalonePolicyFile(cwd, input.Label, currentEvalFunc()); err == nil {
+	var notes []string
+	if existing, diags, err := resolveStandalonePolicyFile(ctx, ws, input.Label, currentEvalFunc(ctx, ws)); err == nil {
 		out := tools.CreateStandalonePolicyOutput{
 			FilePath:  existing,
 			Operation: "noop",
-			Notes: []string{fmt.Sprintf(
+			Notes: append([]string{fmt.Sprintf(
 				"a standalone policy labelled %q is already declared in %s; labels must be unique across "+
 					"the project. Updating a standalone in place is not supported — delete it and recreate it",
-				input.Label, existing)},
+				input.Label, existing)}, diags.notes(ws)...),
 		}
 		body, err := json.Marshal(out)
 		if err != nil {
@@ -189,19 +176,20 @@ func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		}
 		return jsonResult(body), nil, nil
 	} else {
-		var ambiguous *policySourceAmbiguousError
-		if errors.As(err, &ambiguous) {
+		var notFound *policySourceNotFoundError
+		if !errors.As(err, &notFound) {
 			return errorResult(err), nil, nil
 		}
 	}
 
 	filePath := input.FormaFile
 	if filePath == "" {
-		resolved, err := resolveMainFormaFile(cwd, currentEvalFunc())
+		resolved, diags, err := resolveMainFormaFile(ctx, ws, currentEvalFunc(ctx, ws))
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
 		filePath = resolved
+		notes = diags.notes(ws)
 	}
 
 	if err := checkPolicySchemaSupport(filePath); err != nil {
@@ -231,7 +219,7 @@ func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		InsertionAnchorStart: plan.AnchorStart,
 		InsertionAnchorEnd:   plan.AnchorEnd,
 		ImportsToAdd:         plan.ImportsToAdd,
-		Notes:                plan.Notes,
+		Notes:                append(plan.Notes, notes...),
 	}
 	body, err := json.Marshal(out)
 	if err != nil {
@@ -240,7 +228,7 @@ func (s *Server) handleCreateStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	return jsonResult(body), nil, nil
 }
 
-func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallToolRequest, input tools.AttachStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleAttachStandalonePolicy(ctx context.Context, req *mcp.CallToolRequest, input tools.AttachStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
 	if input.Stack == "" {
 		return errorResult(fmt.Errorf("stack is required")), nil, nil
 	}
@@ -251,9 +239,9 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		return errorResult(err), nil, nil
 	}
 
-	cwd, err := os.Getwd()
+	ws, err := workspaceFor(ctx, req)
 	if err != nil {
-		return errorResult(fmt.Errorf("getwd: %w", err)), nil, nil
+		return errorResult(err), nil, nil
 	}
 
 	// Pre-check 1: identify the policy and its type. The agent inventory is
@@ -262,7 +250,7 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	// before the first apply. Fall back to the workspace source in that case
 	// rather than refusing a documented flow.
 	var notes []string
-	items, fetchErr := s.fetchPolicies()
+	items, fetchErr := s.fetchPolicies(ctx)
 	if fetchErr != nil {
 		items = nil
 	}
@@ -270,16 +258,21 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	if item, known := findPolicyByLabel(items, input.PolicyLabel); known {
 		policyType = mcpPolicyType(item.Type)
 	} else {
-		declType, found, err := standalonePolicyTypeFromWorkspace(cwd, input.PolicyLabel, currentEvalFunc())
+		declType, found, diags, err := standalonePolicyTypeFromWorkspace(ctx, ws, input.PolicyLabel, currentEvalFunc(ctx, ws))
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
 		if !found {
-			return errorResult(fmt.Errorf(
+			msg := fmt.Sprintf(
 				"no standalone policy labelled %q is known to the agent or declared anywhere in the "+
 					"workspace; known to the agent: %v. Declare it first with create_standalone_policy",
-				input.PolicyLabel, policyLabelsOf(items))), nil, nil
+				input.PolicyLabel, policyLabelsOf(items))
+			if d := diags.describe(ws); d != "" {
+				msg += ". " + d
+			}
+			return errorResult(errors.New(msg)), nil, nil
 		}
+		notes = append(notes, diags.notes(ws)...)
 		policyType = declType
 		notes = append(notes, fmt.Sprintf(
 			"standalone policy %q is declared in source but not yet applied — the agent does not know it. "+
@@ -304,11 +297,12 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 
 	filePath := input.FormaFile
 	if filePath == "" {
-		resolved, err := resolveStackFile(cwd, input.Stack, currentEvalFunc())
+		resolved, diags, err := resolveStackFile(ctx, ws, input.Stack, currentEvalFunc(ctx, ws))
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
 		filePath = resolved
+		notes = append(notes, diags.notes(ws)...)
 	}
 
 	if err := checkPolicySchemaSupport(filePath); err != nil {
@@ -335,7 +329,7 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 			if lbl == input.PolicyLabel {
 				continue
 			}
-			if t, ok := s.standaloneTypeOf(lbl, items, cwd); ok && t == policyType {
+			if t, ok := s.standaloneTypeOf(ctx, lbl, items, ws); ok && t == policyType {
 				return errorResult(fmt.Errorf(
 					"stack %q already has standalone policy %q of type %s attached in source; a stack may "+
 						"hold only one policy per type. Detach %q first (detach_standalone_policy)",
@@ -360,7 +354,7 @@ func (s *Server) handleAttachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	return jsonResult(body), nil, nil
 }
 
-func (s *Server) handleDetachStandalonePolicy(_ context.Context, _ *mcp.CallToolRequest, input tools.DetachStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleDetachStandalonePolicy(ctx context.Context, req *mcp.CallToolRequest, input tools.DetachStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
 	if input.Stack == "" {
 		return errorResult(fmt.Errorf("stack is required")), nil, nil
 	}
@@ -372,16 +366,18 @@ func (s *Server) handleDetachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	}
 
 	filePath := input.FormaFile
+	var notes []string
 	if filePath == "" {
-		cwd, err := os.Getwd()
+		ws, err := workspaceFor(ctx, req)
 		if err != nil {
-			return errorResult(fmt.Errorf("getwd: %w", err)), nil, nil
+			return errorResult(err), nil, nil
 		}
-		resolved, err := resolveStackFile(cwd, input.Stack, currentEvalFunc())
+		resolved, diags, err := resolveStackFile(ctx, ws, input.Stack, currentEvalFunc(ctx, ws))
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
 		filePath = resolved
+		notes = diags.notes(ws)
 	}
 
 	source, err := os.ReadFile(filePath)
@@ -400,7 +396,7 @@ func (s *Server) handleDetachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		SourceAnchorStart:         plan.AnchorStart,
 		SourceAnchorEnd:           plan.AnchorEnd,
 		ExistingResolvableSnippet: plan.ExistingSnippet,
-		Notes:                     plan.Notes,
+		Notes:                     append(plan.Notes, notes...),
 	}
 	body, err := json.Marshal(out)
 	if err != nil {
@@ -412,7 +408,7 @@ func (s *Server) handleDetachStandalonePolicy(_ context.Context, _ *mcp.CallTool
 // specFromInventoryItem converts an agent inventory entry into the spec used to
 // render PKL. Reading the config back from the agent (rather than the source)
 // means the destroy forma matches what is actually deployed.
-func specFromInventoryItem(item policyInventoryItem) (StandalonePolicySpec, error) {
+func specFromInventoryItem(item model.Policy) (StandalonePolicySpec, error) {
 	var cfg policyConfig
 	if len(item.Config) > 0 {
 		if err := json.Unmarshal(item.Config, &cfg); err != nil {
@@ -428,7 +424,7 @@ func specFromInventoryItem(item policyInventoryItem) (StandalonePolicySpec, erro
 	}, nil
 }
 
-func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallToolRequest, input tools.DeleteStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleDeleteStandalonePolicy(ctx context.Context, req *mcp.CallToolRequest, input tools.DeleteStandalonePolicyInput) (*mcp.CallToolResult, any, error) {
 	if input.Label == "" {
 		return errorResult(fmt.Errorf("label is required")), nil, nil
 	}
@@ -436,7 +432,7 @@ func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		return errorResult(err), nil, nil
 	}
 
-	inventory, err := s.fetchPolicies()
+	inventory, err := s.fetchPolicies(ctx)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -461,9 +457,9 @@ func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		return errorResult(err), nil, nil
 	}
 
-	cwd, err := os.Getwd()
+	ws, err := workspaceFor(ctx, req)
 	if err != nil {
-		return errorResult(fmt.Errorf("getwd: %w", err)), nil, nil
+		return errorResult(err), nil, nil
 	}
 
 	// The agent's AttachedStacks only reflects APPLIED attachments. A stack may
@@ -471,7 +467,7 @@ func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallTool
 	// the agent reports it unattached. Deleting the declaration then leaves a
 	// dangling PolicyResolvable/.res that breaks the next apply. Scan the source
 	// for references and refuse if any remain.
-	refs, err := standalonePolicyReferencesInSource(cwd, input.Label)
+	refs, err := standalonePolicyReferencesInSource(ws, input.Label)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -483,7 +479,7 @@ func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallTool
 			input.Label, len(refs), refs)), nil, nil
 	}
 
-	filePath, err := resolveStandalonePolicyFile(cwd, input.Label, currentEvalFunc())
+	filePath, diags, err := resolveStandalonePolicyFile(ctx, ws, input.Label, currentEvalFunc(ctx, ws))
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -505,7 +501,7 @@ func (s *Server) handleDeleteStandalonePolicy(_ context.Context, _ *mcp.CallTool
 		SourceAnchorEnd:       plan.AnchorEnd,
 		ExistingPolicySnippet: plan.ExistingSnippet,
 		DestroyFormaPKL:       renderDestroyFormaPKL(spec),
-		Notes:                 plan.Notes,
+		Notes:                 append(plan.Notes, diags.notes(ws)...),
 	}
 	body, err := json.Marshal(out)
 	if err != nil {
@@ -14,8 +14,8 @@ import (
 )
 
 // withFixtureWorkspace cd's into the fixture root for the duration of the test
-// and restores cwd afterward. The create_inline_policy tool resolves the
-// workspace from the MCP server's CWD.
+// and restores cwd afterward. The test client declares no roots, so the
+// create_inline_policy tool falls back to the MCP server's CWD.
 func withFixtureWorkspace(t *testing.T, fixture string) {
 	t.Helper()
 	orig, err := os.Getwd()
@@ -89,6 +89,59 @@ func TestCreateInlinePolicySetTTL(t *testing.T) {
 	}
 }
 
+func TestCreateInlinePolicyNotesEvalFailures(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	root := t.TempDir()
+	source, err := os.ReadFile(filepath.Join("..", "..", "testdata", "policy", "lifeline_fixture", "main.pkl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(root, "main.pkl"), string(source)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTestFile(filepath.Join(root, "vars.pkl"), ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(root)
+
+	prevEval := injectedEvalForTest
+	injectedEvalForTest = func(path string) ([]byte, error) {
+		if filepath.Base(path) == "main.pkl" {
+			return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+		}
+		return nil, fmt.Errorf("malformed PKL")
+	}
+	t.Cleanup(func() { injectedEvalForTest = prevEval })
+
+	session := connectTestServer(t, "http://localhost:1")
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name: "create_inline_policy",
+		Arguments: map[string]any{
+			"stack":         "lifeline",
+			"policy_type":   "ttl",
+			"operation":     "set",
+			"ttl_seconds":   1200,
+			"on_dependents": "abort",
+		},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	var out struct {
+		Notes []string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if !strings.Contains(strings.Join(out.Notes, "\n"), "could not be evaluated and were not searched") {
+		t.Errorf("got:\n%v\nwant:\na note naming the files that failed to evaluate", out.Notes)
+	}
+}
+
 func TestCreateInlinePolicyStackNotFound(t *testing.T) {
 	withFakeVersion(t, "0.88.0")
 	withFixtureWorkspace(t, "lifeline_fixture")
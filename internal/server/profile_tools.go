@@ -11,8 +11,10 @@ import (
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
+	"github.com/platform-engineering-labs/formae-mcp/internal/config"
 	"github.com/platform-engineering-labs/formae-mcp/internal/featuregate"
 	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
 )
 
@@ -22,10 +24,17 @@ var profileMu sync.Mutex
 
 // runFormaeProfile shells out to `formae profile <args...>` and returns combined
 // output. okExit lists non-zero exit codes that are NOT errors (e.g. diff's 1).
-func runFormaeProfile(args []string, okExit ...int) (string, error) {
-	cmd := exec.Command("formae", append([]string{"profile"}, args...)...)
+func runFormaeProfile(ctx context.Context, args []string, okExit ...int) (_ string, err error) {
+	argv := append([]string{"profile"}, args...)
+	cmdCtx, span := telemetry.StartSubprocess(ctx, argv...)
+	defer func() { telemetry.End(cmdCtx, span, telemetry.ErrSubprocess, err) }()
+	cmd := exec.CommandContext(cmdCtx, "formae", argv...)
+	cmd.WaitDelay = subprocessWaitDelay
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return string(out), fmt.Errorf("formae profile %v stopped: %w", args, context.Cause(ctx))
+		}
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			for _, code := range okExit {
 				if exitErr.ExitCode() == code {
@@ -38,29 +47,32 @@ func runFormaeProfile(args []string, okExit ...int) (string, error) {
 	return string(out), nil
 }
 
-func (s *Server) handleListProfiles(_ context.Context, _ *mcp.CallToolRequest, _ tools.EmptyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleListProfiles(ctx context.Context, _ *mcp.CallToolRequest, _ tools.EmptyInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
-	out, err := runFormaeProfile([]string{"list", "--output-consumer", "machine", "--output-schema", "json"})
+	out, err := runFormaeProfile(ctx, []string{"list", "--output-consumer", "machine", "--output-schema", "json"})
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(out), nil, nil
 }
 
-func (s *Server) handleCurrentProfile(_ context.Context, _ *mcp.CallToolRequest, _ tools.EmptyInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleCurrentProfile(ctx context.Context, _ *mcp.CallToolRequest, _ tools.EmptyInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
-	out, err := runFormaeProfile([]string{"current", "--output-consumer", "machine", "--output-schema", "json"})
+	out, err := runFormaeProfile(ctx, []string{"current", "--output-consumer", "machine", "--output-schema", "json"})
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
+	if name := s.selectedProfile(); name != "" {
+		out = fmt.Sprintf("This session uses profile %q (selected with use_profile). The active profile of the formae CLI and other sessions:\n%s", name, out)
+	}
 	return textResult(out), nil, nil
 }
 
-func (s *Server) handleReadProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.ReadProfileInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleReadProfile(ctx context.Context, _ *mcp.CallToolRequest, input tools.ReadProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -72,26 +84,60 @@ func (s *Server) handleReadProfile(_ context.Context, _ *mcp.CallToolRequest, in
 	if err != nil {
 		return errorResult(fmt.Errorf("profile %q not found: %w", input.Name, err)), nil, nil
 	}
-	return textResult(string(data)), nil, nil
+	return textResult(config.RedactTokens(string(data))), nil, nil
 }
 
-func (s *Server) handleUseProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.UseProfileInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleUseProfile(ctx context.Context, req *mcp.CallToolRequest, input tools.UseProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
 	if err := profile.ValidateName(input.Name); err != nil {
 		return errorResult(err), nil, nil
 	}
+	if s.perSession {
+		return s.selectSessionProfile(ctx, req, input.Name)
+	}
+	question := fmt.Sprintf("Make %q the active profile? It is shared with the formae CLI and every other session.", input.Name)
+	if active, err := profile.ActiveProfile(); err == nil {
+		question = fmt.Sprintf("Switch the active profile from %q to %q? It is shared with the formae CLI and every other session.", active, input.Name)
+	}
+	if err := confirmWithUser(ctx, req, question); err != nil {
+		return errorResult(err), nil, nil
+	}
 	profileMu.Lock()
 	defer profileMu.Unlock()
-	out, err := runFormaeProfile([]string{"use", input.Name})
+	out, err := runFormaeProfile(ctx, []string{"use", input.Name})
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(fmt.Sprintf("Switched active profile to %q.\n%s", input.Name, out)), nil, nil
 }
 
-func (s *Server) handleSaveProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.SaveProfileInput) (*mcp.CallToolResult, any, error) {
+// selectSessionProfile is use_profile on an HTTP session: the session's
+// empty-profile calls move to name, while the active profile, which the CLI
+// and the other sessions follow, stays put.
+func (s *Server) selectSessionProfile(ctx context.Context, req *mcp.CallToolRequest, name string) (*mcp.CallToolResult, any, error) {
+	path, err := profile.ProfilePath(name)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return errorResult(fmt.Errorf("profile %q not found: %w", name, err)), nil, nil
+	}
+	question := fmt.Sprintf("Use profile %q for this session? The formae CLI and other sessions keep the active profile.", name)
+	if current, err := s.activeProfile(); err == nil {
+		question = fmt.Sprintf("Switch this session from profile %q to %q? The formae CLI and other sessions keep the active profile.", current, name)
+	}
+	if err := confirmWithUser(ctx, req, question); err != nil {
+		return errorResult(err), nil, nil
+	}
+	s.selectedMu.Lock()
+	s.selected = name
+	s.selectedMu.Unlock()
+	return textResult(fmt.Sprintf("This session now uses profile %q. The active profile of the formae CLI and other sessions is unchanged.", name)), nil, nil
+}
+
+func (s *Server) handleSaveProfile(ctx context.Context, _ *mcp.CallToolRequest, input tools.SaveProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -102,14 +148,14 @@ func (s *Server) handleSaveProfile(_ context.Context, _ *mcp.CallToolRequest, in
 	if input.Force {
 		args = append(args, "--force")
 	}
-	out, err := runFormaeProfile(args)
+	out, err := runFormaeProfile(ctx, args)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(out), nil, nil
 }
 
-func (s *Server) handleCreateProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.CreateProfileInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleCreateProfile(ctx context.Context, _ *mcp.CallToolRequest, input tools.CreateProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -120,28 +166,31 @@ func (s *Server) handleCreateProfile(_ context.Context, _ *mcp.CallToolRequest,
 	if input.Force {
 		args = append(args, "--force")
 	}
-	out, err := runFormaeProfile(args)
+	out, err := runFormaeProfile(ctx, args)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(out), nil, nil
 }
 
-func (s *Server) handleDeleteProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.DeleteProfileInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleDeleteProfile(ctx context.Context, req *mcp.CallToolRequest, input tools.DeleteProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
 	if err := profile.ValidateName(input.Name); err != nil {
 		return errorResult(err), nil, nil
 	}
-	out, err := runFormaeProfile([]string{"delete", input.Name})
+	if err := confirmWithUser(ctx, req, fmt.Sprintf("Delete profile %q?", input.Name)); err != nil {
+		return errorResult(err), nil, nil
+	}
+	out, err := runFormaeProfile(ctx, []string{"delete", input.Name})
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(out), nil, nil
 }
 
-func (s *Server) handleDiffProfiles(_ context.Context, _ *mcp.CallToolRequest, input tools.DiffProfilesInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleDiffProfiles(ctx context.Context, _ *mcp.CallToolRequest, input tools.DiffProfilesInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -156,14 +205,14 @@ func (s *Server) handleDiffProfiles(_ context.Context, _ *mcp.CallToolRequest, i
 		args = append(args, input.B)
 	}
 	// exit code 1 means "files differ", which is success for diff.
-	out, err := runFormaeProfile(args, 1)
+	out, err := runFormaeProfile(ctx, args, 1)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return textResult(out), nil, nil
+	return textResult(config.RedactTokens(out)), nil, nil
 }
 
-func (s *Server) handleWriteProfile(_ context.Context, _ *mcp.CallToolRequest, input tools.WriteProfileInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleWriteProfile(ctx context.Context, req *mcp.CallToolRequest, input tools.WriteProfileInput) (*mcp.CallToolResult, any, error) {
 	if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -171,8 +220,16 @@ func (s *Server) handleWriteProfile(_ context.Context, _ *mcp.CallToolRequest, i
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	if _, statErr := os.Stat(path); statErr != nil {
-		return errorResult(fmt.Errorf("profile %q does not exist (use create_profile to create it): %w", input.Name, statErr)), nil, nil
+	current, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return errorResult(fmt.Errorf("profile %q does not exist (use create_profile to create it): %w", input.Name, readErr)), nil, nil
+	}
+	content, err := config.PrepareProfileWrite(input.Content, string(current))
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	if err := confirmWithUser(ctx, req, profileWriteSummary(input.Name, path, string(current), content)); err != nil {
+		return errorResult(err), nil, nil
 	}
 	profileMu.Lock()
 	defer profileMu.Unlock()
@@ -183,7 +240,10 @@ func (s *Server) handleWriteProfile(_ context.Context, _ *mcp.CallToolRequest, i
 	if aerr == nil && input.Name == active {
 		return errorResult(fmt.Errorf("cannot rewrite the active profile %q — switch away with use_profile first, or write to a copy", input.Name)), nil, nil
 	}
-	if err := atomicWrite(path, []byte(input.Content)); err != nil {
+	if input.Name == s.selectedProfile() {
+		return errorResult(fmt.Errorf("cannot rewrite profile %q, which this session uses — switch away with use_profile first, or write to a copy", input.Name)), nil, nil
+	}
+	if err := atomicWrite(path, []byte(content)); err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult(fmt.Sprintf("Wrote profile %q.", input.Name)), nil, nil
@@ -171,3 +171,68 @@ func TestCreateAndUseProfile(t *testing.T) {
 		t.Errorf("active pointer not updated: %q", string(got))
 	}
 }
+
+func TestReadWriteProfile_TokensRedactedAndRestored(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	withFakeVersion(t, "0.87.0")
+	if err := os.MkdirAll(filepath.Join(dir, "profiles"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "profiles", "staging.pkl")
+	if err := os.WriteFile(path, []byte("cli { api { url = \"http://x\" token = \"s3cret\" } }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	session := connectTestServer(t, "http://forced:1")
+
+	res, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "read_profile",
+		Arguments: map[string]any{"name": "staging"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := textContent(t, res)
+	if strings.Contains(read, "s3cret") {
+		t.Fatalf("got:\n%s\nwant:\nthe token redacted", read)
+	}
+
+	res, err = session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "write_profile",
+		Arguments: map[string]any{"name": "staging", "content": "// staging\n" + read},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError {
+		t.Fatalf("unexpected error: %s", textContent(t, res))
+	}
+	if got, _ := os.ReadFile(path); string(got) != "// staging\ncli { api { url = \"http://x\" token = \"s3cret\" } }\n" {
+		t.Errorf("got:\n%s\nwant:\nthe edit with the original token", got)
+	}
+
+	res, err = session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "write_profile",
+		Arguments: map[string]any{"name": "staging", "content": "cli { api { url = \"http://x\" tokenCommand = \"id\" } }\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsError || !strings.Contains(textContent(t, res), "tokenCommand") {
+		t.Fatalf("got:\n%v / %s\nwant:\na refused tokenCommand", res.IsError, textContent(t, res))
+	}
+
+	res, err = session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "write_profile",
+		Arguments: map[string]any{"name": "staging", "content": strings.Replace(read, "http://x", "http://y", 1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsError || !strings.Contains(textContent(t, res), "endpoint") {
+		t.Fatalf("got:\n%v / %s\nwant:\nthe redacted token refused for another endpoint", res.IsError, textContent(t, res))
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), "http://x") {
+		t.Errorf("got:\n%s\nwant:\nthe profile unchanged", got)
+	}
+}
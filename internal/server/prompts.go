@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"strings"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
@@ -10,7 +11,27 @@ func (s *Server) registerPrompts() {
 	s.mcpServer.AddPrompt(&mcp.Prompt{
 		Name:        "check_drift",
 		Description: "Check for infrastructure drift and help resolve it. Shows sync drift (out-of-band changes) and patch drift (unreconciled patches).",
-	}, func(_ context.Context, _ *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		Arguments: []*mcp.PromptArgument{
+			{
+				Name:        "stack",
+				Description: "Only check this stack (leave empty for all stacks)",
+			},
+			{
+				Name:        "profile",
+				Description: "The formae profile (environment) to check (leave empty for the active profile)",
+			},
+		},
+	}, func(_ context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		var scope string
+		if stack := req.Params.Arguments["stack"]; stack != "" {
+			scope += "Only check the stack " + stack + ".\n"
+		}
+		if p := req.Params.Arguments["profile"]; p != "" {
+			scope += "Pass profile " + p + " on every formae tool call.\n"
+		}
+		if scope != "" {
+			scope = "\n\n" + strings.TrimSuffix(scope, "\n")
+		}
 		return &mcp.GetPromptResult{
 			Description: "Check for infrastructure drift",
 			Messages: []*mcp.PromptMessage{
@@ -27,7 +48,7 @@ For each piece of drift found, help me decide whether to:
 - Absorb: incorporate the change into my IaC codebase
 - Extract to file: save the current state as PKL for manual review
 
-Group drift by stack and process one stack at a time.`},
+Group drift by stack and process one stack at a time.` + scope},
 				},
 			},
 		}, nil
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+const (
+	defaultResourceLimit    = 50
+	maxResourceLimit        = 500
+	defaultResourceMaxBytes = 50000
+)
+
+// resourceCursor is the decoded form of list_resources' opaque cursor. The
+// agent does not paginate, so the cursor is an offset into the full result,
+// bound to the query it was issued for.
+type resourceCursor struct {
+	Offset int    `json:"o"`
+	Query  string `json:"q"`
+}
+
+func queryFingerprint(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
+
+func encodeResourceCursor(offset int, query string) string {
+	data, _ := json.Marshal(resourceCursor{Offset: offset, Query: queryFingerprint(query)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeResourceCursor(cursor, query string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var c resourceCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	if c.Query != queryFingerprint(query) {
+		return 0, fmt.Errorf("cursor was issued for a different query; repeat the original query or start without a cursor")
+	}
+	return c.Offset, nil
+}
+
+// projectFields keeps only the requested top-level fields of a resource,
+// matching names case-insensitively so "nativeId" finds "NativeID". A resource
+// that is not a JSON object is returned unchanged.
+func projectFields(resource json.RawMessage, fields []string) json.RawMessage {
+	if len(fields) == 0 {
+		return resource
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(resource, &obj); err != nil {
+		return resource
+	}
+	want := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		want[strings.ToLower(f)] = true
+	}
+	out := make(map[string]json.RawMessage, len(fields))
+	for k, v := range obj {
+		if want[strings.ToLower(k)] {
+			out[k] = v
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return resource
+	}
+	return data
+}
+
+// pageResources slices one page out of the agent's full listing. The page
+// ends at limit resources or when the next resource would push the page past
+// maxBytes, whichever comes first; it always holds at least one resource so
+// a caller paging through can make progress.
+func pageResources(all []model.Resource, offset, limit, maxBytes int, fields []string, query string) tools.ListResourcesOutput {
+	out := tools.ListResourcesOutput{Resources: []json.RawMessage{}, Total: len(all), Offset: offset}
+	size := 0
+	i := offset
+	for ; i < len(all) && len(out.Resources) < limit; i++ {
+		raw, err := json.Marshal(all[i])
+		if err != nil {
+			continue
+		}
+		r := projectFields(raw, fields)
+		if len(out.Resources) > 0 && size+len(r) > maxBytes {
+			break
+		}
+		size += len(r)
+		out.Resources = append(out.Resources, r)
+	}
+	out.Returned = len(out.Resources)
+	if i < len(all) {
+		out.NextCursor = encodeResourceCursor(i, query)
+	}
+	return out
+}
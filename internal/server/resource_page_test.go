@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+func listResourcesPage(t *testing.T, session *mcp.ClientSession, args map[string]any) tools.ListResourcesOutput {
+	t.Helper()
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_resources", Arguments: args})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	var page tools.ListResourcesOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	return page
+}
+
+func TestListResources_Paging(t *testing.T) {
+	var resources []string
+	for i := 0; i < 5; i++ {
+		resources = append(resources, fmt.Sprintf(`{"Label":"b%d","Type":"AWS::S3::Bucket","Stack":"prod","NativeID":"arn:b%d","Properties":{"BucketName":"b%d"}}`, i, i, i))
+	}
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, "["+strings.Join(resources, ",")+"]")
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	args := map[string]any{"query": "managed:false", "limit": 2, "fields": []string{"label", "nativeId"}}
+	var labels []string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging did not terminate")
+		}
+		page := listResourcesPage(t, session, args)
+		if page.Total != 5 {
+			t.Fatalf("total = %d, want 5", page.Total)
+		}
+		for _, r := range page.Resources {
+			var obj map[string]any
+			if err := json.Unmarshal(r, &obj); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := obj["Properties"]; ok {
+				t.Errorf("fields projection kept Properties: %s", r)
+			}
+			if obj["NativeID"] == nil {
+				t.Errorf("fields projection dropped NativeID: %s", r)
+			}
+			labels = append(labels, obj["Label"].(string))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		args["cursor"] = page.NextCursor
+	}
+	if strings.Join(labels, ",") != "b0,b1,b2,b3,b4" {
+		t.Errorf("paged labels = %v", labels)
+	}
+}
+
+func TestListResources_MaxBytes(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"a"},{"Label":"b"},{"Label":"c"}]`)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	// Each resource is 13 bytes; a 20-byte budget fits one per page.
+	page := listResourcesPage(t, session, map[string]any{"max_bytes": 20})
+	if page.Returned != 1 || page.NextCursor == "" {
+		t.Fatalf("page = %+v, want one resource and a cursor", page)
+	}
+}
+
+func TestListResources_CursorQueryMismatch(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[]`)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "list_resources",
+		Arguments: map[string]any{"query": "stack:dev", "cursor": encodeResourceCursor(2, "stack:prod")},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if !result.IsError || !strings.Contains(textContent(t, result), "different query") {
+		t.Fatalf("expected cursor mismatch error, got %s", textContent(t, result))
+	}
+}
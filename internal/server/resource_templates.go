@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yosida95/uritemplate/v3"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+)
+
+// agentResourceReader reads one live agent resource given the variables
+// matched from its URI template.
+type agentResourceReader func(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error)
+
+// registerResourceTemplates exposes live agent state as MCP resource
+// templates, so clients can attach a stack, target, command or resource as
+// context without a tool call. Reads go to the active profile's agent;
+// listAgentResourcesMiddleware also lists the agent's stacks and targets as
+// concrete resources.
+func (s *Server) registerResourceTemplates() {
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://stacks/{label}",
+		Name:        "Formae Stack",
+		Description: "A stack as the agent reports it.",
+	}, readStackResource)
+
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://stacks/{label}/resources",
+		Name:        "Formae Stack Resources",
+		Description: "All resources managed in a stack, with their synced properties.",
+	}, readStackResourcesResource)
+
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://stacks/{label}/drift",
+		Name:        "Formae Stack Drift",
+		Description: "Resources changed outside formae since the stack was last reconciled. Subscribable.",
+	}, readStackDriftResource)
+
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://targets/{label}",
+		Name:        "Formae Target",
+		Description: "A configured target (cloud account/region or other provider endpoint).",
+	}, readTargetResource)
+
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://commands/{id}",
+		Name:        "Formae Command",
+		Description: "A command's state and per-resource progress. Subscribable: notifies when the state changes.",
+	}, readCommandResource)
+
+	s.addAgentResourceTemplate(&mcp.ResourceTemplate{
+		URITemplate: "formae://resources/{type}/{label}",
+		Name:        "Formae Resource",
+		Description: "Resources with the given type and label, managed or discovered, across stacks. " +
+			"The type's colons are percent-encoded in the URI (AWS%3A%3AS3%3A%3ABucket).",
+	}, readResourceResource)
+}
+
+func (s *Server) addAgentResourceTemplate(t *mcp.ResourceTemplate, read agentResourceReader) {
+	tmpl := uritemplate.MustNew(t.URITemplate)
+	t.MIMEType = "application/json"
+	s.mcpServer.AddResourceTemplate(t, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		uri := req.Params.URI
+		vars := tmpl.Match(uri)
+		if vars == nil {
+			return nil, mcp.ResourceNotFoundError(uri)
+		}
+		c, err := s.clientFor(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		v, err := read(ctx, c, vars)
+		if errors.Is(err, ErrNotFound) {
+			return nil, mcp.ResourceNotFoundError(uri)
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %s: %w", uri, err)
+		}
+		return &mcp.ReadResourceResult{
+			Contents: []*mcp.ResourceContents{{URI: uri, MIMEType: t.MIMEType, Text: string(data)}},
+		}, nil
+	})
+}
+
+// agentResourcesTimeout bounds the agent reads behind resources/list, which
+// the tool-call deadline does not cover. A variable so tests can shorten it.
+var agentResourcesTimeout = 3 * time.Second
+
+// listAgentResourcesMiddleware appends the active agent's stacks and targets
+// to the last page of resources/list as concrete formae:// URIs, for clients
+// that browse the list but not the templates. The agent is read on each list
+// request, once and within agentResourcesTimeout; when it cannot be reached in
+// time the list holds only the static docs.
+func (s *Server) listAgentResourcesMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		res, err := next(ctx, method, req)
+		if method != "resources/list" || err != nil {
+			return res, err
+		}
+		list, ok := res.(*mcp.ListResourcesResult)
+		if !ok || list.NextCursor != "" {
+			return res, nil
+		}
+		list.Resources = append(list.Resources, s.agentResources(ctx)...)
+		return list, nil
+	}
+}
+
+// agentResources lists a stack, its resources and its drift per stack, and
+// one resource per target, on the active profile's agent.
+func (s *Server) agentResources(ctx context.Context) []*mcp.Resource {
+	c, err := s.clientFor(ctx, "")
+	if err != nil {
+		slog.DebugContext(ctx, "resources/list: no agent", "error", err)
+		return nil
+	}
+	c = c.withoutRetries()
+	ctx, cancel := context.WithTimeout(ctx, agentResourcesTimeout)
+	defer cancel()
+	var out []*mcp.Resource
+	stacks, err := c.ListStacks(ctx)
+	if err != nil {
+		slog.DebugContext(ctx, "resources/list: list stacks", "error", err)
+	}
+	for _, st := range stacks {
+		uri := "formae://stacks/" + url.PathEscape(st.Label)
+		out = append(out,
+			agentResource(uri, st.Label, "Stack "+st.Label, st.Description),
+			agentResource(uri+"/resources", st.Label+"/resources", "Stack "+st.Label+" resources",
+				"All resources managed in the stack, with their synced properties."),
+			agentResource(uri+"/drift", st.Label+"/drift", "Stack "+st.Label+" drift",
+				"Resources changed outside formae since the stack was last reconciled. Subscribable."))
+	}
+	targets, err := c.ListTargets(ctx, "")
+	if err != nil {
+		slog.DebugContext(ctx, "resources/list: list targets", "error", err)
+	}
+	for _, t := range targets {
+		out = append(out, agentResource("formae://targets/"+url.PathEscape(t.Label), t.Label, "Target "+t.Label, t.Namespace))
+	}
+	return out
+}
+
+func agentResource(uri, name, title, description string) *mcp.Resource {
+	return &mcp.Resource{URI: uri, Name: name, Title: title, Description: description, MIMEType: "application/json"}
+}
+
+func readStackResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	label := vars.Get("label").String()
+	stacks, err := c.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stacks {
+		if st.Label == label {
+			return st, nil
+		}
+	}
+	return nil, fmt.Errorf("stack %q: %w", label, ErrNotFound)
+}
+
+func readStackResourcesResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	return c.ListResources(ctx, queryTerm("stack", vars.Get("label").String()))
+}
+
+func readStackDriftResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	return c.ListChangesSinceLastReconcile(ctx, vars.Get("label").String())
+}
+
+func readTargetResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	label := vars.Get("label").String()
+	targets, err := c.ListTargets(ctx, queryTerm("label", label))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.Label == label {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("target %q: %w", label, ErrNotFound)
+}
+
+func readCommandResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	id := vars.Get("id").String()
+	list, err := c.GetCommandStatus(ctx, id, "formae-mcp")
+	if err != nil {
+		return nil, err
+	}
+	cmd, ok := list.Find(id)
+	if !ok {
+		return nil, fmt.Errorf("command %s: %w", id, ErrNotFound)
+	}
+	return cmd, nil
+}
+
+func readResourceResource(ctx context.Context, c *FormaeClient, vars uritemplate.Values) (any, error) {
+	typ, label := vars.Get("type").String(), vars.Get("label").String()
+	all, err := c.ListResources(ctx, queryTerm("type", typ)+" "+queryTerm("label", label))
+	if err != nil {
+		return nil, err
+	}
+	matches := []model.Resource{}
+	for _, r := range all {
+		if r.Type == typ && r.Label == label {
+			matches = append(matches, r)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("resource %s %s: %w", typ, label, ErrNotFound)
+	}
+	return matches, nil
+}
@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
@@ -235,3 +239,142 @@ func TestReadResource_Troubleshooting(t *testing.T) {
 		t.Error("expected troubleshooting doc to cover alias/rename rejections")
 	}
 }
+
+func TestResourceTemplates(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"prod","Description":"Production"},{"Label":"dev"}]`)
+		},
+		"GET /api/v1/resources": func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("query"); got != `type:"AWS::S3::Bucket" label:"logs"` {
+				t.Errorf("query = %q", got)
+			}
+			_, _ = fmt.Fprint(w, `[{"Label":"logs","Type":"AWS::S3::Bucket","Stack":"prod"},{"Label":"logs-archive","Type":"AWS::S3::Bucket"}]`)
+		},
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+	ctx := context.Background()
+
+	templates, err := session.ListResourceTemplates(ctx, nil)
+	if err != nil {
+		t.Fatalf("ListResourceTemplates failed: %v", err)
+	}
+	if len(templates.ResourceTemplates) != 6 {
+		t.Errorf("expected 6 resource templates, got %d", len(templates.ResourceTemplates))
+	}
+
+	t.Run("stack", func(t *testing.T) {
+		result, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: "formae://stacks/prod"})
+		if err != nil {
+			t.Fatalf("ReadResource failed: %v", err)
+		}
+		if got := result.Contents[0].Text; got != `{"Label":"prod","Description":"Production"}` {
+			t.Errorf("stack = %s", got)
+		}
+		if result.Contents[0].MIMEType != "application/json" {
+			t.Errorf("MIMEType = %q", result.Contents[0].MIMEType)
+		}
+	})
+	t.Run("unknown stack", func(t *testing.T) {
+		if _, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: "formae://stacks/staging"}); err == nil {
+			t.Error("expected not-found error")
+		}
+	})
+	t.Run("resource by encoded type", func(t *testing.T) {
+		result, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: "formae://resources/AWS%3A%3AS3%3A%3ABucket/logs"})
+		if err != nil {
+			t.Fatalf("ReadResource failed: %v", err)
+		}
+		if got := result.Contents[0].Text; got != `[{"Label":"logs","Type":"AWS::S3::Bucket","Stack":"prod"}]` {
+			t.Errorf("resources = %s", got)
+		}
+	})
+	t.Run("missing command", func(t *testing.T) {
+		_, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: "formae://commands/cmd-404"})
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("err = %v, want resource not found", err)
+		}
+	})
+}
+
+func TestResourceListIncludesAgentStacksAndTargets(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"prod","Description":"Production"},{"Label":"team a"}]`)
+		},
+		"GET /api/v1/targets": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `[{"Label":"prod-us-east-1","Namespace":"AWS"}]`)
+		},
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+	ctx := context.Background()
+
+	resources, err := session.ListResources(ctx, nil)
+	if err != nil {
+		t.Fatalf("ListResources failed: %v", err)
+	}
+	got := map[string]bool{}
+	for _, r := range resources.Resources {
+		got[r.URI] = true
+	}
+	for _, uri := range []string{
+		"formae://docs/index",
+		"formae://stacks/prod",
+		"formae://stacks/prod/resources",
+		"formae://stacks/prod/drift",
+		"formae://stacks/team%20a",
+		"formae://targets/prod-us-east-1",
+	} {
+		if !got[uri] {
+			t.Errorf("resources/list is missing %s", uri)
+		}
+	}
+
+	// A listed URI reads back through its template.
+	result, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: "formae://stacks/team%20a"})
+	if err != nil {
+		t.Fatalf("ReadResource failed: %v", err)
+	}
+	if text := result.Contents[0].Text; text != `{"Label":"team a"}` {
+		t.Errorf("stack = %s", text)
+	}
+}
+
+func TestResourceListDoesNotWaitOnAHangingAgent(t *testing.T) {
+	old := agentResourcesTimeout
+	agentResourcesTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { agentResourcesTimeout = old })
+	var calls atomic.Int32
+	hang := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-r.Context().Done()
+	}
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks":  hang,
+		"GET /api/v1/targets": hang,
+	})
+	defer agent.Close()
+	session := connectTestServer(t, agent.URL)
+
+	start := time.Now()
+	resources, err := session.ListResources(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListResources failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("got:\nresources/list took %s\nwant:\nan answer after about %s", elapsed, agentResourcesTimeout)
+	}
+	for _, r := range resources.Resources {
+		if !strings.HasPrefix(r.URI, "formae://docs/") {
+			t.Errorf("got:\n%s\nwant:\nonly the static docs", r.URI)
+		}
+	}
+	if n := calls.Load(); n > 2 {
+		t.Errorf("got:\n%d agent requests\nwant:\none per list, no retries", n)
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// schemaTypes overrides inference for types whose JSON form differs from
+// their Go form. json.RawMessage carries agent-defined JSON (resource
+// properties, target and policy configs) and may hold any value.
+var schemaTypes = map[reflect.Type]*jsonschema.Schema{
+	reflect.TypeFor[json.RawMessage](): {},
+}
+
+// outputSchema infers a tool's output schema from T. Handlers keep their
+// untyped (any) output so error results carry no structured content, which
+// is why the schema is set on the tool rather than inferred by AddTool.
+func outputSchema[T any]() *jsonschema.Schema {
+	s, err := jsonschema.For[T](&jsonschema.ForOptions{TypeSchemas: schemaTypes})
+	if err != nil {
+		panic(fmt.Sprintf("output schema for %v: %v", reflect.TypeFor[T](), err))
+	}
+	return s
+}
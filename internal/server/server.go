@@ -1,18 +1,23 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
 	"github.com/platform-engineering-labs/formae-mcp/internal/config"
 	"github.com/platform-engineering-labs/formae-mcp/internal/featuregate"
+	"github.com/platform-engineering-labs/formae-mcp/internal/guardrail"
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
 	"github.com/platform-engineering-labs/formae-mcp/internal/profile"
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
 	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
 	"github.com/platform-engineering-labs/formae-mcp/internal/version"
 )
@@ -33,26 +38,70 @@ func implementation() *mcp.Implementation {
 type Server struct {
 	mcpServer      *mcp.Server
 	hub            *HubClient
+	plans          *planStore
+	watcher        *resourceWatcher
+	completions    *completionCache
 	forcedEndpoint string // when set, empty-profile calls use this (tests / explicit)
+	opts           Options
+	declaredTools  []string // every tool name, registered or filtered out
+
+	// perSession marks a Server built for one HTTP session (see HTTPHandler):
+	// use_profile then selects the session's profile in selected instead of
+	// switching the active profile every other session and the CLI share.
+	perSession bool
+	selectedMu sync.Mutex
+	selected   string
 }
 
 // New creates a new formae MCP server connected to the given agent endpoint.
 func New(endpoint string) *Server {
+	return NewWithOptions(endpoint, Options{})
+}
+
+// NewWithOptions creates a formae MCP server exposing the tools opts select.
+func NewWithOptions(endpoint string, opts Options) *Server {
+	s := newServer(endpoint, opts, NewHubClient())
+	s.warnUnmatchedToolPatterns()
+	return s
+}
+
+// sessionServer builds the Server for one HTTP session: the same tools and
+// hub client as s, with its own plans, completions, subscriptions and profile
+// selection.
+func (s *Server) sessionServer() *Server {
+	ss := newServer(s.forcedEndpoint, s.opts, s.hub)
+	ss.perSession = true
+	return ss
+}
+
+func newServer(endpoint string, opts Options, hub *HubClient) *Server {
+	s := &Server{
+		hub:            hub,
+		plans:          newPlanStore(),
+		completions:    newCompletionCache(),
+		forcedEndpoint: endpoint,
+		opts:           opts,
+	}
+	s.watcher = newResourceWatcher(func(ctx context.Context) (*FormaeClient, error) {
+		return s.clientFor(ctx, "")
+	})
+
 	mcpServer := mcp.NewServer(
 		implementation(),
 		&mcp.ServerOptions{
-			Instructions: serverInstructions,
+			Instructions:       instructionsFor(opts),
+			SubscribeHandler:   s.watcher.subscribe,
+			UnsubscribeHandler: s.watcher.unsubscribe,
+			CompletionHandler:  s.handleComplete,
 		},
 	)
+	s.mcpServer = mcpServer
+	s.watcher.server = mcpServer
 
-	s := &Server{
-		mcpServer:      mcpServer,
-		hub:            NewHubClient(),
-		forcedEndpoint: endpoint,
-	}
-
+	mcpServer.AddReceivingMiddleware(sessionContextMiddleware, telemetryMiddleware, toolDeadlineMiddleware, auditMiddleware, s.listAgentResourcesMiddleware)
 	s.registerTools()
 	s.registerResources()
+	s.registerResourceTemplates()
 	s.registerPrompts()
 
 	return s
@@ -61,7 +110,7 @@ func New(endpoint string) *Server {
 // clientFor builds a FormaeClient for the given profile (empty = active/default).
 // A non-empty profile is version-gated and name-validated; endpoint resolution
 // hard-errors for an unresolvable requested/active profile.
-func (s *Server) clientFor(profileName string) (*FormaeClient, error) {
+func (s *Server) clientFor(ctx context.Context, profileName string) (*FormaeClient, error) {
 	if profileName != "" {
 		if err := featuregate.GuardFeature(featuregate.FeatureProfile); err != nil {
 			return nil, err
@@ -70,13 +119,39 @@ func (s *Server) clientFor(profileName string) (*FormaeClient, error) {
 			return nil, err
 		}
 	} else if s.forcedEndpoint != "" {
+		noteAuditTarget(ctx, "", s.forcedEndpoint)
 		return NewFormaeClient(s.forcedEndpoint), nil
+	} else {
+		profileName = s.selectedProfile()
 	}
-	url, port, err := config.AgentEndpoint(profileName)
+	api, err := config.AgentAPI(profileName)
 	if err != nil {
 		return nil, err
 	}
-	return NewFormaeClient(url + ":" + port), nil
+	guarded, err := s.guardedProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := api.URL + ":" + api.Port
+	noteAuditTarget(ctx, guarded, endpoint)
+	return NewFormaeClientWithAuth(ctx, endpoint, api.Auth)
+}
+
+// selectedProfile returns the profile use_profile selected for this HTTP
+// session, or "" to follow the active profile.
+func (s *Server) selectedProfile() string {
+	s.selectedMu.Lock()
+	defer s.selectedMu.Unlock()
+	return s.selected
+}
+
+// activeProfile names the profile an empty-profile call reaches: the
+// session's selection, else the active profile.
+func (s *Server) activeProfile() (string, error) {
+	if name := s.selectedProfile(); name != "" {
+		return name, nil
+	}
+	return profile.ActiveProfile()
 }
 
 // Run starts the MCP server with the given transport.
@@ -88,104 +163,130 @@ func (s *Server) registerTools() {
 	readOnly := &mcp.ToolAnnotations{ReadOnlyHint: true}
 
 	// Read-only tools
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "list_resources",
-		Description: tools.ListResourcesDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "list_resources",
+		Description:  tools.ListResourcesDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListResourcesOutput](),
 	}, s.handleListResources)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "list_stacks",
-		Description: tools.ListStacksDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "list_stacks",
+		Description:  tools.ListStacksDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListStacksOutput](),
 	}, s.handleListStacks)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "list_targets",
-		Description: tools.ListTargetsDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "list_targets",
+		Description:  tools.ListTargetsDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListTargetsOutput](),
 	}, s.handleListTargets)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "get_command_status",
-		Description: tools.GetCommandStatusDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "get_command_status",
+		Description:  tools.GetCommandStatusDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.GetCommandStatusOutput](),
 	}, s.handleGetCommandStatus)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "list_commands",
-		Description: tools.ListCommandsDescription,
+	addTool(s, &mcp.Tool{
+		Name:        "wait_for_command",
+		Description: tools.WaitForCommandDescription,
 		Annotations: readOnly,
+	}, s.handleWaitForCommand)
+
+	addTool(s, &mcp.Tool{
+		Name:         "list_commands",
+		Description:  tools.ListCommandsDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListCommandsOutput](),
 	}, s.handleListCommands)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "get_agent_stats",
-		Description: tools.GetAgentStatsDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "get_agent_stats",
+		Description:  tools.GetAgentStatsDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[model.Stats](),
 	}, s.handleGetAgentStats)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "check_health",
 		Description: tools.CheckHealthDescription,
 		Annotations: readOnly,
 	}, s.handleCheckHealth)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
-		Name:        "list_policies",
-		Description: tools.ListPoliciesDescription,
-		Annotations: readOnly,
+	addTool(s, &mcp.Tool{
+		Name:         "list_policies",
+		Description:  tools.ListPoliciesDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListPoliciesOutput](),
 	}, s.handleListPolicies)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
+		Name:         "list_audit_log",
+		Description:  tools.ListAuditLogDescription,
+		Annotations:  readOnly,
+		OutputSchema: outputSchema[tools.ListAuditLogOutput](),
+	}, s.handleListAuditLog)
+
+	addTool(s, &mcp.Tool{
 		Name:        "list_changes_since_last_reconcile",
 		Description: tools.ListChangesSinceLastReconcileDescription,
 		Annotations: readOnly,
 	}, s.handleListChangesSinceLastReconcile)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
+		Name:        "diff_resource_drift",
+		Description: tools.DiffResourceDriftDescription,
+		Annotations: readOnly,
+	}, s.handleDiffResourceDrift)
+
+	addTool(s, &mcp.Tool{
 		Name:        "extract_resources",
 		Description: tools.ExtractResourcesDescription,
 		Annotations: readOnly,
 	}, s.handleExtractResources)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name: "list_profiles", Description: tools.ListProfilesDescription, Annotations: readOnly,
 	}, s.handleListProfiles)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name: "current_profile", Description: tools.CurrentProfileDescription, Annotations: readOnly,
 	}, s.handleCurrentProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name: "read_profile", Description: tools.ReadProfileDescription, Annotations: readOnly,
 	}, s.handleReadProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{Name: "use_profile", Description: tools.UseProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleUseProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{Name: "save_profile", Description: tools.SaveProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleSaveProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{Name: "create_profile", Description: tools.CreateProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleCreateProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{Name: "delete_profile", Description: tools.DeleteProfileDescription, Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(true)}}, s.handleDeleteProfile)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{Name: "diff_profiles", Description: tools.DiffProfilesDescription, Annotations: readOnly}, s.handleDiffProfiles)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{Name: "use_profile", Description: tools.UseProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleUseProfile)
+	addTool(s, &mcp.Tool{Name: "save_profile", Description: tools.SaveProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleSaveProfile)
+	addTool(s, &mcp.Tool{Name: "create_profile", Description: tools.CreateProfileDescription, Annotations: &mcp.ToolAnnotations{}}, s.handleCreateProfile)
+	addTool(s, &mcp.Tool{Name: "delete_profile", Description: tools.DeleteProfileDescription, Annotations: &mcp.ToolAnnotations{DestructiveHint: boolPtr(true)}}, s.handleDeleteProfile)
+	addTool(s, &mcp.Tool{Name: "diff_profiles", Description: tools.DiffProfilesDescription, Annotations: readOnly}, s.handleDiffProfiles)
+	addTool(s, &mcp.Tool{
 		Name: "write_profile", Description: tools.WriteProfileDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleWriteProfile)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "search_hub_plugins",
 		Description: tools.SearchHubPluginsDescription,
 		Annotations: readOnly,
 	}, s.handleSearchHubPlugins)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "get_hub_plugin",
 		Description: tools.GetHubPluginDescription,
 		Annotations: readOnly,
 	}, s.handleGetHubPlugin)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "list_plugin_examples",
 		Description: tools.ListPluginExamplesDescription,
 		Annotations: readOnly,
 	}, s.handleListPluginExamples)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "get_plugin_example",
 		Description: tools.GetPluginExampleDescription,
 		Annotations: readOnly,
@@ -193,73 +294,79 @@ func (s *Server) registerTools() {
 
 	// Mutation tools
 	destructive := boolPtr(true)
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "apply_forma",
 		Description: tools.ApplyFormaDescription,
 		Annotations: &mcp.ToolAnnotations{DestructiveHint: destructive},
 	}, s.handleApplyForma)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "destroy_forma",
 		Description: tools.DestroyFormaDescription,
 		Annotations: &mcp.ToolAnnotations{DestructiveHint: destructive},
 	}, s.handleDestroyForma)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "cancel_commands",
 		Description: tools.CancelCommandsDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleCancelCommands)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "force_sync",
 		Description: tools.ForceSyncDescription,
 		Annotations: &mcp.ToolAnnotations{IdempotentHint: true},
 	}, s.handleForceSync)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "force_discover",
 		Description: tools.ForceDiscoverDescription,
 		Annotations: &mcp.ToolAnnotations{IdempotentHint: true},
 	}, s.handleForceDiscover)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "force_check_ttl",
 		Description: tools.ForceCheckTTLDescription,
 		Annotations: &mcp.ToolAnnotations{IdempotentHint: true, DestructiveHint: destructive},
 	}, s.handleForceCheckTTL)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "force_reconcile_stack",
 		Description: tools.ForceReconcileStackDescription,
 		Annotations: &mcp.ToolAnnotations{IdempotentHint: true},
 	}, s.handleForceReconcileStack)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "create_inline_policy",
 		Description: tools.CreateInlinePolicyDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleCreateInlinePolicy)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
+		Name:        "absorb_drift",
+		Description: tools.AbsorbDriftDescription,
+		Annotations: &mcp.ToolAnnotations{},
+	}, s.handleAbsorbDrift)
+
+	addTool(s, &mcp.Tool{
 		Name:        "create_standalone_policy",
 		Description: tools.CreateStandalonePolicyDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleCreateStandalonePolicy)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "attach_standalone_policy",
 		Description: tools.AttachStandalonePolicyDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleAttachStandalonePolicy)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "detach_standalone_policy",
 		Description: tools.DetachStandalonePolicyDescription,
 		Annotations: &mcp.ToolAnnotations{},
 	}, s.handleDetachStandalonePolicy)
 
-	mcp.AddTool(s.mcpServer, &mcp.Tool{
+	addTool(s, &mcp.Tool{
 		Name:        "delete_standalone_policy",
 		Description: tools.DeleteStandalonePolicyDescription,
 		Annotations: &mcp.ToolAnnotations{},
@@ -268,169 +375,178 @@ func (s *Server) registerTools() {
 
 // Tool handlers — read-only
 
-func (s *Server) handleListResources(_ context.Context, _ *mcp.CallToolRequest, input tools.ListResourcesInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleListResources(ctx context.Context, _ *mcp.CallToolRequest, input tools.ListResourcesInput) (*mcp.CallToolResult, any, error) {
+	if input.Limit < 0 {
+		return errorResult(fmt.Errorf("limit must be >= 0, got %d", input.Limit)), nil, nil
+	}
+	if input.MaxBytes < 0 {
+		return errorResult(fmt.Errorf("max_bytes must be >= 0, got %d", input.MaxBytes)), nil, nil
+	}
+	limit := defaultResourceLimit
+	if input.Limit > 0 {
+		limit = min(input.Limit, maxResourceLimit)
+	}
+	maxBytes := defaultResourceMaxBytes
+	if input.MaxBytes > 0 {
+		maxBytes = input.MaxBytes
+	}
+	offset := 0
+	if input.Cursor != "" {
+		var err error
+		if offset, err = decodeResourceCursor(input.Cursor, input.Query); err != nil {
+			return errorResult(err), nil, nil
+		}
+	}
+
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ListResources(input.Query)
+	all, err := c.ListResources(ctx, input.Query)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	if offset > len(all) {
+		offset = len(all)
+	}
+	page := pageResources(all, offset, limit, maxBytes, input.Fields, input.Query)
+	data, err := json.Marshal(page)
+	if err != nil {
+		return errorResult(fmt.Errorf("marshal output: %w", err)), nil, nil
+	}
+	return jsonResult(data), page, nil
 }
 
-func (s *Server) handleListStacks(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleListStacks(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ListStacks()
+	result, err := c.ListStacks(ctx)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return marshalResult(result), tools.ListStacksOutput{Stacks: model.CanonicalList(result)}, nil
 }
 
-func (s *Server) handleListTargets(_ context.Context, _ *mcp.CallToolRequest, input tools.ListTargetsInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleListTargets(ctx context.Context, _ *mcp.CallToolRequest, input tools.ListTargetsInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ListTargets(input.Query)
+	result, err := c.ListTargets(ctx, input.Query)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return marshalResult(result), tools.ListTargetsOutput{Targets: model.CanonicalList(result)}, nil
 }
 
-func (s *Server) handleGetCommandStatus(_ context.Context, _ *mcp.CallToolRequest, input tools.GetCommandStatusInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleGetCommandStatus(ctx context.Context, _ *mcp.CallToolRequest, input tools.GetCommandStatusInput) (*mcp.CallToolResult, any, error) {
 	if input.CommandID == "" {
 		return errorResult(fmt.Errorf("command_id is required")), nil, nil
 	}
-	c, err := s.clientFor(input.Profile)
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.GetCommandStatus(input.CommandID, "formae-mcp")
+	result, err := c.GetCommandStatus(ctx, input.CommandID, "formae-mcp")
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	cmd, ok := result.Find(input.CommandID)
+	if !ok {
+		return errorResult(fmt.Errorf("command %s not found", input.CommandID)), nil, nil
+	}
+	return marshalResult(result), tools.GetCommandStatusOutput{Command: cmd.Canonical()}, nil
 }
 
-func (s *Server) handleListCommands(_ context.Context, _ *mcp.CallToolRequest, input tools.ListCommandsInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleListCommands(ctx context.Context, _ *mcp.CallToolRequest, input tools.ListCommandsInput) (*mcp.CallToolResult, any, error) {
 	maxResults := input.MaxResults
 	if maxResults == "" {
 		maxResults = "10"
 	}
-	c, err := s.clientFor(input.Profile)
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ListCommands(input.Query, maxResults, "formae-mcp")
+	result, err := c.ListCommands(ctx, input.Query, maxResults, "formae-mcp")
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return marshalResult(result), tools.ListCommandsOutput{Commands: model.CanonicalList(result.Commands)}, nil
 }
 
-func (s *Server) handleGetAgentStats(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleGetAgentStats(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.GetAgentStats()
+	result, err := c.GetAgentStats(ctx)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return marshalResult(result), result.Canonical(), nil
 }
 
-func (s *Server) handleCheckHealth(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleCheckHealth(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	if err := c.CheckHealth(); err != nil {
+	if err := c.CheckHealth(ctx); err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult("Formae agent is healthy and reachable."), nil, nil
 }
 
-func (s *Server) handleListPolicies(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleListPolicies(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ListPolicies()
+	result, err := c.ListPolicies(ctx)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return marshalResult(result), tools.ListPoliciesOutput{Policies: model.CanonicalList(result)}, nil
 }
 
-func (s *Server) handleListChangesSinceLastReconcile(_ context.Context, _ *mcp.CallToolRequest, input tools.ListChangesSinceLastReconcileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleListChangesSinceLastReconcile(ctx context.Context, _ *mcp.CallToolRequest, input tools.ListChangesSinceLastReconcileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 
 	if input.Stack != "" {
-		result, err := c.ListChangesSinceLastReconcile(input.Stack)
+		result, err := c.ListChangesSinceLastReconcile(ctx, input.Stack)
 		if err != nil {
 			return errorResult(err), nil, nil
 		}
-		return jsonResult(result), nil, nil
+		return marshalResult(result), nil, nil
 	}
 
 	// No stack specified: fetch all stacks, then get drift for each
-	stacksJSON, err := c.ListStacks()
+	stacks, err := c.ListStacks(ctx)
 	if err != nil {
 		return errorResult(fmt.Errorf("failed to list stacks: %w", err)), nil, nil
 	}
-
-	var stacks []struct {
-		Label string `json:"Label"`
-	}
-	if err := json.Unmarshal(stacksJSON, &stacks); err != nil {
-		return errorResult(fmt.Errorf("failed to parse stacks: %w", err)), nil, nil
-	}
-
-	type stackDrift struct {
-		Stack             string          `json:"Stack"`
-		ModifiedResources json.RawMessage `json:"ModifiedResources"`
-	}
-	var results []stackDrift
-
-	for _, stack := range stacks {
-		driftJSON, err := c.ListChangesSinceLastReconcile(stack.Label)
-		if err != nil {
-			return errorResult(fmt.Errorf("failed to get drift for stack %s: %w", stack.Label, err)), nil, nil
-		}
-
-		// Parse to check if there are modifications
-		var drift struct {
-			ModifiedResources json.RawMessage `json:"ModifiedResources"`
-		}
-		if err := json.Unmarshal(driftJSON, &drift); err != nil {
-			return errorResult(fmt.Errorf("failed to parse drift for stack %s: %w", stack.Label, err)), nil, nil
-		}
-
-		results = append(results, stackDrift{
-			Stack:             stack.Label,
-			ModifiedResources: drift.ModifiedResources,
-		})
+	labels := make([]string, len(stacks))
+	for i, st := range stacks {
+		labels[i] = st.Label
 	}
 
+	results := collectStackDrift(ctx, c, labels)
+	summary := summarizeStackDrift(len(labels), results)
 	aggregated, err := json.Marshal(results)
 	if err != nil {
 		return errorResult(fmt.Errorf("failed to marshal results: %w", err)), nil, nil
 	}
-	return jsonResult(aggregated), nil, nil
+	res := textResult(summary.header() + "\n\n" + string(aggregated))
+	res.IsError = len(labels) > 0 && summary.failed == len(labels)
+	return res, nil, nil
 }
 
-func (s *Server) handleExtractResources(_ context.Context, _ *mcp.CallToolRequest, input tools.ExtractResourcesInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleExtractResources(ctx context.Context, _ *mcp.CallToolRequest, input tools.ExtractResourcesInput) (*mcp.CallToolResult, any, error) {
 	if input.Query == "" {
 		return errorResult(fmt.Errorf("query is required")), nil, nil
 	}
@@ -455,8 +571,15 @@ func (s *Server) handleExtractResources(_ context.Context, _ *mcp.CallToolReques
 		args = append(args, "--profile", input.Profile)
 	}
 	args = append(args, outFile)
-	cmd := exec.Command("formae", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	cmdCtx, span := telemetry.StartSubprocess(ctx, args...)
+	cmd := exec.CommandContext(cmdCtx, "formae", args...)
+	cmd.WaitDelay = subprocessWaitDelay
+	output, err := cmd.CombinedOutput()
+	telemetry.End(cmdCtx, span, telemetry.ErrSubprocess, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errorResult(fmt.Errorf("formae extract stopped: %w", context.Cause(ctx))), nil, nil
+		}
 		return errorResult(fmt.Errorf("formae extract failed: %w\noutput: %s", err, string(output))), nil, nil
 	}
 
@@ -468,8 +591,8 @@ func (s *Server) handleExtractResources(_ context.Context, _ *mcp.CallToolReques
 	return textResult(string(content)), nil, nil
 }
 
-func (s *Server) handleSearchHubPlugins(_ context.Context, _ *mcp.CallToolRequest, input tools.SearchHubPluginsInput) (*mcp.CallToolResult, any, error) {
-	plugins, err := s.hub.SearchPlugins(input.Query)
+func (s *Server) handleSearchHubPlugins(ctx context.Context, _ *mcp.CallToolRequest, input tools.SearchHubPluginsInput) (*mcp.CallToolResult, any, error) {
+	plugins, err := s.hub.SearchPlugins(ctx, input.Query)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -480,11 +603,11 @@ func (s *Server) handleSearchHubPlugins(_ context.Context, _ *mcp.CallToolReques
 	return jsonResult(data), nil, nil
 }
 
-func (s *Server) handleGetHubPlugin(_ context.Context, _ *mcp.CallToolRequest, input tools.GetHubPluginInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleGetHubPlugin(ctx context.Context, _ *mcp.CallToolRequest, input tools.GetHubPluginInput) (*mcp.CallToolResult, any, error) {
 	if input.Name == "" {
 		return errorResult(fmt.Errorf("name is required")), nil, nil
 	}
-	d, err := s.hub.GetPlugin(input.Name)
+	d, err := s.hub.GetPlugin(ctx, input.Name)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -495,11 +618,11 @@ func (s *Server) handleGetHubPlugin(_ context.Context, _ *mcp.CallToolRequest, i
 	return jsonResult(data), nil, nil
 }
 
-func (s *Server) handleListPluginExamples(_ context.Context, _ *mcp.CallToolRequest, input tools.ListPluginExamplesInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleListPluginExamples(ctx context.Context, _ *mcp.CallToolRequest, input tools.ListPluginExamplesInput) (*mcp.CallToolResult, any, error) {
 	if input.Plugin == "" {
 		return errorResult(fmt.Errorf("plugin is required")), nil, nil
 	}
-	result, err := s.hub.ListExamples(input.Plugin, input.Version)
+	result, err := s.hub.ListExamples(ctx, input.Plugin, input.Version)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -510,14 +633,14 @@ func (s *Server) handleListPluginExamples(_ context.Context, _ *mcp.CallToolRequ
 	return jsonResult(data), nil, nil
 }
 
-func (s *Server) handleGetPluginExample(_ context.Context, _ *mcp.CallToolRequest, input tools.GetPluginExampleInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleGetPluginExample(ctx context.Context, _ *mcp.CallToolRequest, input tools.GetPluginExampleInput) (*mcp.CallToolResult, any, error) {
 	if input.Plugin == "" {
 		return errorResult(fmt.Errorf("plugin is required")), nil, nil
 	}
 	if input.Example == "" {
 		return errorResult(fmt.Errorf("example is required")), nil, nil
 	}
-	result, err := s.hub.GetExample(input.Plugin, input.Example, input.Version)
+	result, err := s.hub.GetExample(ctx, input.Plugin, input.Example, input.Version)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
@@ -530,7 +653,7 @@ func (s *Server) handleGetPluginExample(_ context.Context, _ *mcp.CallToolReques
 
 // Tool handlers — mutations
 
-func (s *Server) handleApplyForma(_ context.Context, _ *mcp.CallToolRequest, input tools.ApplyFormaInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleApplyForma(ctx context.Context, _ *mcp.CallToolRequest, input tools.ApplyFormaInput) (*mcp.CallToolResult, any, error) {
 	if input.FilePath == "" {
 		return errorResult(fmt.Errorf("file_path is required")), nil, nil
 	}
@@ -549,23 +672,75 @@ func (s *Server) handleApplyForma(_ context.Context, _ *mcp.CallToolRequest, inp
 		}
 	}
 
-	formaJSON, err := evalFormaFile(input.FilePath)
+	formaJSON, err := evalFormaFile(ctx, input.FilePath)
 	if err != nil {
 		return errorResult(fmt.Errorf("failed to evaluate forma file: %w", err)), nil, nil
 	}
+	noteAuditForma(ctx, formaJSON)
 
-	c, err := s.clientFor(input.Profile)
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.SubmitCommand("apply", input.Mode, input.Simulate, input.Force, formaJSON, "formae-mcp")
+	guarded, err := s.guardedProfile(input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	planIn := planInputs{mode: input.Mode, force: input.Force, profile: guarded, endpoint: c.endpoint}
+
+	// A forced reconcile overwrites drift and removes whatever the file no
+	// longer declares, so it is checked against the guardrails using both the
+	// forma and what a fresh simulation would change.
+	if !input.Simulate && input.Mode == "reconcile" && input.Force {
+		policy, err := guardrail.Load()
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+		if policy.Active() {
+			plan := simulatedPlan("apply", func(simulate bool) (json.RawMessage, error) {
+				return c.SubmitCommand(ctx, "apply", input.Mode, simulate, input.Force, formaJSON, "formae-mcp")
+			})
+			scope := guardrail.Scope{
+				Profile:    guarded,
+				Subjects:   append(formaSubjects(formaJSON), planSubjects(plan)...),
+				Unresolved: plan == nil,
+			}
+			if err := policy.Check("apply_forma (reconcile, force)", scope, input.OverrideProtection); err != nil {
+				return errorResult(err), nil, nil
+			}
+		}
+	}
+	if !input.Simulate {
+		if err := s.plans.take(input.PlanToken, formaJSON, input.FilePath, planIn); err != nil {
+			return errorResult(err), nil, nil
+		}
+	}
+	result, err := c.SubmitCommand(ctx, "apply", input.Mode, input.Simulate, input.Force, formaJSON, "formae-mcp")
+	if err != nil {
+		if !input.Simulate {
+			// The agent may have accepted the apply before the error, so the
+			// token is not handed back.
+			err = fmt.Errorf("%w. The plan token is used up: re-run apply_forma with simulate=true and confirm the plan before retrying", err)
+		}
+		return errorResult(err), nil, nil
+	}
+	if !input.Simulate {
+		return jsonResult(result), nil, nil
+	}
+
+	token := s.plans.issue(formaJSON, input.FilePath, planIn)
+	res, plan := simulationResult("apply", result)
+	res.Content = append(res.Content, &mcp.TextContent{Text: fmt.Sprintf(
+		"plan_token: %s\nTo apply exactly this plan, call apply_forma with simulate=false, the same file_path, mode, force and profile, and plan_token=%s. The apply is refused if the file changes first.",
+		token, token)})
+	if plan == nil {
+		return res, nil, nil
+	}
+	plan.PlanToken = token
+	return res, plan, nil
 }
 
-func (s *Server) handleDestroyForma(_ context.Context, _ *mcp.CallToolRequest, input tools.DestroyFormaInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleDestroyForma(ctx context.Context, req *mcp.CallToolRequest, input tools.DestroyFormaInput) (*mcp.CallToolResult, any, error) {
 	if input.FilePath == "" && input.Query == "" {
 		return errorResult(fmt.Errorf("either file_path or query is required")), nil, nil
 	}
@@ -581,90 +756,153 @@ func (s *Server) handleDestroyForma(_ context.Context, _ *mcp.CallToolRequest, i
 		}
 	}
 
-	c, err := s.clientFor(input.Profile)
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 
+	var submit func(simulate bool) (json.RawMessage, error)
+	var formaJSON []byte
+	what := input.FilePath
 	if input.Query != "" {
-		result, err := c.DestroyByQuery(input.Query, input.Simulate, "formae-mcp")
+		what = fmt.Sprintf("query %q", input.Query)
+		submit = func(simulate bool) (json.RawMessage, error) {
+			return c.DestroyByQuery(ctx, input.Query, simulate, "formae-mcp")
+		}
+	} else {
+		formaJSON, err = evalFormaFile(ctx, input.FilePath)
 		if err != nil {
-			return errorResult(err), nil, nil
+			return errorResult(fmt.Errorf("failed to evaluate forma file: %w", err)), nil, nil
+		}
+		noteAuditForma(ctx, formaJSON)
+		submit = func(simulate bool) (json.RawMessage, error) {
+			return c.SubmitCommand(ctx, "destroy", "", simulate, false, formaJSON, "formae-mcp")
 		}
-		return jsonResult(result), nil, nil
 	}
 
-	formaJSON, err := evalFormaFile(input.FilePath)
-	if err != nil {
-		return errorResult(fmt.Errorf("failed to evaluate forma file: %w", err)), nil, nil
+	// Before a real destroy, a fresh simulation resolves what it would remove:
+	// the guardrails are checked against it, and a client that can ask the
+	// user gets the question from the server with its summary.
+	if !input.Simulate {
+		policy, err := guardrail.Load()
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+		var plan *tools.ChangePlan
+		if policy.Active() || canElicit(req) {
+			plan = simulatedPlan("destroy", submit)
+		}
+		if policy.Active() {
+			guarded, err := s.guardedProfile(input.Profile)
+			if err != nil {
+				return errorResult(err), nil, nil
+			}
+			scope := guardrail.Scope{
+				Profile:  guarded,
+				Subjects: append(formaSubjects(formaJSON), planSubjects(plan)...),
+				// A query's reach is only known from its simulation.
+				Unresolved: plan == nil && input.Query != "",
+			}
+			if err := policy.Check("destroy_forma", scope, input.OverrideProtection); err != nil {
+				return errorResult(err), nil, nil
+			}
+		}
+		if err := confirmWithUser(ctx, req, destroySummary(plan, what, s.describeAgent(input.Profile))); err != nil {
+			return errorResult(err), nil, nil
+		}
 	}
 
-	result, err := c.SubmitCommand("destroy", "", input.Simulate, false, formaJSON, "formae-mcp")
+	result, err := submit(input.Simulate)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	return jsonResult(result), nil, nil
+	return destroyResult(result, input.Simulate)
 }
 
-func (s *Server) handleCancelCommands(_ context.Context, _ *mcp.CallToolRequest, input tools.CancelCommandsInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+// destroyResult renders a simulated destroy as a change plan and passes a
+// real one through.
+func destroyResult(body json.RawMessage, simulate bool) (*mcp.CallToolResult, any, error) {
+	if !simulate {
+		return jsonResult(body), nil, nil
+	}
+	res, plan := simulationResult("destroy", body)
+	if plan == nil {
+		return res, nil, nil
+	}
+	return res, plan, nil
+}
+
+func (s *Server) handleCancelCommands(ctx context.Context, req *mcp.CallToolRequest, input tools.CancelCommandsInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.CancelCommands(input.Query, "formae-mcp")
+	if err := confirmWithUser(ctx, req, cancelSummary(ctx, c, input.Query, s.describeAgent(input.Profile))); err != nil {
+		return errorResult(err), nil, nil
+	}
+	result, err := c.CancelCommands(ctx, input.Query, "formae-mcp")
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return jsonResult(result), nil, nil
 }
 
-func (s *Server) handleForceSync(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleForceSync(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	if err := c.ForceSync(); err != nil {
+	if err := c.ForceSync(ctx); err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult("Resource synchronization triggered successfully."), nil, nil
 }
 
-func (s *Server) handleForceDiscover(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleForceDiscover(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	if err := c.ForceDiscover(); err != nil {
+	if err := c.ForceDiscover(ctx); err != nil {
 		return errorResult(err), nil, nil
 	}
 	return textResult("Resource discovery triggered successfully."), nil, nil
 }
 
-func (s *Server) handleForceCheckTTL(_ context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
-	c, err := s.clientFor(input.Profile)
+func (s *Server) handleForceCheckTTL(ctx context.Context, _ *mcp.CallToolRequest, input tools.ProfileInput) (*mcp.CallToolResult, any, error) {
+	c, err := s.clientFor(ctx, input.Profile)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	result, err := c.ForceCheckTTL()
+	result, err := c.ForceCheckTTL(ctx)
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
 	return jsonResult(result), nil, nil
 }
 
-func (s *Server) handleForceReconcileStack(_ context.Context, _ *mcp.CallToolRequest, input tools.ForceReconcileStackInput) (*mcp.CallToolResult, any, error) {
+func (s *Server) handleForceReconcileStack(ctx context.Context, _ *mcp.CallToolRequest, input tools.ForceReconcileStackInput) (*mcp.CallToolResult, any, error) {
 	if input.Stack == "" {
 		return errorResult(fmt.Errorf("stack is required")), nil, nil
 	}
-	c, err := s.clientFor(input.Profile)
+	policy, err := guardrail.Load()
 	if err != nil {
 		return errorResult(err), nil, nil
 	}
-	body, _, err := c.ForceReconcileStack(input.Stack)
+	guarded, err := s.guardedProfile(input.Profile)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	scope := guardrail.Scope{Profile: guarded, Subjects: []guardrail.Subject{{Stack: input.Stack}}}
+	if err := policy.Check("force_reconcile_stack", scope, input.OverrideProtection); err != nil {
+		return errorResult(err), nil, nil
+	}
+	c, err := s.clientFor(ctx, input.Profile)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+	body, _, err := c.ForceReconcileStack(ctx, input.Stack)
 	if err != nil {
-		if body != nil {
-			return errorResult(fmt.Errorf("%s: %s", err.Error(), string(body))), nil, nil
-		}
 		return errorResult(err), nil, nil
 	}
 	return jsonResult(body), nil, nil
@@ -672,16 +910,26 @@ func (s *Server) handleForceReconcileStack(_ context.Context, _ *mcp.CallToolReq
 
 // Helpers
 
-func evalFormaFile(filePath string) ([]byte, error) {
+func evalFormaFile(ctx context.Context, filePath string) ([]byte, error) {
 	if strings.HasSuffix(filePath, ".json") {
 		return os.ReadFile(filePath)
 	}
 
-	cmd := exec.Command("formae", "eval", filePath, "--output-schema", "json", "--output-consumer", "machine")
+	args := []string{"eval", filePath, "--output-schema", "json", "--output-consumer", "machine"}
+	cmdCtx, span := telemetry.StartSubprocess(ctx, args...)
+	cmd := exec.CommandContext(cmdCtx, "formae", args...)
+	cmd.WaitDelay = subprocessWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	output, err := cmd.Output()
+	telemetry.End(cmdCtx, span, telemetry.ErrSubprocess, err)
+	logEvalStderr(ctx, filePath, stderr.Bytes())
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("formae eval failed: %s", string(exitErr.Stderr))
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("formae eval stopped: %w", context.Cause(ctx))
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("formae eval failed: %s", stderr.String())
 		}
 		return nil, fmt.Errorf("formae eval failed: %w", err)
 	}
@@ -697,6 +945,15 @@ func jsonResult(data json.RawMessage) *mcp.CallToolResult {
 	}
 }
 
+// marshalResult renders a typed agent response as a JSON text result.
+func marshalResult(v any) *mcp.CallToolResult {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errorResult(fmt.Errorf("marshal output: %w", err))
+	}
+	return jsonResult(data)
+}
+
 func textResult(text string) *mcp.CallToolResult {
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -2,12 +2,15 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
@@ -35,6 +38,11 @@ func connectTestServer(t *testing.T, agentURL string) *mcp.ClientSession {
 	t.Helper()
 	ctx := context.Background()
 
+	// Keep agent retries but without real backoff delays.
+	prevRetry := defaultRetryPolicy
+	defaultRetryPolicy.base, defaultRetryPolicy.max = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { defaultRetryPolicy = prevRetry })
+
 	s := New(agentURL)
 
 	t1, t2 := mcp.NewInMemoryTransports()
@@ -174,6 +182,43 @@ func TestListStacks(t *testing.T) {
 	if result.IsError {
 		t.Fatalf("expected success, got error: %s", textContent(t, result))
 	}
+	// The text keeps the agent's response for clients without structured
+	// content; the structured form carries only the modelled fields.
+	if text := textContent(t, result); !strings.Contains(text, "resource_count") {
+		t.Errorf("text lost the agent's fields: %s", text)
+	}
+	structured, err := json.Marshal(result.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"stacks":[{"Description":"Default stack","Label":"default"}]}`; string(structured) != want {
+		t.Errorf("structured = %s, want %s", structured, want)
+	}
+}
+
+func TestReadOnlyToolsAdvertiseOutputSchemas(t *testing.T) {
+	session := connectTestServer(t, "http://localhost:1")
+	result, err := session.ListTools(context.Background(), &mcp.ListToolsParams{})
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	want := map[string]bool{
+		"list_resources": true, "list_stacks": true, "list_targets": true, "list_commands": true,
+		"get_command_status": true, "get_agent_stats": true, "list_policies": true,
+	}
+	for _, tool := range result.Tools {
+		if !want[tool.Name] {
+			continue
+		}
+		delete(want, tool.Name)
+		schema, _ := json.Marshal(tool.OutputSchema)
+		if !strings.Contains(string(schema), `"type":"object"`) {
+			t.Errorf("%s output schema = %s", tool.Name, schema)
+		}
+	}
+	for name := range want {
+		t.Errorf("%s not registered", name)
+	}
 }
 
 func TestListPolicies(t *testing.T) {
@@ -264,6 +309,33 @@ func TestGetAgentStats(t *testing.T) {
 	}
 }
 
+func TestGetAgentStats_StructuredToleratesFieldTypeDrift(t *testing.T) {
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stats": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"Version":"0.88.0","ManagedResources":{"AWS":3},"Plugins":"aws"}`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name: "get_agent_stats",
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	structured, err := json.Marshal(result.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"ManagedResources":{"AWS":3},"Version":"0.88.0"}`; string(structured) != want {
+		t.Errorf("structured = %s, want %s", structured, want)
+	}
+}
+
 func TestGetCommandStatus(t *testing.T) {
 	agent := mockAgent(t, map[string]http.HandlerFunc{
 		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
@@ -658,8 +730,14 @@ func TestListChangesSinceLastReconcile_AllStacks(t *testing.T) {
 		t.Fatalf("expected success, got error: %s", textContent(t, result))
 	}
 	text := textContent(t, result)
-	if !strings.Contains(text, "production") || !strings.Contains(text, "staging") {
-		t.Errorf("expected result to contain both stacks, got: %s", text)
+	if !strings.HasPrefix(text, "Checked 2 stacks: 1 with changes") {
+		t.Errorf("expected summary header, got: %s", text)
+	}
+	if !strings.Contains(text, "prod-bucket") {
+		t.Errorf("expected production drift in result, got: %s", text)
+	}
+	if strings.Contains(text, `"Stack":"staging"`) {
+		t.Errorf("expected stack without changes to be dropped, got: %s", text)
 	}
 }
 
@@ -735,6 +813,9 @@ func TestListResources_AgentError(t *testing.T) {
 	if !result.IsError {
 		t.Fatal("expected error for 500 response")
 	}
+	if result.StructuredContent != nil {
+		t.Errorf("error result carries structured content: %v", result.StructuredContent)
+	}
 }
 
 func TestListResources_NotFound(t *testing.T) {
@@ -753,16 +834,41 @@ func TestListResources_NotFound(t *testing.T) {
 		t.Fatalf("CallTool failed: %v", err)
 	}
 	if result.IsError {
-		t.Fatal("expected success with empty array for 404")
+		t.Fatal("expected success with empty page for 404")
 	}
 	text := textContent(t, result)
-	if text != "[]" {
-		t.Errorf("expected empty array, got: %s", text)
+	if !strings.Contains(text, `"resources":[]`) || !strings.Contains(text, `"total":0`) {
+		t.Errorf("expected empty page, got: %s", text)
 	}
 }
 
 // --- Helper ---
 
+// withAgentProfiles points FORMAE_CONFIG_DIR at a config dir holding one
+// profile per name, each reaching the given agent URL, and returns a function
+// that makes one of them the active profile.
+func withAgentProfiles(t *testing.T, agents map[string]string) (setActive func(name string)) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("FORMAE_CONFIG_DIR", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "profiles"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, agent := range agents {
+		host, port, _ := strings.Cut(strings.TrimPrefix(agent, "http://"), ":")
+		content := fmt.Sprintf("cli {\n  api {\n    url = \"http://%s\"\n    port = %s\n  }\n}\n", host, port)
+		if err := writeTestFile(filepath.Join(dir, "profiles", name+".pkl"), content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func(name string) {
+		t.Helper()
+		if err := writeTestFile(filepath.Join(dir, "active"), name+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func writeTestFile(path, content string) error {
 	return os.WriteFile(path, []byte(content), 0644)
 }
@@ -777,7 +883,7 @@ func withFakeVersion(t *testing.T, v string) {
 
 func TestClientFor_EmptyProfileUsesForcedEndpoint(t *testing.T) {
 	s := New("http://forced:1") // forcedEndpoint
-	c, err := s.clientFor("")
+	c, err := s.clientFor(context.Background(), "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -802,7 +908,7 @@ func TestClientFor_ExplicitProfileResolvesFromConfigDir(t *testing.T) {
 	withFakeVersion(t, "0.87.0")
 
 	s := New("http://forced:1")
-	c, err := s.clientFor("p")
+	c, err := s.clientFor(context.Background(), "p")
 	if err != nil {
 		t.Fatal(err)
 	}
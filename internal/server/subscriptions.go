@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yosida95/uritemplate/v3"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+)
+
+// subscriptionPollInterval is how often the watcher re-reads subscribed
+// resources from the agent. A variable so tests can shrink it.
+var subscriptionPollInterval = 5 * time.Second
+
+var (
+	commandURITemplate    = uritemplate.MustNew("formae://commands/{id}")
+	stackDriftURITemplate = uritemplate.MustNew("formae://stacks/{label}/drift")
+)
+
+// resourceWatcher backs resources/subscribe for formae://commands/{id} and
+// formae://stacks/{label}/drift. While anything is subscribed, one goroutine
+// polls the agent each subscription was made against and sends
+// notifications/resources/updated when a command changes state or a stack's
+// drift changes. The SDK tracks which sessions receive the notification; the
+// watcher only decides what to poll and when something changed.
+type resourceWatcher struct {
+	server   *mcp.Server // set once the server exists; subscriptions arrive only after
+	clientFn func(ctx context.Context) (*FormaeClient, error)
+
+	mu          sync.Mutex
+	subscribers map[watchKey]map[*mcp.ServerSession]bool
+	// clients holds the client each watched agent is polled with.
+	clients map[string]*FormaeClient
+	// last holds a fingerprint of each watch's most recently observed state;
+	// a watch without an entry has not been observed yet.
+	last    map[watchKey]string
+	polling bool
+}
+
+// watchKey is a subscribed URI on one agent. The agent is resolved when the
+// client subscribes, so switching the active profile afterwards does not
+// point an existing subscription at another agent's command or stack.
+type watchKey struct {
+	uri      string
+	endpoint string
+}
+
+func newResourceWatcher(clientFn func(ctx context.Context) (*FormaeClient, error)) *resourceWatcher {
+	return &resourceWatcher{
+		clientFn:    clientFn,
+		subscribers: map[watchKey]map[*mcp.ServerSession]bool{},
+		clients:     map[string]*FormaeClient{},
+		last:        map[watchKey]string{},
+	}
+}
+
+// subscribe records a subscription against the active profile's agent and
+// starts the poller if it is idle. The current state is read as the baseline,
+// so the first notification reports a change made after the subscription; an
+// unreachable agent only defers the baseline to the first successful poll.
+func (w *resourceWatcher) subscribe(ctx context.Context, req *mcp.SubscribeRequest) error {
+	uri := req.Params.URI
+	if !isSubscribable(uri) {
+		return fmt.Errorf("resource %s does not support subscriptions; subscribe to formae://commands/{id} or formae://stacks/{label}/drift", uri)
+	}
+	c, err := w.clientFn(ctx)
+	if err != nil {
+		return fmt.Errorf("subscribe to %s: %w", uri, err)
+	}
+	key := watchKey{uri: uri, endpoint: c.endpoint}
+
+	w.mu.Lock()
+	if w.subscribers[key] == nil {
+		w.subscribers[key] = map[*mcp.ServerSession]bool{}
+	}
+	w.subscribers[key][req.Session] = true
+	w.clients[key.endpoint] = c
+	_, observed := w.last[key]
+	start := !w.polling
+	w.polling = true
+	w.mu.Unlock()
+
+	if !observed {
+		if fp, err := fingerprintResource(ctx, c, uri); err == nil {
+			w.mu.Lock()
+			if _, ok := w.last[key]; !ok {
+				w.last[key] = fp
+			}
+			w.mu.Unlock()
+		}
+	}
+	if start {
+		go w.run()
+	}
+	return nil
+}
+
+// unsubscribe drops the session's subscriptions to the URI on every agent.
+func (w *resourceWatcher) unsubscribe(_ context.Context, req *mcp.UnsubscribeRequest) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key := range w.subscribers {
+		if key.uri == req.Params.URI {
+			w.drop(key, req.Session)
+		}
+	}
+	return nil
+}
+
+// drop removes one session's subscription; w.mu must be held.
+func (w *resourceWatcher) drop(key watchKey, ss *mcp.ServerSession) {
+	delete(w.subscribers[key], ss)
+	if len(w.subscribers[key]) != 0 {
+		return
+	}
+	delete(w.subscribers, key)
+	delete(w.last, key)
+	for other := range w.subscribers {
+		if other.endpoint == key.endpoint {
+			return
+		}
+	}
+	delete(w.clients, key.endpoint)
+}
+
+// run polls until no subscriptions remain.
+func (w *resourceWatcher) run() {
+	ticker := time.NewTicker(subscriptionPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		keys := w.watched()
+		if len(keys) == 0 {
+			return
+		}
+		w.poll(keys)
+	}
+}
+
+// watched prunes subscriptions of sessions that have closed and returns the
+// watches still subscribed. With none left it marks the poller stopped, under
+// the same lock a new subscription checks, so no subscription is orphaned.
+func (w *resourceWatcher) watched() []watchKey {
+	live := slices.Collect(w.server.Sessions())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, sessions := range w.subscribers {
+		for ss := range sessions {
+			if !slices.Contains(live, ss) {
+				w.drop(key, ss)
+			}
+		}
+	}
+	if len(w.subscribers) == 0 {
+		w.polling = false
+		return nil
+	}
+	keys := make([]watchKey, 0, len(w.subscribers))
+	for key := range w.subscribers {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b watchKey) int {
+		return strings.Compare(a.uri+"\x00"+a.endpoint, b.uri+"\x00"+b.endpoint)
+	})
+	return keys
+}
+
+// poll re-reads each watch from its agent and notifies subscribers of the
+// URIs whose state changed since the last observation. A failed read keeps
+// the old state, so an agent outage neither notifies nor loses the baseline.
+// The notification names only the URI, so subscribers of the same URI on
+// another agent are told too and re-read it.
+func (w *resourceWatcher) poll(keys []watchKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultToolTimeout)
+	defer cancel()
+	for _, key := range keys {
+		w.mu.Lock()
+		prev, observed := w.last[key]
+		c := w.clients[key.endpoint]
+		// The watch's URI and agent are its subscribers' business: its
+		// logs, including the client's retries, go to them only.
+		keyCtx := withLogSessions(ctx, slices.Collect(maps.Keys(w.subscribers[key]))...)
+		w.mu.Unlock()
+		if c == nil || observed && strings.HasPrefix(prev, "terminal:") {
+			continue // unsubscribed meanwhile, or a finished command that does not change again
+		}
+		fp, err := fingerprintResource(keyCtx, c, key.uri)
+		if err != nil {
+			slog.DebugContext(keyCtx, "resource watcher: read failed", "uri", key.uri, "agent", key.endpoint, "error", err)
+			continue
+		}
+		w.mu.Lock()
+		_, stillSubscribed := w.subscribers[key]
+		if stillSubscribed {
+			w.last[key] = fp
+		}
+		w.mu.Unlock()
+		if stillSubscribed && observed && fp != prev {
+			_ = w.server.ResourceUpdated(ctx, &mcp.ResourceUpdatedNotificationParams{URI: key.uri})
+		}
+	}
+}
+
+func isSubscribable(uri string) bool {
+	return commandURITemplate.Match(uri) != nil || stackDriftURITemplate.Match(uri) != nil
+}
+
+// fingerprintResource reduces a subscribable resource to the part whose
+// change is worth a notification: a command's state, or the set of a stack's
+// drifted resources. Terminal command states are prefixed "terminal:".
+func fingerprintResource(ctx context.Context, c *FormaeClient, uri string) (string, error) {
+	if vars := commandURITemplate.Match(uri); vars != nil {
+		id := vars.Get("id").String()
+		list, err := c.GetCommandStatus(ctx, id, "formae-mcp")
+		if err != nil {
+			return "", err
+		}
+		cmd, err := commandFromStatus(list, id)
+		if err != nil {
+			return "", err
+		}
+		if isTerminalCommandState(cmd.State) {
+			return "terminal:" + cmd.State, nil
+		}
+		return cmd.State, nil
+	}
+	if vars := stackDriftURITemplate.Match(uri); vars != nil {
+		changes, err := c.ListChangesSinceLastReconcile(ctx, vars.Get("label").String())
+		if err != nil {
+			return "", err
+		}
+		return driftFingerprint(changes.ModifiedResources), nil
+	}
+	return "", fmt.Errorf("resource %s does not support subscriptions", uri)
+}
+
+// driftFingerprint is an order-independent key for a set of modified resources.
+func driftFingerprint(modified []model.ModifiedResource) string {
+	keys := make([]string, len(modified))
+	for i, m := range modified {
+		keys[i] = m.Type + "\x00" + m.Label + "\x00" + m.Operation
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, "\n")
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connectTestServerWithUpdates is connectTestServer with a client that reports
+// resource-updated notifications on the returned channel.
+func connectTestServerWithUpdates(t *testing.T, agentURL string) (*mcp.ClientSession, <-chan string) {
+	t.Helper()
+	ctx := context.Background()
+	prev := subscriptionPollInterval
+	subscriptionPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() { subscriptionPollInterval = prev })
+
+	s := New(agentURL)
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	updates := make(chan string, 16)
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, &mcp.ClientOptions{
+		ResourceUpdatedHandler: func(_ context.Context, req *mcp.ResourceUpdatedNotificationRequest) {
+			updates <- req.Params.URI
+		},
+	})
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession, updates
+}
+
+func TestSubscribeCommandNotifiesOnStateChange(t *testing.T) {
+	var calls atomic.Int32
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			state := "InProgress"
+			if calls.Add(1) > 3 {
+				state = "Success"
+			}
+			_, _ = fmt.Fprintf(w, `{"Commands":[{"CommandID":"cmd-1","State":%q}]}`, state)
+		},
+	})
+	defer agent.Close()
+	session, updates := connectTestServerWithUpdates(t, agent.URL)
+
+	if err := session.Subscribe(context.Background(), &mcp.SubscribeParams{URI: "formae://commands/cmd-1"}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	select {
+	case uri := <-updates:
+		if uri != "formae://commands/cmd-1" {
+			t.Errorf("updated URI = %q", uri)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after the command finished")
+	}
+
+	// A finished command is not polled again, so no further notifications.
+	polled := calls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != polled {
+		t.Errorf("finished command still polled: %d calls, then %d", polled, calls.Load())
+	}
+	select {
+	case uri := <-updates:
+		t.Errorf("unexpected second notification for %s", uri)
+	default:
+	}
+}
+
+func TestSubscribeStackDriftNotifiesOnNewDrift(t *testing.T) {
+	var calls atomic.Int32
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/prod/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) > 2 {
+				_, _ = fmt.Fprint(w, `{"ModifiedResources":[{"Stack":"prod","Type":"AWS::S3::Bucket","Label":"b","Operation":"update"}]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[]}`)
+		},
+	})
+	defer agent.Close()
+	session, updates := connectTestServerWithUpdates(t, agent.URL)
+
+	if err := session.Subscribe(context.Background(), &mcp.SubscribeParams{URI: "formae://stacks/prod/drift"}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	select {
+	case uri := <-updates:
+		if uri != "formae://stacks/prod/drift" {
+			t.Errorf("updated URI = %q", uri)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after drift appeared")
+	}
+
+	// Unchanged drift does not notify again.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case uri := <-updates:
+		t.Errorf("unexpected second notification for %s", uri)
+	default:
+	}
+}
+
+func TestSubscribePollsTheAgentSubscribedTo(t *testing.T) {
+	var calls atomic.Int32
+	prod := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks/app/changes-since-last-reconcile": func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) > 2 {
+				_, _ = fmt.Fprint(w, `{"ModifiedResources":[{"Stack":"app","Type":"AWS::S3::Bucket","Label":"b","Operation":"update"}]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"ModifiedResources":[]}`)
+		},
+	})
+	defer prod.Close()
+	// The staging agent must never be asked: the subscription was made
+	// against prod.
+	staging := mockAgent(t, map[string]http.HandlerFunc{})
+	defer staging.Close()
+	setActive := withAgentProfiles(t, map[string]string{"prod": prod.URL, "staging": staging.URL})
+	setActive("prod")
+	session, updates := connectTestServerWithUpdates(t, "")
+
+	if err := session.Subscribe(context.Background(), &mcp.SubscribeParams{URI: "formae://stacks/app/drift"}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	setActive("staging")
+
+	select {
+	case uri := <-updates:
+		if uri != "formae://stacks/app/drift" {
+			t.Errorf("updated URI = %q", uri)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after drift appeared on the subscribed agent")
+	}
+}
+
+func TestSubscribeRejectsUnsupportedResource(t *testing.T) {
+	session := connectTestServer(t, "http://localhost:1")
+	if err := session.Subscribe(context.Background(), &mcp.SubscribeParams{URI: "formae://stacks/prod"}); err == nil {
+		t.Error("expected an error subscribing to a resource without updates")
+	}
+}
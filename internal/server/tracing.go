@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
+)
+
+// telemetryMiddleware wraps every tools/call in a span, so the agent
+// requests and formae subprocesses a tool makes show up beneath it. A call
+// that fails or returns an error result is counted as a tool error.
+func telemetryMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		call, ok := req.(*mcp.CallToolRequest)
+		if !ok || call.Params == nil {
+			return next(ctx, method, req)
+		}
+		name := call.Params.Name
+		attrs := []attribute.KeyValue{
+			attribute.String("mcp.method.name", method),
+			attribute.String("gen_ai.tool.name", name),
+		}
+		if call.Session != nil && call.Session.ID() != "" {
+			attrs = append(attrs, attribute.String("mcp.session.id", call.Session.ID()))
+		}
+		ctx, span := telemetry.Start(ctx, method+" "+name, trace.SpanKindServer, attrs...)
+		defer span.End()
+
+		result, err := next(ctx, method, req)
+		toolAttr := attribute.String("gen_ai.tool.name", name)
+		if err != nil {
+			telemetry.Fail(ctx, span, telemetry.ErrTool, err.Error(), toolAttr)
+		} else if res, ok := result.(*mcp.CallToolResult); ok && res.IsError {
+			telemetry.Fail(ctx, span, telemetry.ErrTool, resultText(res), toolAttr)
+		}
+		return result, err
+	}
+}
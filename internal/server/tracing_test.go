@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/telemetry"
+)
+
+// withTestTelemetry records spans and metrics in memory for one test.
+func withTestTelemetry(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	prevTP, prevMP, prevProp := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetMeterProvider(prevMP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	return exp, reader
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestToolCallSpanParentsAgentRequests(t *testing.T) {
+	exp, _ := withTestTelemetry(t)
+	var traceparent string
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			_, _ = w.Write([]byte(`[]`))
+		},
+	})
+	t.Cleanup(agent.Close)
+	session := connectTestServer(t, agent.URL)
+
+	if _, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_stacks", Arguments: map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	tool := spanNamed(spans, "tools/call list_stacks")
+	request := spanNamed(spans, "GET /api/v1/stacks")
+	if tool == nil || request == nil {
+		t.Fatalf("want a tool span and an agent request span, got %d spans", len(spans))
+	}
+	if request.Parent.SpanID() != tool.SpanContext.SpanID() {
+		t.Error("agent request span is not a child of the tool span")
+	}
+	if traceparent == "" {
+		t.Error("agent request carried no traceparent header")
+	}
+}
+
+func TestToolErrorsAreCounted(t *testing.T) {
+	exp, reader := withTestTelemetry(t)
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/stacks": func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		},
+	})
+	t.Cleanup(agent.Close)
+	session := connectTestServer(t, agent.URL)
+
+	res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "list_stacks", Arguments: map[string]any{}})
+	if err != nil || !res.IsError {
+		t.Fatalf("want an error result, got %v %+v", err, res)
+	}
+
+	if tool := spanNamed(exp.GetSpans(), "tools/call list_stacks"); tool == nil || tool.Status.Code != codes.Error {
+		t.Errorf("want a failed tool span, got %+v", tool)
+	}
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != telemetry.ErrorsCounter {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				typ, _ := dp.Attributes.Value("error.type")
+				counts[typ.AsString()] += dp.Value
+			}
+		}
+	}
+	if counts[telemetry.ErrTool] != 1 || counts[telemetry.ErrAgentStatus] == 0 {
+		t.Errorf("error counts %v, want one tool error and agent status errors", counts)
+	}
+}
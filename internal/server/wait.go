@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+const (
+	defaultWaitTimeout = 600 * time.Second
+	maxWaitTimeout     = 3600 * time.Second
+)
+
+// Poll backoff for wait_for_command: start fast so short commands return
+// promptly, then back off so a long apply does not hammer the agent.
+// Variables so tests can shrink them.
+var (
+	waitPollInitial = 1 * time.Second
+	waitPollMax     = 10 * time.Second
+)
+
+// commandFromStatus picks commandID's entry out of a command status
+// response, rejecting a response that carries no state for it.
+func commandFromStatus(list model.CommandList, commandID string) (model.Command, error) {
+	c, ok := list.Find(commandID)
+	if !ok || c.State == "" {
+		return model.Command{}, fmt.Errorf("parse command status: no state in response for command %s", commandID)
+	}
+	return c, nil
+}
+
+// normalizeState folds the agent's state spellings ("InProgress",
+// "in_progress", "Success") into one lowercase form without separators.
+func normalizeState(state string) string {
+	return strings.NewReplacer("_", "", "-", "", " ", "").Replace(strings.ToLower(state))
+}
+
+// isTerminalCommandState reports whether a command has stopped running.
+func isTerminalCommandState(state string) bool {
+	switch normalizeState(state) {
+	case "success", "completed", "failed", "canceled", "cancelled":
+		return true
+	}
+	return false
+}
+
+// isFinishedUpdateState reports whether a single resource update is done,
+// successfully or not.
+func isFinishedUpdateState(state string) bool {
+	switch normalizeState(state) {
+	case "success", "completed", "failed", "rejected", "canceled", "cancelled":
+		return true
+	}
+	return false
+}
+
+// isFailedUpdateState reports whether a resource update ended unsuccessfully.
+func isFailedUpdateState(state string) bool {
+	switch normalizeState(state) {
+	case "failed", "rejected":
+		return true
+	}
+	return false
+}
+
+// finishedUpdates counts resource updates that have reached a final state.
+func finishedUpdates(c model.Command) int {
+	n := 0
+	for _, u := range c.ResourceUpdates {
+		if isFinishedUpdateState(u.State) {
+			n++
+		}
+	}
+	return n
+}
+
+// summarizeCommand condenses a command status into the wait_for_command result.
+func summarizeCommand(c model.Command, commandID string, elapsed time.Duration) tools.WaitForCommandOutput {
+	out := tools.WaitForCommandOutput{
+		CommandID:      commandID,
+		Command:        c.Command,
+		State:          c.State,
+		Finished:       isTerminalCommandState(c.State),
+		ElapsedSeconds: int(elapsed.Round(time.Second) / time.Second),
+	}
+	if len(c.ResourceUpdates) > 0 {
+		out.ResourceCounts = make(map[string]int)
+	}
+	for _, u := range c.ResourceUpdates {
+		state := u.State
+		if state == "" {
+			state = "Unknown"
+		}
+		out.ResourceCounts[state]++
+		if isFailedUpdateState(u.State) {
+			out.FailedResources = append(out.FailedResources, tools.FailedResourceUpdate{
+				Label:     u.ResourceLabel,
+				Type:      u.ResourceType,
+				Stack:     u.StackName,
+				Operation: u.Operation,
+				State:     u.State,
+				Error:     u.ErrorMessage,
+			})
+		}
+	}
+	return out
+}
+
+func (s *Server) handleWaitForCommand(ctx context.Context, req *mcp.CallToolRequest, input tools.WaitForCommandInput) (*mcp.CallToolResult, any, error) {
+	if input.CommandID == "" {
+		return errorResult(fmt.Errorf("command_id is required")), nil, nil
+	}
+	if input.TimeoutSeconds < 0 {
+		return errorResult(fmt.Errorf("timeout_seconds must be >= 0, got %d", input.TimeoutSeconds)), nil, nil
+	}
+	timeout := defaultWaitTimeout
+	if input.TimeoutSeconds > 0 {
+		timeout = min(time.Duration(input.TimeoutSeconds)*time.Second, maxWaitTimeout)
+	}
+	c, err := s.clientFor(ctx, input.Profile)
+	if err != nil {
+		return errorResult(err), nil, nil
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	interval := waitPollInitial
+	reported := -1
+
+	for {
+		list, err := c.GetCommandStatus(ctx, input.CommandID, "formae-mcp")
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+		status, err := commandFromStatus(list, input.CommandID)
+		if err != nil {
+			return errorResult(err), nil, nil
+		}
+
+		if done := finishedUpdates(status); done > reported {
+			notifyCommandProgress(ctx, req, status, done)
+			reported = done
+		}
+
+		if isTerminalCommandState(status.State) {
+			return waitResult(summarizeCommand(status, input.CommandID, time.Since(start)))
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			out := summarizeCommand(status, input.CommandID, time.Since(start))
+			out.TimedOut = true
+			return waitResult(out)
+		}
+
+		timer := time.NewTimer(min(interval, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errorResult(fmt.Errorf("stopped waiting for command %s: %w (the command is still running in the agent)",
+				input.CommandID, ctx.Err())), nil, nil
+		case <-timer.C:
+		}
+		interval = min(interval*3/2, waitPollMax)
+	}
+}
+
+// notifyCommandProgress sends a notifications/progress for the call when the
+// client asked for progress. Failures are ignored — progress is best-effort and
+// must never fail the wait itself.
+func notifyCommandProgress(ctx context.Context, req *mcp.CallToolRequest, status model.Command, done int) {
+	if req == nil || req.Session == nil || req.Params == nil {
+		return
+	}
+	token := req.Params.GetProgressToken()
+	if token == nil {
+		return
+	}
+	total := len(status.ResourceUpdates)
+	_ = req.Session.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+		ProgressToken: token,
+		Progress:      float64(done),
+		Total:         float64(total),
+		Message:       fmt.Sprintf("%s: %d/%d resource updates finished", status.State, done, total),
+	})
+}
+
+func waitResult(out tools.WaitForCommandOutput) (*mcp.CallToolResult, any, error) {
+	body, err := json.Marshal(out)
+	if err != nil {
+		return errorResult(fmt.Errorf("marshal output: %w", err)), nil, nil
+	}
+	return jsonResult(body), nil, nil
+}
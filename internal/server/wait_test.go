@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+	"github.com/platform-engineering-labs/formae-mcp/internal/tools"
+)
+
+// withFastWaitPolling shrinks the wait_for_command backoff for the test.
+func withFastWaitPolling(t *testing.T) {
+	t.Helper()
+	initial, max := waitPollInitial, waitPollMax
+	waitPollInitial, waitPollMax = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { waitPollInitial, waitPollMax = initial, max })
+}
+
+// connectTestServerWithProgress is connectTestServer with a client that records
+// progress notifications.
+func connectTestServerWithProgress(t *testing.T, agentURL string) (*mcp.ClientSession, func() []*mcp.ProgressNotificationParams) {
+	t.Helper()
+	ctx := context.Background()
+	s := New(agentURL)
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	var mu sync.Mutex
+	var got []*mcp.ProgressNotificationParams
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, &mcp.ClientOptions{
+		ProgressNotificationHandler: func(_ context.Context, req *mcp.ProgressNotificationClientRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, req.Params)
+		},
+	})
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession, func() []*mcp.ProgressNotificationParams {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*mcp.ProgressNotificationParams(nil), got...)
+	}
+}
+
+func TestWaitForCommand_PollsUntilTerminal(t *testing.T) {
+	withFastWaitPolling(t)
+	responses := []string{
+		`{"Commands":[{"CommandID":"cmd-1","Command":"apply","State":"InProgress","ResourceUpdates":[{"ResourceLabel":"a","State":"InProgress"},{"ResourceLabel":"b","State":"NotStarted"}]}]}`,
+		`{"Commands":[{"CommandID":"cmd-1","Command":"apply","State":"InProgress","ResourceUpdates":[{"ResourceLabel":"a","State":"Success"},{"ResourceLabel":"b","State":"InProgress"}]}]}`,
+		`{"Commands":[{"CommandID":"cmd-1","Command":"apply","State":"Failed","ResourceUpdates":[{"ResourceLabel":"a","State":"Success"},{"ResourceLabel":"b","ResourceType":"AWS::S3::Bucket","StackName":"prod","Operation":"create","State":"Failed","ErrorMessage":"AccessDenied"}]}]}`,
+	}
+	var calls atomic.Int32
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			i := int(calls.Add(1)) - 1
+			if i >= len(responses) {
+				i = len(responses) - 1
+			}
+			_, _ = fmt.Fprint(w, responses[i])
+		},
+	})
+	defer agent.Close()
+
+	session, progress := connectTestServerWithProgress(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Meta:      mcp.Meta{"progressToken": "tok-1"},
+		Name:      "wait_for_command",
+		Arguments: map[string]any{"command_id": "cmd-1"},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+
+	var out tools.WaitForCommandOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if !out.Finished || out.TimedOut || out.State != "Failed" {
+		t.Errorf("unexpected summary: %+v", out)
+	}
+	if out.ResourceCounts["Success"] != 1 || out.ResourceCounts["Failed"] != 1 {
+		t.Errorf("unexpected resource counts: %v", out.ResourceCounts)
+	}
+	if len(out.FailedResources) != 1 || out.FailedResources[0].Label != "b" || out.FailedResources[0].Error != "AccessDenied" {
+		t.Errorf("unexpected failed resources: %+v", out.FailedResources)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 polls, got %d", calls.Load())
+	}
+
+	// Progress arrives asynchronously; give the client a moment to drain it.
+	var notes []*mcp.ProgressNotificationParams
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if notes = progress(); len(notes) >= 3 {
+			break
+		}
+	}
+	if len(notes) != 3 {
+		t.Fatalf("expected 3 progress notifications (0, 1, 2 finished), got %d", len(notes))
+	}
+	for i, n := range notes {
+		if n.ProgressToken != "tok-1" || n.Progress != float64(i) || n.Total != 2 {
+			t.Errorf("notification %d = %+v", i, n)
+		}
+	}
+}
+
+func TestWaitForCommand_TimesOut(t *testing.T) {
+	withFastWaitPolling(t)
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"CommandID":"cmd-2","State":"InProgress"}`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "wait_for_command",
+		Arguments: map[string]any{"command_id": "cmd-2", "timeout_seconds": 1},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success on timeout, got error: %s", textContent(t, result))
+	}
+	var out tools.WaitForCommandOutput
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if out.Finished || !out.TimedOut || out.State != "InProgress" {
+		t.Errorf("unexpected summary: %+v", out)
+	}
+}
+
+func TestWaitForCommand_Cancelled(t *testing.T) {
+	withFastWaitPolling(t)
+	agent := mockAgent(t, map[string]http.HandlerFunc{
+		"GET /api/v1/commands/status": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, `{"CommandID":"cmd-3","State":"InProgress"}`)
+		},
+	})
+	defer agent.Close()
+
+	session := connectTestServer(t, agent.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := session.CallTool(ctx, &mcp.CallToolParams{
+		Name:      "wait_for_command",
+		Arguments: map[string]any{"command_id": "cmd-3"},
+	})
+	if err == nil {
+		t.Fatal("expected the call to end with the cancelled context")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("cancellation took %v", time.Since(start))
+	}
+}
+
+func TestCommandFromStatus(t *testing.T) {
+	parse := func(t *testing.T, body string) model.CommandList {
+		t.Helper()
+		var list model.CommandList
+		if err := json.Unmarshal([]byte(body), &list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+	t.Run("wrapped picks matching id", func(t *testing.T) {
+		c, err := commandFromStatus(parse(t, `{"Commands":[{"CommandID":"x","State":"Pending"},{"CommandID":"y","State":"Success"}]}`), "y")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.State != "Success" {
+			t.Errorf("State = %q, want Success", c.State)
+		}
+	})
+	t.Run("bare object", func(t *testing.T) {
+		c, err := commandFromStatus(parse(t, `{"CommandID":"x","State":"InProgress"}`), "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.State != "InProgress" {
+			t.Errorf("State = %q, want InProgress", c.State)
+		}
+	})
+	t.Run("no state", func(t *testing.T) {
+		if _, err := commandFromStatus(parse(t, `{}`), "x"); err == nil {
+			t.Error("expected error for a response without a state")
+		}
+	})
+}
+
+func TestIsTerminalCommandState(t *testing.T) {
+	for _, s := range []string{"Success", "completed", "Failed", "Canceled", "cancelled"} {
+		if !isTerminalCommandState(s) {
+			t.Errorf("isTerminalCommandState(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"Pending", "InProgress", "in_progress", "Canceling", ""} {
+		if isTerminalCommandState(s) {
+			t.Errorf("isTerminalCommandState(%q) = true, want false", s)
+		}
+	}
+}
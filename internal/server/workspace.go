@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// workspace is the set of root directories whose PKL files the policy and
+// drift tools search: the client's MCP roots, or the process working directory
+// for clients that declare none. A plugin host may launch formae-mcp from any
+// directory, and an editor may have several folders open, so the roots are
+// what identify the user's project.
+type workspace []string
+
+// workspaceFor resolves the workspace for a tool call by asking the client for
+// its roots (roots/list). Only file:// roots are used. A client without the
+// roots capability, or one that declares no file roots, gets the working
+// directory.
+func workspaceFor(ctx context.Context, req *mcp.CallToolRequest) (workspace, error) {
+	if req != nil && req.Session != nil {
+		if p := req.Session.InitializeParams(); p != nil && p.Capabilities != nil && p.Capabilities.RootsV2 != nil {
+			res, err := req.Session.ListRoots(ctx, nil)
+			if err != nil {
+				return nil, fmt.Errorf("list client roots: %w", err)
+			}
+			if ws := workspaceFromRoots(res.Roots); len(ws) > 0 {
+				return ws, nil
+			}
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getwd: %w", err)
+	}
+	return workspace{cwd}, nil
+}
+
+// workspaceFromRoots converts file:// root URIs to directories, in the order
+// the client declared them, dropping duplicates and other schemes.
+func workspaceFromRoots(roots []*mcp.Root) workspace {
+	var ws workspace
+	for _, r := range roots {
+		u, err := url.Parse(r.URI)
+		if err != nil || u.Scheme != "file" || u.Path == "" {
+			continue
+		}
+		dir := filepath.Clean(filepath.FromSlash(u.Path))
+		if !slices.Contains(ws, dir) {
+			ws = append(ws, dir)
+		}
+	}
+	return ws
+}
+
+// pklFiles walks every root and returns the PKL files found, in root order. A
+// file reachable from two roots (one nested in the other) is listed once.
+func (w workspace) pklFiles() ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, root := range w {
+		rootFiles, err := walkPKLFiles(root)
+		if err != nil {
+			return nil, fmt.Errorf("walk workspace root %s: %w", root, err)
+		}
+		for _, f := range rootFiles {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// rootOf returns the innermost root containing path, or "" if none does.
+func (w workspace) rootOf(path string) string {
+	best := ""
+	for _, root := range w {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(abs, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(abs) > len(best) {
+			best = abs
+		}
+	}
+	return best
+}
+
+// describe renders files for an error message grouped by the root that holds
+// them, e.g. "in /src/app: main.pkl, nested/sub.pkl; in /src/infra: infra.pkl".
+func (w workspace) describe(files []string) string {
+	var roots []string
+	byRoot := map[string][]string{}
+	for _, f := range files {
+		root := w.rootOf(f)
+		name := f
+		if root != "" {
+			if rel, err := filepath.Rel(root, f); err == nil {
+				name = rel
+			}
+		}
+		if _, ok := byRoot[root]; !ok {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], name)
+	}
+	groups := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if root == "" {
+			groups = append(groups, strings.Join(byRoot[root], ", "))
+			continue
+		}
+		groups = append(groups, fmt.Sprintf("in %s: %s", root, strings.Join(byRoot[root], ", ")))
+	}
+	return strings.Join(groups, "; ")
+}
+
+func (w workspace) String() string {
+	return strings.Join(w, ", ")
+}
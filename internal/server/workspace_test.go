@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// fileRootURI returns the file:// URI MCP clients use for a directory.
+func fileRootURI(t *testing.T, dir string) string {
+	t.Helper()
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// connectTestServerWithRoots is connectTestServer with a client that declares
+// the given directories as its roots.
+func connectTestServerWithRoots(t *testing.T, agentURL string, dirs ...string) *mcp.ClientSession {
+	t.Helper()
+	ctx := context.Background()
+	s := New(agentURL)
+	t1, t2 := mcp.NewInMemoryTransports()
+	serverSession, err := s.mcpServer.Connect(ctx, t1, nil)
+	if err != nil {
+		t.Fatalf("server.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "test-client", Version: "v0.0.1"}, nil)
+	for _, dir := range dirs {
+		client.AddRoots(&mcp.Root{URI: fileRootURI(t, dir)})
+	}
+	clientSession, err := client.Connect(ctx, t2, nil)
+	if err != nil {
+		t.Fatalf("client.Connect failed: %v", err)
+	}
+	t.Cleanup(func() { _ = clientSession.Close() })
+	return clientSession
+}
+
+func TestWorkspaceFromRoots(t *testing.T) {
+	ws := workspaceFromRoots([]*mcp.Root{
+		{URI: "file:///src/app"},
+		{URI: "https://example.com/repo"},
+		{URI: "file:///src/app/"},
+		{URI: "file:///src/infra"},
+	})
+	want := workspace{filepath.FromSlash("/src/app"), filepath.FromSlash("/src/infra")}
+	if !slices.Equal(ws, want) {
+		t.Errorf("workspaceFromRoots = %v, want %v", ws, want)
+	}
+}
+
+func TestResolveStackFileAmbiguousAcrossRootsNamesRoots(t *testing.T) {
+	fixtures := filepath.Join("..", "..", "testdata", "policy")
+	ws := workspace{filepath.Join(fixtures, "lifeline_fixture"), filepath.Join(fixtures, "walker_fixture")}
+	eval := func(path string) ([]byte, error) {
+		if filepath.Base(path) == "main.pkl" {
+			return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+		}
+		return []byte(`{"Stacks":[]}`), nil
+	}
+	_, _, err := resolveStackFile(t.Context(), ws, "lifeline", eval)
+	var ambErr *stackAmbiguousError
+	if !errors.As(err, &ambErr) {
+		t.Fatalf("expected stackAmbiguousError, got %T: %v", err, err)
+	}
+	for _, root := range ws {
+		abs, _ := filepath.Abs(root)
+		if !strings.Contains(err.Error(), "in "+abs+": main.pkl") {
+			t.Errorf("error does not name root %s:\n%v", abs, err)
+		}
+	}
+}
+
+func TestCreateInlinePolicyResolvesStackFromClientRoots(t *testing.T) {
+	withFakeVersion(t, "0.88.0")
+	prevEval := injectedEvalForTest
+	injectedEvalForTest = func(path string) ([]byte, error) {
+		if filepath.Base(path) == "main.pkl" {
+			return []byte(`{"Stacks":[{"Label":"lifeline"}]}`), nil
+		}
+		return []byte(`{"Stacks":[]}`), nil
+	}
+	t.Cleanup(func() { injectedEvalForTest = prevEval })
+
+	// The server's cwd (this package) holds no forma; only the root does.
+	fixture := filepath.Join("..", "..", "testdata", "policy", "lifeline_fixture")
+	session := connectTestServerWithRoots(t, "http://localhost:1", fixture)
+
+	result, err := session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name: "create_inline_policy",
+		Arguments: map[string]any{
+			"stack":         "lifeline",
+			"policy_type":   "ttl",
+			"operation":     "set",
+			"ttl_seconds":   1200,
+			"on_dependents": "abort",
+		},
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("expected success, got error: %s", textContent(t, result))
+	}
+	var out struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := json.Unmarshal([]byte(textContent(t, result)), &out); err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(filepath.Join(fixture, "main.pkl"))
+	if out.FilePath != abs {
+		t.Errorf("file_path = %s, want %s", out.FilePath, abs)
+	}
+}
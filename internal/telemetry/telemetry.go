@@ -0,0 +1,179 @@
+// Package telemetry is formae-mcp's OpenTelemetry instrumentation: spans for
+// tool calls, agent requests, formae subprocesses and hub fetches, and a
+// counter of errors by type. Export is off unless an OTLP endpoint is
+// configured in the environment; until then spans and counts go to the
+// global no-op providers and cost next to nothing.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of every span and instrument.
+const ScopeName = "github.com/platform-engineering-labs/formae-mcp"
+
+// ErrorsCounter counts errors, with an "error.type" attribute.
+const ErrorsCounter = "formae_mcp.errors"
+
+// Error types counted by ErrorsCounter.
+const (
+	ErrTool             = "tool"              // a tool call failed or returned an error result
+	ErrAgentUnreachable = "agent_unreachable" // an agent request got no response
+	ErrAgentStatus      = "agent_status"      // the agent answered 4xx/5xx
+	ErrSubprocess       = "subprocess"        // a formae subprocess failed
+	ErrHub              = "hub"               // a hub or GitHub fetch failed
+)
+
+// Setup installs OTLP/HTTP trace and metric export when the standard
+// environment asks for it: OTEL_EXPORTER_OTLP_ENDPOINT, or the per-signal
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT / OTEL_EXPORTER_OTLP_METRICS_ENDPOINT.
+// OTEL_SDK_DISABLED=true, or OTEL_TRACES_EXPORTER / OTEL_METRICS_EXPORTER set
+// to "none", turns a signal off. The exporters read the remaining OTEL_*
+// variables (headers, timeouts, TLS) themselves. The returned shutdown
+// flushes what is buffered; it is a no-op when nothing was installed.
+func Setup(ctx context.Context, serviceVersion string) (shutdown func(context.Context) error, err error) {
+	var shutdowns []func(context.Context) error
+	shutdown = func(ctx context.Context) error {
+		var errs []error
+		for _, fn := range shutdowns {
+			errs = append(errs, fn(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return shutdown, nil
+	}
+	traces := enabled("TRACES")
+	metrics := enabled("METRICS")
+	if !traces && !metrics {
+		return shutdown, nil
+	}
+	if p := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != "http/protobuf" {
+		return shutdown, fmt.Errorf("OTEL_EXPORTER_OTLP_PROTOCOL=%s is not supported; formae-mcp exports http/protobuf", p)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "formae-mcp"),
+			attribute.String("service.version", serviceVersion),
+		),
+		resource.WithTelemetrySDK(),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the above.
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return shutdown, fmt.Errorf("telemetry resource: %w", err)
+	}
+
+	if traces {
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return shutdown, fmt.Errorf("OTLP trace exporter: %w", err)
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+		shutdowns = append(shutdowns, tp.Shutdown)
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+	if metrics {
+		exp, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return shutdown, fmt.Errorf("OTLP metric exporter: %w", err)
+		}
+		mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)), sdkmetric.WithResource(res))
+		shutdowns = append(shutdowns, mp.Shutdown)
+		otel.SetMeterProvider(mp)
+	}
+	return shutdown, nil
+}
+
+// enabled reports whether the environment configures an OTLP endpoint for a
+// signal ("TRACES" or "METRICS") without turning its exporter off.
+func enabled(signal string) bool {
+	if os.Getenv("OTEL_"+signal+"_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_"+signal+"_ENDPOINT") != ""
+}
+
+// Start begins a span under ctx. Providers are looked up on every call, so
+// one installed later (by Setup, or an in-memory one in tests) takes effect.
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.GetTracerProvider().Tracer(ScopeName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End ends span, first marking it failed and counting an errType error when
+// err is non-nil.
+func End(ctx context.Context, span trace.Span, errType string, err error, attrs ...attribute.KeyValue) {
+	if err != nil {
+		Fail(ctx, span, errType, err.Error(), attrs...)
+	}
+	span.End()
+}
+
+// Fail marks span failed with description and counts an errType error, for
+// failures that are not Go errors, such as an error status or tool result.
+func Fail(ctx context.Context, span trace.Span, errType, description string, attrs ...attribute.KeyValue) {
+	span.SetStatus(codes.Error, description)
+	span.SetAttributes(attribute.String("error.type", errType))
+	CountError(ctx, errType, attrs...)
+}
+
+// CountError adds one to ErrorsCounter for errType.
+func CountError(ctx context.Context, errType string, attrs ...attribute.KeyValue) {
+	counter, err := otel.GetMeterProvider().Meter(ScopeName).Int64Counter(ErrorsCounter,
+		metric.WithDescription("Errors in formae-mcp, by error.type."),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		otel.Handle(err)
+		return
+	}
+	counter.Add(ctx, 1, metric.WithAttributes(append([]attribute.KeyValue{attribute.String("error.type", errType)}, attrs...)...))
+}
+
+// StartHTTP begins a client span for an outgoing request. The returned
+// request carries the span's context.
+func StartHTTP(req *http.Request, attrs ...attribute.KeyValue) (*http.Request, trace.Span) {
+	attrs = append([]attribute.KeyValue{
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", req.URL.Redacted()),
+		attribute.String("server.address", req.URL.Hostname()),
+	}, attrs...)
+	ctx, span := Start(req.Context(), req.Method+" "+req.URL.Path, trace.SpanKindClient, attrs...)
+	return req.WithContext(ctx), span
+}
+
+// Inject adds the trace context of req's context to its headers, so a
+// service that takes part in the trace (the agent) can continue it.
+func Inject(req *http.Request) {
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+}
+
+// StartSubprocess begins a span for a formae subprocess, named after its
+// subcommand (e.g. "formae eval").
+func StartSubprocess(ctx context.Context, args ...string) (context.Context, trace.Span) {
+	name := "formae"
+	if len(args) > 0 {
+		name += " " + args[0]
+	}
+	return Start(ctx, name, trace.SpanKindInternal,
+		attribute.String("process.executable.name", "formae"),
+		attribute.StringSlice("process.command_args", append([]string{"formae"}, args...)))
+}
@@ -0,0 +1,128 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inMemory installs in-memory trace and metric providers for one test.
+func inMemory(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	prevTP, prevMP, prevProp := otel.GetTracerProvider(), otel.GetMeterProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetMeterProvider(prevMP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	return exp, reader
+}
+
+// errorCounts sums ErrorsCounter by error.type.
+func errorCounts(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != ErrorsCounter {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				typ, _ := dp.Attributes.Value("error.type")
+				counts[typ.AsString()] += dp.Value
+			}
+		}
+	}
+	return counts
+}
+
+func TestSetupWithoutEndpointInstallsNothing(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "")
+	prev := otel.GetTracerProvider()
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otel.GetTracerProvider() != prev {
+		t.Error("Setup replaced the tracer provider without an endpoint")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestSetupRejectsGRPC(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:4317")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	if _, err := Setup(context.Background(), "test"); err == nil {
+		t.Error("expected an error for the grpc protocol")
+	}
+}
+
+func TestEndRecordsFailure(t *testing.T) {
+	exp, reader := inMemory(t)
+	ctx := context.Background()
+
+	_, ok := Start(ctx, "ok", trace.SpanKindInternal)
+	End(ctx, ok, ErrSubprocess, nil)
+	_, failed := StartSubprocess(ctx, "eval", "main.pkl")
+	End(ctx, failed, ErrSubprocess, errors.New("exit status 1"))
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status.Code == codes.Error {
+		t.Error("successful span marked failed")
+	}
+	if spans[1].Name != "formae eval" || spans[1].Status.Code != codes.Error || spans[1].Status.Description != "exit status 1" {
+		t.Errorf("unexpected failed span %s %+v", spans[1].Name, spans[1].Status)
+	}
+	if got := errorCounts(t, reader); got[ErrSubprocess] != 1 || len(got) != 1 {
+		t.Errorf("error counts %v, want one subprocess error", got)
+	}
+}
+
+func TestStartHTTPAndInject(t *testing.T) {
+	exp, _ := inMemory(t)
+	req, err := http.NewRequest("GET", "http://agent:49684/api/v1/stacks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, span := StartHTTP(req, attribute.String("extra", "x"))
+	Inject(req)
+	span.End()
+
+	if req.Header.Get("traceparent") == "" {
+		t.Error("want a traceparent header")
+	}
+	spans := exp.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "GET /api/v1/stacks" || spans[0].SpanKind != trace.SpanKindClient {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	if !trace.SpanContextFromContext(req.Context()).Equal(spans[0].SpanContext) {
+		t.Error("request context does not carry the span")
+	}
+}
@@ -7,7 +7,7 @@ const ListResourcesDescription = `Query infrastructure resources managed by the
 
 Use this tool when the user asks about deployed infrastructure, what resources exist, what's in a specific stack, or to find unmanaged resources discovered by the agent.
 
-IMPORTANT: This endpoint returns ALL matching resources with full properties. On large environments a broad query can return hundreds of thousands of characters and overflow the context window. You MUST always combine 'managed:false' with a type filter (e.g., 'managed:false type:AWS::S3::Bucket'). Never use 'managed:false' alone. For broad questions like "what do we have?" or "what's unmanaged?", use get_agent_stats first for an overview of counts by provider, then drill down with type-filtered queries.
+Results are paged: each call returns at most 'limit' resources (default 50) within roughly 'max_bytes' (default 50000), plus the total match count and a next_cursor when more remain. Pass next_cursor back with the same query to continue. Use 'fields' (e.g. ['label', 'type', 'stack', 'nativeId']) to drop properties and fit many more resources per page — this makes broad queries such as 'managed:false' safe. For broad questions like "what do we have?" or "what's unmanaged?", get_agent_stats still gives the quickest overview of counts by provider.
 
 Query syntax uses field:value pairs. Supported fields:
 - stack: filter by stack name (e.g., 'stack:production')
@@ -25,6 +25,10 @@ const ListPoliciesDescription = `List all standalone (reusable) policies known t
 
 Use this tool when the user asks about reusable policies, which stacks share a policy, or what standalone policies exist. For inline policies attached directly to a stack, use list_stacks — inline policies appear on each stack object.`
 
+const ListAuditLogDescription = `List entries from formae-mcp's audit log, newest first. Every call to a tool that changes infrastructure, profiles or PKL source (apply_forma, destroy_forma, cancel_commands, force_*, the profile writes and the policy planners) is recorded with its time, MCP session and client, sanitized arguments, resolved profile and agent endpoint, evaluated-forma hash, resulting command IDs, and outcome (success, error, or declined by the user).
+
+Use this tool when the user asks what was changed through this assistant, by which session, or why a command was submitted. Filter by tool, session_id, outcome, or since (RFC 3339 time or a duration like '24h').`
+
 const ListTargetsDescription = `Query infrastructure targets (cloud accounts/regions) configured in the formae agent.
 
 Use this tool when the user asks about their cloud targets, configured regions, or provider setup.
@@ -52,6 +56,12 @@ Query syntax uses field:value pairs. Supported fields:
 - stack: filter by stack name
 - managed: filter by managed status`
 
+const WaitForCommandDescription = `Wait for a formae command to finish and return one condensed summary: final state, resource update counts by state, and the label, type and error of every resource that failed.
+
+Use this after apply_forma, destroy_forma or force_reconcile_stack return a command ID, instead of calling get_command_status repeatedly. The tool polls the agent itself with backoff and, when the client supplies a progress token, sends progress notifications as resource updates complete.
+
+If timeout_seconds elapses first, the result has finished=false and timed_out=true with the progress so far. The command is still running in the agent; call wait_for_command again to keep waiting, or get_command_status for the full detail.`
+
 const GetAgentStatsDescription = `Get statistics about the formae agent including version, managed/unmanaged resource counts by provider, active plugins, and command counts.
 
 Use this tool to get an overview of the agent's state, check what plugins are loaded, or verify the agent version.`
@@ -60,7 +70,7 @@ const CheckHealthDescription = `Check if the formae agent is running and reachab
 
 Use this tool to verify the agent is available before performing operations.`
 
-const ApplyFormaDescription = `Submit a forma apply command to the formae agent. The command is executed asynchronously — use get_command_status or list_commands to monitor progress.
+const ApplyFormaDescription = `Submit a forma apply command to the formae agent. The command is executed asynchronously — use wait_for_command to block until it finishes, or get_command_status / list_commands to check on it.
 
 This tool evaluates the forma file (PKL -> JSON if needed) and submits it to the agent. There are two modes:
 
@@ -68,18 +78,22 @@ This tool evaluates the forma file (PKL -> JSON if needed) and submits it to the
 
 - patch: Only applies the changes explicitly specified in the forma file. Other resources are untouched. Use this for urgent targeted fixes (e.g., scaling up a cluster during an incident). Patches create drift that should later be reconciled.
 
-Use simulate=true to preview changes without modifying infrastructure.
+Use simulate=true to preview changes without modifying infrastructure. A simulation returns a change plan grouped by stack and target (creates, updates with property diffs, replacements forced by createOnly fields, deletes) as markdown plus structured content, and a plan_token identifying exactly what was previewed.
 Use force=true (reconcile only) to overwrite detected drift.
 
+A real apply (simulate=false) requires the plan_token from the simulation the user approved, with the same file_path, mode, force and profile. It is refused if the evaluated forma file, or the agent the call resolves to (e.g. after use_profile), has changed since that simulation; simulate again and re-confirm. Tokens are single-use, including when the submission fails, and expire after an hour.
+
+A real reconcile with force=true is also checked against the server's guardrails. Refused when it touches a profile, stack or resource protected by the server's guardrail config; override_protection=true lifts that only if the config allows it, and only after the user explicitly approves.
+
 IMPORTANT: Always simulate first and confirm with the user before applying changes to infrastructure.`
 
-const DestroyFormaDescription = `Submit a forma destroy command to remove infrastructure resources. Can destroy by forma file (all resources declared) or by query (matching resources). The command executes asynchronously.
+const DestroyFormaDescription = `Submit a forma destroy command to remove infrastructure resources. Can destroy by forma file (all resources declared) or by query (matching resources). The command executes asynchronously. With simulate=true the result is a change plan grouped by stack and target, as markdown plus structured content.
 
-IMPORTANT: Always simulate first and confirm with the user before destroying resources. Destruction is irreversible.`
+IMPORTANT: Always simulate first and confirm with the user before destroying resources. Destruction is irreversible. Refused when it touches a profile, stack or resource protected by the server's guardrail config; override_protection=true lifts that only if the config allows it, and only after the user explicitly approves. If your client supports elicitation, the server also asks the user directly, with the simulation's summary, and refuses the destroy if they decline — do not retry a declined destroy.`
 
 const CancelCommandsDescription = `Cancel one or more in-progress formae commands. If no query is provided, cancels the most recent in-progress command.
 
-Use this tool when the user wants to stop a running deployment or destroy operation.`
+Use this tool when the user wants to stop a running deployment or destroy operation. Clients that support elicitation are asked by the server to confirm first; a declined cancel is not run.`
 
 const ForceSyncDescription = `Trigger an immediate synchronization of resource state with the actual cloud infrastructure. The formae agent continuously syncs in the background, but this forces an immediate sync cycle.
 
@@ -95,7 +109,7 @@ DESTRUCTIVE: This tool destroys infrastructure for any stack whose TTL has expir
 
 Primarily useful for test harnesses and incident response. For normal operation the agent runs this automatically.`
 
-const ForceReconcileStackDescription = `Force a one-shot reconcile on a specific stack. Reverts any out-of-band changes to managed resources on the stack back to their last-known desired state. The stack must have an auto-reconcile policy attached.
+const ForceReconcileStackDescription = `Force a one-shot reconcile on a specific stack. Reverts any out-of-band changes to managed resources on the stack back to their last-known desired state. The stack must have an auto-reconcile policy attached. Refused when it touches a profile, stack or resource protected by the server's guardrail config; override_protection=true lifts that only if the config allows it, and only after the user explicitly approves.
 
 Returns 202 with a command_id when the reconcile starts (poll get_command_status to monitor progress). Returns 200 if there is no drift to reconcile. Returns 403 if the stack has no auto-reconcile policy attached. Returns 409 if the stack has active commands.
 
@@ -123,10 +137,30 @@ const ListChangesSinceLastReconcileDescription = `List infrastructure changes de
 
 Use this tool when the user asks about out-of-band changes, or what has changed in their infrastructure outside of formae since the last reconcile. Returns a list of modified resources grouped by stack, showing the resource type, label, and operation (update/delete).
 
-If a stack is specified, only checks that stack. If no stack is specified, checks all known stacks and aggregates the results.
+If a stack is specified, only checks that stack. If no stack is specified, checks all known stacks in parallel and returns a summary header followed by only the stacks with changes; a stack that could not be checked is listed with an Error instead of failing the whole call.
 
 An empty result means no changes have been detected — the infrastructure matches the last reconciled state.`
 
+const DiffResourceDriftDescription = `Show exactly which properties changed out of band on a stack's modified resources.
+
+For each resource that list_changes_since_last_reconcile reports for the stack, compares the last applied desired state (the properties of the most recent successful apply that touched it, whether a reconcile or a patch) with the current synced state, and returns a diff keyed by JSON pointer (e.g. /Tags/0/Value) with old (desired) and new (current) values. Only properties the desired state set are compared, so fields the agent or provider fills in (ids, ARNs, timestamps, defaults) never show as drift.
+
+Use this before deciding whether to absorb drift into the IaC code or overwrite it with a force reconcile. Resources deleted out of band, or with no desired state in recent command history, carry a note instead of a diff.`
+
+const AbsorbDriftDescription = `Plan the PKL edits that absorb a stack's out-of-band changes into the IaC source. For each modified resource (see diff_resource_drift) the tool finds its resource block — in the file declaring the stack, or any workspace PKL module — and computes anchored edits that rewrite the changed properties to their current cloud values. Only properties the block declares or the last apply set are absorbed, and nested values keep only the fields the last apply set, so read-only and provider-generated attributes (ARNs, ids, timestamps) never reach the source. The tool does NOT modify files — the caller applies each edit using the Edit tool.
+
+Output per resource:
+- file_path, block_start / block_end: where the resource block lives (1-indexed, inclusive)
+- edits: one per changed top-level property
+  - operation: "update" (replace the property's lines), "create" (insert the snippet before insertion_anchor_start, the block's closing line), "remove" (delete the lines), or "remove_block" (the resource was deleted out of band; delete the whole block)
+  - pkl_snippet: replacement text (empty for remove)
+  - insertion_anchor_start / insertion_anchor_end: 1-indexed inclusive line range
+  - existing_snippet: the lines being replaced or removed
+  - pointers: the JSON pointers of the drifted values this edit covers
+- notes: anything that needs a human look (expressions replaced by literals, nested values, resources not found)
+
+Edits are listed bottom-up. Apply every edit in a file from the highest line to the lowest so the remaining line numbers stay valid. Afterwards run apply_forma in reconcile mode with simulate=true and force=true on the main forma file to confirm no changes remain.`
+
 const SearchHubPluginsDescription = "Search the formae plugin hub catalog (hub.platform.engineering) for available plugins by name, namespace, or category. Returns qualifiedName, namespace, category, and latest stable version. Use this to infer which plugin SCHEMA packages a forma file needs, to resolve PklProject dependency versions, and to detect when no plugin exists for a desired service (which signals creating one). This reads the live catalog — it does NOT install anything."
 
 const GetHubPluginDescription = "Get detail for one hub plugin by short name, including its github_repo_url (used to locate examples) and latest version. Reads the live hub API."
@@ -141,13 +175,13 @@ Use when the user asks which profiles exist or which is active.`
 
 const CurrentProfileDescription = `Print the active formae configuration profile name. Returns JSON {"active": "<name>"}. Requires formae >= 0.87.0.`
 
-const ReadProfileDescription = `Return the PKL contents of a named configuration profile (the read half of "edit"). Requires formae >= 0.87.0.
+const ReadProfileDescription = `Return the PKL contents of a named configuration profile (the read half of "edit"). Token values are shown as "<redacted>". Requires formae >= 0.87.0.
 
 Use to inspect a profile before modifying it with write_profile.`
 
 const UseProfileDescription = `Switch the GLOBAL active formae configuration profile. Takes effect for subsequent MCP calls without restarting. Requires formae >= 0.87.0.
 
-Use sparingly. The active profile is global, persisted state shared with the user's formae CLI and any other concurrent MCP sessions — switching it can redirect work in those sessions to the wrong agent. To target a specific environment for your own work, do NOT call use_profile; instead pass the optional 'profile' argument on each tool (apply / destroy / status / inventory / list_* / force_* / cancel / extract). Only call use_profile when the user explicitly asks to change their default environment/agent.`
+Use sparingly. The active profile is global, persisted state shared with the user's formae CLI and any other concurrent MCP sessions — switching it can redirect work in those sessions to the wrong agent. To target a specific environment for your own work, do NOT call use_profile; instead pass the optional 'profile' argument on each tool (apply / destroy / status / inventory / list_* / force_* / cancel / extract). Only call use_profile when the user explicitly asks to change their default environment/agent. On a shared HTTP server it selects the profile for this MCP session only and leaves the global active profile alone.`
 
 const SaveProfileDescription = `Snapshot the active profile under a new name (does not switch). Requires formae >= 0.87.0.`
 
@@ -155,9 +189,9 @@ const CreateProfileDescription = `Create a new profile from the starter template
 
 const DeleteProfileDescription = `Delete a profile. Refuses the active profile — switch away first. Requires formae >= 0.87.0.`
 
-const DiffProfilesDescription = `Show a unified diff between two profiles (or <a> vs the active profile). Requires formae >= 0.87.0.`
+const DiffProfilesDescription = `Show a unified diff between two profiles (or <a> vs the active profile). Token values are redacted. Requires formae >= 0.87.0.`
 
-const WriteProfileDescription = `Overwrite an existing profile's PKL contents (the write half of "edit"). Overwrite-only — use create_profile for new profiles. Refuses the active profile: switch away with use_profile first, or write to a copy. The content is written as-is (formae 0.87.0 has no reliable config validator), so a malformed profile surfaces at next use/apply. Requires formae >= 0.87.0.`
+const WriteProfileDescription = `Overwrite an existing profile's PKL contents (the write half of "edit"). Overwrite-only — use create_profile for new profiles. Refuses the active profile: switch away with use_profile first, or write to a copy. The content is written as-is (formae 0.87.0 has no reliable config validator), so a malformed profile surfaces at next use/apply. Leave token values as read_profile returned them ("<redacted>") and the current values are kept. Refuses to add or change a tokenCommand: it runs as a shell command, so only the user may set it by editing the file. Likewise refuses changes to caBundle, clientCert, clientKey or insecureSkipVerify, and url or port changes while the profile keeps its credentials. Requires formae >= 0.87.0.`
 
 const ExtractResourcesDescription = `Extract resources as PKL infrastructure code. Runs 'formae extract' to export matching resources as a PKL forma file that can be incorporated into an IaC codebase.
 
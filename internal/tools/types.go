@@ -1,5 +1,12 @@
 package tools
 
+import (
+	"encoding/json"
+
+	"github.com/platform-engineering-labs/formae-mcp/internal/audit"
+	"github.com/platform-engineering-labs/formae-mcp/internal/model"
+)
+
 // EmptyInput is used for tools that take no parameters.
 type EmptyInput struct{}
 
@@ -11,8 +18,26 @@ type ProfileInput struct {
 
 // ListResourcesInput is the input for the list_resources tool.
 type ListResourcesInput struct {
-	Query   string `json:"query,omitempty" jsonschema:"Bluge query string to filter resources. Supported fields: stack, type, label, managed (boolean). Examples: 'managed:false', 'type:AWS::S3::Bucket stack:production', 'managed:true label:my-bucket'. Leave empty to list all resources."`
-	Profile string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	Query    string   `json:"query,omitempty" jsonschema:"Bluge query string to filter resources. Supported fields: stack, type, label, managed (boolean). Examples: 'managed:false', 'type:AWS::S3::Bucket stack:production', 'managed:true label:my-bucket'. Leave empty to list all resources."`
+	Limit    int      `json:"limit,omitempty" jsonschema:"Maximum number of resources to return in this page. Defaults to 50, capped at 500."`
+	Cursor   string   `json:"cursor,omitempty" jsonschema:"Continuation cursor from a previous call's next_cursor. Must be used with the same query."`
+	Fields   []string `json:"fields,omitempty" jsonschema:"Only return these fields of each resource, matched case-insensitively (e.g. ['label', 'type', 'stack', 'nativeId']). Leave empty for full resources including properties."`
+	MaxBytes int      `json:"max_bytes,omitempty" jsonschema:"Approximate size budget for the returned resources in bytes. The page stops early when the next resource would exceed it. Defaults to 50000."`
+	Profile  string   `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+}
+
+// ListResourcesOutput is one page of list_resources results.
+type ListResourcesOutput struct {
+	Resources  []json.RawMessage `json:"resources"`
+	Total      int               `json:"total"`
+	Offset     int               `json:"offset"`
+	Returned   int               `json:"returned"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ListStacksOutput is the structured result of the list_stacks tool.
+type ListStacksOutput struct {
+	Stacks []model.Stack `json:"stacks"`
 }
 
 // ListTargetsInput is the input for the list_targets tool.
@@ -21,12 +46,51 @@ type ListTargetsInput struct {
 	Profile string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
 }
 
+// ListTargetsOutput is the structured result of the list_targets tool.
+type ListTargetsOutput struct {
+	Targets []model.Target `json:"targets"`
+}
+
 // GetCommandStatusInput is the input for the get_command_status tool.
 type GetCommandStatusInput struct {
 	CommandID string `json:"command_id" jsonschema:"required,The ID of the command to check status for."`
 	Profile   string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
 }
 
+// GetCommandStatusOutput is the structured result of the get_command_status tool.
+type GetCommandStatusOutput struct {
+	Command model.Command `json:"command"`
+}
+
+// WaitForCommandInput is the input for the wait_for_command tool.
+type WaitForCommandInput struct {
+	CommandID      string `json:"command_id" jsonschema:"required,The ID of the command to wait for, as returned by apply_forma, destroy_forma or force_reconcile_stack."`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema:"Maximum time to wait, in seconds, before returning the command's current state. Defaults to 600 and is capped at 3600. Reaching the timeout is not an error: the command keeps running in the agent and you may wait again."`
+	Profile        string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+}
+
+// WaitForCommandOutput is the condensed final summary returned by wait_for_command.
+type WaitForCommandOutput struct {
+	CommandID       string                 `json:"command_id"`
+	Command         string                 `json:"command,omitempty"`
+	State           string                 `json:"state"`
+	Finished        bool                   `json:"finished"`
+	TimedOut        bool                   `json:"timed_out,omitempty"`
+	ElapsedSeconds  int                    `json:"elapsed_seconds"`
+	ResourceCounts  map[string]int         `json:"resource_counts,omitempty"`
+	FailedResources []FailedResourceUpdate `json:"failed_resources,omitempty"`
+}
+
+// FailedResourceUpdate identifies one resource update that did not succeed.
+type FailedResourceUpdate struct {
+	Label     string `json:"label"`
+	Type      string `json:"type"`
+	Stack     string `json:"stack,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
 // ListCommandsInput is the input for the list_commands tool.
 type ListCommandsInput struct {
 	Query      string `json:"query,omitempty" jsonschema:"Query to filter commands. Supported fields: id, client, command (apply/destroy), status (pending/in_progress/completed/failed), stack, managed. Use 'client:me' to filter to your own commands. Leave empty for most recent commands."`
@@ -34,21 +98,97 @@ type ListCommandsInput struct {
 	Profile    string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
 }
 
+// ListCommandsOutput is the structured result of the list_commands tool.
+type ListCommandsOutput struct {
+	Commands []model.Command `json:"commands"`
+}
+
+// ListPoliciesOutput is the structured result of the list_policies tool.
+type ListPoliciesOutput struct {
+	Policies []model.Policy `json:"policies"`
+}
+
+// ListAuditLogInput is the input for the list_audit_log tool.
+type ListAuditLogInput struct {
+	Tool      string `json:"tool,omitempty" jsonschema:"Only entries for this tool, e.g. 'apply_forma'."`
+	SessionID string `json:"session_id,omitempty" jsonschema:"Only entries from this MCP session."`
+	Outcome   string `json:"outcome,omitempty" jsonschema:"Only entries with this outcome: 'success', 'error' or 'declined'."`
+	Since     string `json:"since,omitempty" jsonschema:"Only entries at or after this time: RFC 3339 (e.g. '2026-01-02T15:04:05Z') or a duration back from now (e.g. '24h')."`
+	Limit     int    `json:"limit,omitempty" jsonschema:"Maximum number of entries to return, newest first. Defaults to 50."`
+}
+
+// ListAuditLogOutput is the structured result of the list_audit_log tool.
+type ListAuditLogOutput struct {
+	Path    string        `json:"path"`
+	Entries []audit.Entry `json:"entries"`
+}
+
 // ApplyFormaInput is the input for the apply_forma tool.
 type ApplyFormaInput struct {
-	FilePath string `json:"file_path" jsonschema:"required,Absolute path to the forma file (.pkl or .json). PKL files are evaluated locally before submission."`
-	Mode     string `json:"mode" jsonschema:"required,Apply mode. 'reconcile': full stack declaration - guarantees infrastructure matches the file exactly. Resources in the file but not in infra are created; resources in infra but not in the file are destroyed; differences are updated. 'patch': only applies the specified changes without affecting other resources - use for targeted urgent fixes."`
-	Simulate bool   `json:"simulate,omitempty" jsonschema:"If true, performs a dry-run showing what changes would be made without actually modifying infrastructure. Defaults to false."`
-	Force    bool   `json:"force,omitempty" jsonschema:"Only applies to reconcile mode. If true, overwrites any out-of-band changes (drift) detected since the last reconcile. Without force, reconcile rejects if drift is detected."`
-	Profile  string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	FilePath           string `json:"file_path" jsonschema:"required,Absolute path to the forma file (.pkl or .json). PKL files are evaluated locally before submission."`
+	Mode               string `json:"mode" jsonschema:"required,Apply mode. 'reconcile': full stack declaration - guarantees infrastructure matches the file exactly. Resources in the file but not in infra are created; resources in infra but not in the file are destroyed; differences are updated. 'patch': only applies the specified changes without affecting other resources - use for targeted urgent fixes."`
+	Simulate           bool   `json:"simulate,omitempty" jsonschema:"If true, performs a dry-run showing what changes would be made without actually modifying infrastructure. Defaults to false."`
+	Force              bool   `json:"force,omitempty" jsonschema:"Only applies to reconcile mode. If true, overwrites any out-of-band changes (drift) detected since the last reconcile. Without force, reconcile rejects if drift is detected."`
+	PlanToken          string `json:"plan_token,omitempty" jsonschema:"Required when simulate is false. The plan_token returned by the simulate=true call the user approved. The apply is refused if the forma file, mode, force or profile differ from that simulation."`
+	Profile            string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	OverrideProtection bool   `json:"override_protection,omitempty" jsonschema:"Proceed even though the call touches profiles, stacks or resources protected by the server's guardrail config. Set only after the user has explicitly approved it for this call; honoured only when the config allows overrides."`
 }
 
 // DestroyFormaInput is the input for the destroy_forma tool.
 type DestroyFormaInput struct {
-	FilePath string `json:"file_path,omitempty" jsonschema:"Path to the forma file declaring resources to destroy. Mutually exclusive with query."`
-	Query    string `json:"query,omitempty" jsonschema:"Query to select resources for destruction. Examples: 'stack:staging', 'type:AWS::S3::Bucket label:temp-data'. Mutually exclusive with file_path."`
-	Simulate bool   `json:"simulate,omitempty" jsonschema:"If true, performs a dry-run showing what would be destroyed without actually deleting resources. Defaults to false."`
-	Profile  string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	FilePath           string `json:"file_path,omitempty" jsonschema:"Path to the forma file declaring resources to destroy. Mutually exclusive with query."`
+	Query              string `json:"query,omitempty" jsonschema:"Query to select resources for destruction. Examples: 'stack:staging', 'type:AWS::S3::Bucket label:temp-data'. Mutually exclusive with file_path."`
+	Simulate           bool   `json:"simulate,omitempty" jsonschema:"If true, performs a dry-run showing what would be destroyed without actually deleting resources. Defaults to false."`
+	Profile            string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	OverrideProtection bool   `json:"override_protection,omitempty" jsonschema:"Proceed even though the call touches profiles, stacks or resources protected by the server's guardrail config. Set only after the user has explicitly approved it for this call; honoured only when the config allows overrides."`
+}
+
+// ChangePlan is the grouped, human-oriented view of a simulated apply or
+// destroy, returned as structured content alongside its markdown rendering.
+type ChangePlan struct {
+	Command         string            `json:"command"`
+	CommandID       string            `json:"command_id,omitempty"`
+	ChangesRequired bool              `json:"changes_required"`
+	Summary         ChangePlanSummary `json:"summary"`
+	Groups          []ChangePlanGroup `json:"groups,omitempty"`
+	Targets         []PlannedChange   `json:"targets,omitempty"`
+	PlanToken       string            `json:"plan_token,omitempty"`
+}
+
+// ChangePlanSummary counts planned resource changes by action.
+type ChangePlanSummary struct {
+	Create  int `json:"create"`
+	Update  int `json:"update"`
+	Replace int `json:"replace"`
+	Delete  int `json:"delete"`
+	Other   int `json:"other,omitempty"`
+}
+
+// ChangePlanGroup holds the planned changes for one stack on one target.
+type ChangePlanGroup struct {
+	Stack   string          `json:"stack"`
+	Target  string          `json:"target,omitempty"`
+	Changes []PlannedChange `json:"changes"`
+}
+
+// PlannedChange is one resource (or target) the simulation would touch.
+// Action is create, update, replace, delete, or the agent's operation name
+// when it is none of those.
+type PlannedChange struct {
+	Action        string           `json:"action"`
+	Type          string           `json:"type,omitempty"`
+	Label         string           `json:"label"`
+	Properties    []PropertyChange `json:"properties,omitempty"`
+	ReplaceReason string           `json:"replace_reason,omitempty"`
+}
+
+// PropertyChange is one property-level difference, keyed by JSON pointer
+// into the resource properties.
+type PropertyChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
 }
 
 // CancelCommandsInput is the input for the cancel_commands tool.
@@ -63,6 +203,67 @@ type ListChangesSinceLastReconcileInput struct {
 	Profile string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
 }
 
+// DiffResourceDriftInput is the input for the diff_resource_drift tool.
+type DiffResourceDriftInput struct {
+	Stack   string `json:"stack" jsonschema:"required,Label of the stack whose modified resources to diff."`
+	Profile string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+}
+
+// DiffResourceDriftOutput is the property-level drift for one stack.
+type DiffResourceDriftOutput struct {
+	Stack     string              `json:"stack"`
+	Resources []ResourceDriftDiff `json:"resources"`
+}
+
+// ResourceDriftDiff compares one modified resource's last applied desired
+// properties (old) with its current synced properties (new).
+type ResourceDriftDiff struct {
+	Type        string           `json:"type"`
+	Label       string           `json:"label"`
+	Operation   string           `json:"operation"`
+	DesiredFrom string           `json:"desired_from,omitempty"`
+	Changes     []PropertyChange `json:"changes,omitempty"`
+	Note        string           `json:"note,omitempty"`
+}
+
+// AbsorbDriftInput is the input for the absorb_drift tool.
+type AbsorbDriftInput struct {
+	Stack     string `json:"stack" jsonschema:"required,Label of the stack whose out-of-band changes should be absorbed into PKL source."`
+	FormaFile string `json:"forma_file,omitempty" jsonschema:"Optional explicit path to the forma file declaring the stack. When omitted the tool searches the workspace using formae eval."`
+	Profile   string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+}
+
+// AbsorbDriftOutput is the edit plan for absorbing a stack's drift.
+type AbsorbDriftOutput struct {
+	Stack     string               `json:"stack"`
+	Resources []ResourceAbsorbPlan `json:"resources"`
+	Notes     []string             `json:"notes,omitempty"`
+}
+
+// ResourceAbsorbPlan locates one drifted resource's block in PKL source and
+// lists the edits that bring it in line with the current cloud state.
+type ResourceAbsorbPlan struct {
+	Type       string         `json:"type"`
+	Label      string         `json:"label"`
+	FilePath   string         `json:"file_path,omitempty"`
+	BlockStart int            `json:"block_start,omitempty"`
+	BlockEnd   int            `json:"block_end,omitempty"`
+	Edits      []PropertyEdit `json:"edits,omitempty"`
+	Notes      []string       `json:"notes,omitempty"`
+}
+
+// PropertyEdit is one anchored edit to a resource block, in the same
+// start/end-line form as create_inline_policy.
+type PropertyEdit struct {
+	Property             string   `json:"property,omitempty"`
+	Pointers             []string `json:"pointers,omitempty"`
+	Operation            string   `json:"operation"`
+	PKLSnippet           string   `json:"pkl_snippet,omitempty"`
+	InsertionAnchorStart int      `json:"insertion_anchor_start"`
+	InsertionAnchorEnd   int      `json:"insertion_anchor_end"`
+	ExistingSnippet      string   `json:"existing_snippet,omitempty"`
+}
+
 // ExtractResourcesInput is the input for the extract_resources tool.
 type ExtractResourcesInput struct {
 	Query   string `json:"query" jsonschema:"required,Bluge query string to select resources for extraction. Examples: 'managed:false type:AWS::S3::Bucket', 'managed:false stack:production'. Must include at least one filter to avoid extracting all resources."`
@@ -71,8 +272,9 @@ type ExtractResourcesInput struct {
 
 // ForceReconcileStackInput is the input for the force_reconcile_stack tool.
 type ForceReconcileStackInput struct {
-	Stack   string `json:"stack" jsonschema:"required,The label of the stack to force-reconcile. The stack must have an auto-reconcile policy attached."`
-	Profile string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	Stack              string `json:"stack" jsonschema:"required,The label of the stack to force-reconcile. The stack must have an auto-reconcile policy attached."`
+	Profile            string `json:"profile,omitempty" jsonschema:"Preferred way to target a named formae environment/agent for THIS call only, without changing global state. Use this in preference to use_profile for per-session targeting: the active profile is global and shared with the user's CLI and any other concurrent sessions, so switching it can hijack work elsewhere. Leave empty to use the active profile. See list_profiles for names. Requires formae >= 0.87.0."`
+	OverrideProtection bool   `json:"override_protection,omitempty" jsonschema:"Proceed even though the call touches profiles, stacks or resources protected by the server's guardrail config. Set only after the user has explicitly approved it for this call; honoured only when the config allows overrides."`
 }
 
 // CreateInlinePolicyInput is the input for the create_inline_policy tool.